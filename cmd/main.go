@@ -3,19 +3,31 @@ package main
 import (
 	"log"
 	"net/http"
+	"os"
+	"time"
 
 	"personnel-api/pkg/api/create"
 	"personnel-api/pkg/api/delete"
 	"personnel-api/pkg/api/read"
 	"personnel-api/pkg/api/update"
+	"personnel-api/pkg/audit"
 	"personnel-api/pkg/authorization"
+	"personnel-api/pkg/cache"
+	"personnel-api/pkg/history"
 	"personnel-api/pkg/middleware"
+	"personnel-api/pkg/router"
+	"personnel-api/pkg/schema"
+	"personnel-api/pkg/svc"
+	"personnel-api/pkg/trash"
 
 	"github.com/casbin/casbin/v2"
 	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
 )
 
-var enforcer *casbin.Enforcer
+var (
+	enforcer *casbin.SyncedEnforcer
+	keyStore authorization.KeyStore
+)
 
 func main() {
 	model := "model.conf"
@@ -27,17 +39,69 @@ func main() {
 	}
 
 	var err error
-	enforcer, err = casbin.NewEnforcer(model, adapter)
+	enforcer, err = casbin.NewSyncedEnforcer(model, adapter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	authorization.SetEnforcer(enforcer)
+
+	keyFile := os.Getenv("API_KEYS_FILE")
+	if keyFile == "" {
+		keyFile = "api_keys.csv"
+	}
+	keyStore, err = authorization.NewFileKeyStore(keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	backend, err := svc.NewBackendFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	svc.SetBackend(backend)
+
+	sheetCache, err := cache.NewFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache.SetDefault(sheetCache)
+
+	schemas, err := schema.NewStoreFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	schema.SetDefault(schemas)
+
+	changes, err := history.NewStoreFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	history.SetDefault(changes)
+
+	auditLog, err := audit.NewLogFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	audit.SetDefault(auditLog)
+
+	trashStore, err := trash.NewStoreFromEnv()
 	if err != nil {
 		log.Fatal(err)
 	}
+	trash.SetDefault(trashStore)
+	trash.StartPurger(time.Hour)
 
 	// Register routes
+	registerV1Routes()
 	registerReadRoutes()
 	registerCreateRoutes()
 	registerUpdateRoutes()
 	registerDeleteRoutes()
 	registerAuthRoutes()
+	registerSchemaRoutes()
+	registerHistoryRoutes()
+	registerAuditRoutes()
+	registerTrashRoutes()
 
 	log.Println("Server started on http://localhost:8080")
 	log.Fatal(http.ListenAndServe(":8080", nil))
@@ -54,9 +118,45 @@ func registerReadRoutes() {
 		"/GetSpreadsheetById":  read.GetSpreadsheetById,
 	}
 
+	// The read routes above are kept as aliases of the /v1 routes
+	readSuccessors := map[string]string{
+		"/GetAll":              "/v1/spreadsheets/{spreadsheetID}/values",
+		"/GetSheetData":        "/v1/spreadsheets/{spreadsheetID}/sheets/{sheetName}/rows",
+		"/GetByColumn":         "/v1/spreadsheets/{spreadsheetID}/sheets/{sheetName}/rows?column=COLUMN_NAME",
+		"/GetByFilter":         "/v1/spreadsheets/{spreadsheetID}/sheets/{sheetName}/rows?filter=EXPRESSION",
+		"/GetSheets":           "/v1/spreadsheets/{spreadsheetID}/sheets",
+		"/ListAllSpreadsheets": "/v1/spreadsheets",
+		"/GetSpreadsheetById":  "/v1/spreadsheets/{spreadsheetID}",
+	}
+
 	for path, handler := range readRoutes {
-		http.HandleFunc(path, middleware.EnableCORS(middleware.Authorize(enforcer)(handler)))
+		handler = middleware.Deprecated(readSuccessors[path])(middleware.BypassCache(handler))
+		http.HandleFunc(path, middleware.EnableCORS(middleware.Audit(keyStore)(middleware.Authorize(enforcer, keyStore)(handler))))
+	}
+}
+
+func registerV1Routes() {
+	v1Routes := []struct {
+		method  string
+		pattern string
+		handler http.HandlerFunc
+	}{
+		{http.MethodGet, "/v1/spreadsheets", read.ListAllSpreadsheets},
+		{http.MethodGet, "/v1/spreadsheets/{spreadsheetID}", read.GetSpreadsheetById},
+		{http.MethodGet, "/v1/spreadsheets/{spreadsheetID}/values", read.GetAll},
+		{http.MethodGet, "/v1/spreadsheets/{spreadsheetID}/sheets", read.GetSheets},
+		{http.MethodGet, "/v1/spreadsheets/{spreadsheetID}/sheets/{sheetName}/rows", read.GetRows},
+		{http.MethodGet, "/v1/spreadsheets/{spreadsheetID}/sheets/{sheetName}/export", read.ExportSheet},
+		{http.MethodGet, "/v1/cache/stats", read.GetCacheStats},
+		{http.MethodGet, "/v1/backend/stats", read.GetBackendStats},
+	}
+
+	v1 := router.New()
+	for _, route := range v1Routes {
+		v1.HandleFunc(route.method, route.pattern, middleware.Authorize(enforcer, keyStore)(middleware.BypassCache(route.handler)))
 	}
+	// audited around the router, so calls matching no route are logged too
+	http.HandleFunc("/v1/", middleware.EnableCORS(middleware.Audit(keyStore)(v1.ServeHTTP)))
 }
 
 func registerCreateRoutes() {
@@ -64,10 +164,11 @@ func registerCreateRoutes() {
 		"/CreateData":        create.CreateData,
 		"/CreateSpreadsheet": create.CreateSpreadsheet,
 		"/CreateSheet":       create.CreateSheet,
+		"/ImportCSV":         create.ImportCSV,
 	}
 
 	for path, handler := range createRoutes {
-		http.HandleFunc(path, middleware.EnableCORS(middleware.Authorize(enforcer)(handler)))
+		http.HandleFunc(path, middleware.EnableCORS(middleware.Audit(keyStore)(middleware.Authorize(enforcer, keyStore)(middleware.History(middleware.IfMatch(handler))))))
 	}
 }
 
@@ -75,12 +176,14 @@ func registerUpdateRoutes() {
 	updateRoutes := map[string]http.HandlerFunc{
 		"/UpdateDataRow":     update.UpdateDataRow,
 		"/UpdateDataCell":    update.UpdateDataCell,
+		"/UpdateByKey":       update.UpdateByKey,
+		"/Upsert":            update.Upsert,
 		"/UpdateSpreadsheet": update.UpdateSpreadsheet,
 		"/UpdateSheet":       update.UpdateSheet,
 	}
 
 	for path, handler := range updateRoutes {
-		http.HandleFunc(path, middleware.EnableCORS(middleware.Authorize(enforcer)(handler)))
+		http.HandleFunc(path, middleware.EnableCORS(middleware.Audit(keyStore)(middleware.Authorize(enforcer, keyStore)(middleware.History(middleware.IfMatch(handler))))))
 	}
 }
 
@@ -88,12 +191,13 @@ func registerDeleteRoutes() {
 	deleteRoutes := map[string]http.HandlerFunc{
 		"/DeleteDataRow":     delete.DeleteDataRow,
 		"/DeleteDataCell":    delete.DeleteDataCell,
+		"/DeleteByKey":       delete.DeleteByKey,
 		"/DeleteSpreadsheet": delete.DeleteSpreadsheet,
 		"/DeleteSheet":       delete.DeleteSheet,
 	}
 
 	for path, handler := range deleteRoutes {
-		http.HandleFunc(path, middleware.EnableCORS(middleware.Authorize(enforcer)(handler)))
+		http.HandleFunc(path, middleware.EnableCORS(middleware.Audit(keyStore)(middleware.Authorize(enforcer, keyStore)(middleware.History(middleware.IfMatch(handler))))))
 	}
 }
 
@@ -101,9 +205,50 @@ func registerAuthRoutes() {
 	authRoutes := map[string]http.HandlerFunc{
 		"/AddPolicy":    authorization.AddPolicy,
 		"/RemovePolicy": authorization.RemovePolicy,
+		"/ListPolicies": authorization.ListPolicies,
 	}
 
 	for path, handler := range authRoutes {
-		http.HandleFunc(path, middleware.EnableCORS(middleware.Authorize(enforcer)(handler)))
+		http.HandleFunc(path, middleware.EnableCORS(middleware.Audit(keyStore)(middleware.Authorize(enforcer, keyStore)(handler))))
+	}
+}
+
+func registerSchemaRoutes() {
+	schemaRoutes := map[string]http.HandlerFunc{
+		"/SetSchema":    schema.SetSchema,
+		"/GetSchema":    schema.GetSchema,
+		"/DeleteSchema": schema.DeleteSchema,
+	}
+
+	for path, handler := range schemaRoutes {
+		http.HandleFunc(path, middleware.EnableCORS(middleware.Audit(keyStore)(middleware.Authorize(enforcer, keyStore)(handler))))
+	}
+}
+
+func registerHistoryRoutes() {
+	historyRoutes := map[string]http.HandlerFunc{
+		"/ListChanges":  history.ListChanges,
+		"/RevertChange": history.RevertChange,
+		"/RestoreRange": history.RestoreRange,
+	}
+
+	for path, handler := range historyRoutes {
+		http.HandleFunc(path, middleware.EnableCORS(middleware.Audit(keyStore)(middleware.Authorize(enforcer, keyStore)(handler))))
+	}
+}
+
+func registerAuditRoutes() {
+	http.HandleFunc("/QueryAudit", middleware.EnableCORS(middleware.Audit(keyStore)(middleware.Authorize(enforcer, keyStore)(audit.QueryAudit))))
+}
+
+func registerTrashRoutes() {
+	trashRoutes := map[string]http.HandlerFunc{
+		"/ListTrash":    trash.ListTrash,
+		"/RestoreTrash": trash.RestoreTrash,
+		"/PurgeTrash":   trash.PurgeTrash,
+	}
+
+	for path, handler := range trashRoutes {
+		http.HandleFunc(path, middleware.EnableCORS(middleware.Audit(keyStore)(middleware.Authorize(enforcer, keyStore)(handler))))
 	}
 }
@@ -0,0 +1,64 @@
+// Package a1 converts between column and row indexes and the A1 notation
+// of Google Sheets ranges, such as 'Sheet 1'!B3:D10.
+package a1
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var cellPattern = regexp.MustCompile(`^([A-Za-z]*)([0-9]*)$`)
+
+// ColumnLetter converts a zero based column index to its letters, e.g. 0 to
+// A, 25 to Z and 26 to AA.
+func ColumnLetter(index int) string {
+	result := ""
+	for index++; index > 0; index /= 26 {
+		index--
+		result = string(rune('A'+index%26)) + result
+	}
+	return result
+}
+
+// ParseCell returns the zero based column and row of a cell reference such
+// as B3. A reference may omit its column, as in 3, or its row, as in B; the
+// part omitted is -1.
+func ParseCell(ref string) (int, int, bool) {
+	match := cellPattern.FindStringSubmatch(ref)
+	if match == nil || (match[1] == "" && match[2] == "") {
+		return 0, 0, false
+	}
+
+	col, row := -1, -1
+	if match[1] != "" {
+		col = 0
+		for _, c := range strings.ToUpper(match[1]) {
+			col = col*26 + int(c-'A'+1)
+		}
+		col--
+	}
+	if match[2] != "" {
+		n, err := strconv.Atoi(match[2])
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		row = n - 1
+	}
+	return col, row, true
+}
+
+// QuoteSheetName quotes a sheet name for a range, so names with spaces, ! or
+// ' can be used.
+func QuoteSheetName(name string) string {
+	return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+}
+
+// UnquoteSheetName removes the quotes QuoteSheetName adds. Names that are not
+// quoted are returned as they are.
+func UnquoteSheetName(name string) string {
+	if len(name) >= 2 && strings.HasPrefix(name, "'") && strings.HasSuffix(name, "'") {
+		return strings.ReplaceAll(name[1:len(name)-1], "''", "'")
+	}
+	return name
+}
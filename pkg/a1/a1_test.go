@@ -0,0 +1,50 @@
+package a1
+
+import "testing"
+
+func TestColumnLetter(t *testing.T) {
+	cases := map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"}
+	for index, expected := range cases {
+		if got := ColumnLetter(index); got != expected {
+			t.Errorf("ColumnLetter(%d) = %q, expected %q", index, got, expected)
+		}
+		if col, _, ok := ParseCell(expected); !ok || col != index {
+			t.Errorf("ParseCell(%q) = %d, expected %d", expected, col, index)
+		}
+	}
+}
+
+func TestParseCell(t *testing.T) {
+	cases := []struct {
+		ref      string
+		col, row int
+	}{
+		{"B3", 1, 2},
+		{"aa10", 26, 9},
+		{"C", 2, -1},
+		{"7", -1, 6},
+	}
+	for _, c := range cases {
+		col, row, ok := ParseCell(c.ref)
+		if !ok || col != c.col || row != c.row {
+			t.Errorf("ParseCell(%q) = %d, %d, %v, expected %d, %d", c.ref, col, row, ok, c.col, c.row)
+		}
+	}
+	for _, ref := range []string{"", "B0", "3B", "B-1", "B 3"} {
+		if _, _, ok := ParseCell(ref); ok {
+			t.Errorf("ParseCell(%q) expected to fail", ref)
+		}
+	}
+}
+
+func TestQuoteSheetName(t *testing.T) {
+	for _, name := range []string{"Sheet1", "Q1 'final'!", "''"} {
+		quoted := QuoteSheetName(name)
+		if got := UnquoteSheetName(quoted); got != name {
+			t.Errorf("UnquoteSheetName(%q) = %q, expected %q", quoted, got, name)
+		}
+	}
+	if got := QuoteSheetName("Q1 'final'"); got != "'Q1 ''final'''" {
+		t.Errorf("Unexpected quoted name %q", got)
+	}
+}
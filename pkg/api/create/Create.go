@@ -6,17 +6,16 @@ import (
 	"io"
 	"net/http"
 
+	"personnel-api/pkg/a1"
 	"personnel-api/pkg/api/read"
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/cache"
+	"personnel-api/pkg/history"
 	"personnel-api/pkg/svc"
 
 	"google.golang.org/api/sheets/v4"
 )
 
-/*
-POST
-Body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetName": "SHEET_NAME", "rows":[ ["3", "test1", "test1@gmail.com"], ["4", "test2", "test2@gmail.com"] ]}
-*/
-
 /*
 POST
 Body: {"title": "Spreadsheet Title"}
@@ -24,7 +23,7 @@ Body: {"title": "Spreadsheet Title"}
 func CreateSpreadsheet(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
@@ -34,7 +33,7 @@ func CreateSpreadsheet(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
 		return
 	}
 
@@ -45,7 +44,7 @@ func CreateSpreadsheet(w http.ResponseWriter, r *http.Request) {
 
 	spreadsheetID, err := CreateSpreadsheetHelper(title)
 	if err != nil {
-		http.Error(w, "Cannot create new spreadsheet: "+err.Error(), http.StatusInternalServerError)
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Cannot create new spreadsheet")
 		return
 	}
 
@@ -62,11 +61,6 @@ func CreateSpreadsheet(w http.ResponseWriter, r *http.Request) {
 }
 
 func CreateSpreadsheetHelper(title string) (string, error) {
-	service, err := svc.SetupGoogleSheetsService()
-	if err != nil {
-		return "", err
-	}
-
 	spreadsheet := &sheets.Spreadsheet{
 		Properties: &sheets.SpreadsheetProperties{
 			Title: title,
@@ -80,7 +74,7 @@ func CreateSpreadsheetHelper(title string) (string, error) {
 		},
 	}
 
-	createdSpreadsheet, err := service.Spreadsheets.Create(spreadsheet).Do()
+	createdSpreadsheet, err := svc.GetBackend().CreateSpreadsheet(spreadsheet)
 	if err != nil {
 		return "", err
 	}
@@ -88,50 +82,75 @@ func CreateSpreadsheetHelper(title string) (string, error) {
 	return createdSpreadsheet.SpreadsheetId, nil
 }
 
-// check for valid length of input not included (each data in rows has to match what is in the sheet)
+/*
+POST
+Body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetName": "SHEET_NAME", "rows":[ ["3", "test1", "test1@gmail.com"], ["4", "test2", "test2@gmail.com"] ]}
+or:   {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetName": "SHEET_NAME", "records":[ {"ID": "3", "Name": "test1", "Email": "test1@gmail.com"} ]}
+Rows are appended unless a position is given:
+"position": {"before": 3} | {"after": 3} | {"beforeKey": {"column": "ID", "value": "3"}}
+Rows are checked against the sheet's schema, if one is registered.
+*/
 func CreateData(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
 	var req struct {
-		SpreadsheetID string          `json:"spreadsheetID"`
-		SheetName     string          `json:"sheetName"`
-		Rows          [][]interface{} `json:"rows"`
+		SpreadsheetID string                   `json:"spreadsheetID"`
+		SheetName     string                   `json:"sheetName"`
+		Rows          [][]interface{}          `json:"rows"`
+		Records       []map[string]interface{} `json:"records"`
+		Position      *Position                `json:"position"`
 	}
 
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
 		return
 	}
 
 	spreadsheetID := req.SpreadsheetID
 	if spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
 	sheetName := req.SheetName
 	if sheetName == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
-	dataRange, _, _ := read.GetSheetDataHelper(spreadsheetID, sheetName)
+	dataRange, sheetData, _ := read.GetSheetDataHelper(spreadsheetID, sheetName)
 	dataRange = sheetName + "!" + dataRange
 
 	rows := req.Rows
+	if len(rows) == 0 && len(req.Records) > 0 {
+		rows, err = RecordsToRowsHelper(sheetData, req.Records)
+		if err != nil {
+			apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Cannot convert records")
+			return
+		}
+	}
 	if len(rows) == 0 {
-		http.Error(w, "rows data field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "rows or records data field is required")
 		return
 	}
 
-	err = CreateDataHelper(spreadsheetID, dataRange, rows)
+	if err := read.ValidateRows(spreadsheetID, sheetName, nil, nil, rows); err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid rows")
+		return
+	}
+
+	if req.Position != nil {
+		_, err = InsertDataHelper(spreadsheetID, sheetName, *req.Position, rows)
+	} else {
+		err = CreateDataHelper(spreadsheetID, dataRange, rows)
+	}
 	if err != nil {
-		http.Error(w, "Cannot create new rows in sheet", http.StatusBadRequest)
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Cannot create new rows in sheet")
 		return
 	}
 
@@ -139,36 +158,172 @@ func CreateData(w http.ResponseWriter, r *http.Request) {
 }
 
 func CreateDataHelper(spreadsheetID string, dataRange string, rows [][]interface{}) error {
-	service, err := svc.SetupGoogleSheetsService()
-	if err != nil {
-		return err
-	}
+	defer cache.Default().InvalidateRange(spreadsheetID, dataRange)
 
 	valueRange := &sheets.ValueRange{
 		Values: rows,
 	}
 
-	_, err = service.Spreadsheets.Values.Append(spreadsheetID, dataRange, valueRange).ValueInputOption("USER_ENTERED").Do()
+	resp, err := svc.GetBackend().AppendValues(spreadsheetID, dataRange, valueRange)
 	if err != nil {
 		return err
 	}
+	if resp.Updates != nil {
+		history.Appended(spreadsheetID, resp.Updates.UpdatedRange, rows)
+	}
 
 	return nil
 }
 
+// Position places inserted rows relative to a sheet row number or to the
+// first row whose key column holds a value. Exactly one field must be set.
+type Position struct {
+	Before    int       `json:"before"`
+	After     int       `json:"after"`
+	BeforeKey *KeyMatch `json:"beforeKey"`
+}
+
+type KeyMatch struct {
+	Column string      `json:"column"`
+	Value  interface{} `json:"value"`
+}
+
+// InsertDataHelper inserts empty rows at the requested position, shifting
+// the rows below down, and writes rows into them. It returns the range
+// written. If the write fails the inserted rows are removed again.
+func InsertDataHelper(spreadsheetID string, sheetName string, position Position, rows [][]interface{}) (string, error) {
+	defer cache.Default().Invalidate(spreadsheetID, sheetName)
+
+	table, err := read.GetTableHelper(spreadsheetID, sheetName)
+	if err != nil {
+		return "", err
+	}
+	if table == nil {
+		return "", apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "cannot insert rows into a sheet without a header row")
+	}
+
+	at, err := resolvePosition(table, position)
+	if err != nil {
+		return "", err
+	}
+
+	sheetID, err := read.SheetID(spreadsheetID, sheetName)
+	if err != nil {
+		return "", err
+	}
+
+	// rows inserted right below the header take the formatting of the data
+	// row after them rather than the header's
+	dimension := &sheets.DimensionRange{
+		SheetId:    sheetID,
+		Dimension:  "ROWS",
+		StartIndex: int64(at - 1),
+		EndIndex:   int64(at - 1 + len(rows)),
+	}
+	edit := history.NewRowsEdit(spreadsheetID, sheetName, at)
+	_, err = svc.GetBackend().BatchUpdate(spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{InsertDimension: &sheets.InsertDimensionRequest{
+			Range:             dimension,
+			InheritFromBefore: at-1 > table.FirstRow,
+		}}},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	width := len(table.Rows[0])
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+	writeRange := fmt.Sprintf("%s!%s%d:%s%d", sheetName,
+		a1.ColumnLetter(table.FirstColumn), at,
+		a1.ColumnLetter(table.FirstColumn+width-1), at+len(rows)-1)
+
+	_, err = svc.GetBackend().UpdateValues(spreadsheetID, writeRange, &sheets.ValueRange{Values: rows})
+	if err != nil {
+		svc.GetBackend().BatchUpdate(spreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+			Requests: []*sheets.Request{{DeleteDimension: &sheets.DeleteDimensionRequest{Range: dimension}}},
+		})
+		return "", err
+	}
+	edit.InsertRows(at, len(rows))
+	edit.Write(writeRange, rows)
+	edit.Done()
+
+	return writeRange, nil
+}
+
+// resolvePosition returns the sheet row number the first inserted row will
+// take. Rows can go anywhere from just below the header to just below the
+// last row of data.
+func resolvePosition(table *read.Table, position Position) (int, error) {
+	set := 0
+	for _, ok := range []bool{position.Before != 0, position.After != 0, position.BeforeKey != nil} {
+		if ok {
+			set++
+		}
+	}
+	if set != 1 {
+		return 0, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "position must have exactly one of before, after or beforeKey")
+	}
+
+	var at int
+	switch {
+	case position.Before != 0:
+		at = position.Before
+	case position.After != 0:
+		at = position.After + 1
+	default:
+		matches, err := table.MatchRows(position.BeforeKey.Column, position.BeforeKey.Value)
+		if err != nil {
+			return 0, err
+		}
+		if len(matches) == 0 {
+			return 0, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "no row found with %v = %v", position.BeforeKey.Column, position.BeforeKey.Value)
+		}
+		return table.RowNumber(matches[0]), nil
+	}
+
+	if at <= table.FirstRow || at > table.LastRow()+1 {
+		return 0, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRange, "position must be between rows %d and %d", table.FirstRow+1, table.LastRow()+1)
+	}
+	return at, nil
+}
+
+// RecordsToRowsHelper orders the values of each record by the sheet's header row.
+func RecordsToRowsHelper(sheetData []interface{}, records []map[string]interface{}) ([][]interface{}, error) {
+	header, err := read.Header(sheetData)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows [][]interface{}
+	for _, record := range records {
+		row, err := read.FromRecord(header, record, nil)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
 /*
 POST
 Body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetName": "NEW_SHEET_NAME"}
 */
 func CreateSheet(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
@@ -179,25 +334,25 @@ func CreateSheet(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
 		return
 	}
 
 	spreadsheetID := req.SpreadsheetID
 	if spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
 	sheetName := req.SheetName
 	if sheetName == "" {
-		http.Error(w, "sheetName field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "sheetName field is required")
 		return
 	}
 
 	err = CreateSheetHelper(spreadsheetID, sheetName)
 	if err != nil {
-		http.Error(w, "Cannot create new sheet: "+err.Error(), http.StatusInternalServerError)
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Cannot create new sheet")
 		return
 	}
 
@@ -216,11 +371,6 @@ func CreateSheet(w http.ResponseWriter, r *http.Request) {
 }
 
 func CreateSheetHelper(spreadsheetID string, sheetName string) error {
-	service, err := svc.SetupGoogleSheetsService()
-	if err != nil {
-		return err
-	}
-
 	req := &sheets.BatchUpdateSpreadsheetRequest{
 		Requests: []*sheets.Request{
 			{
@@ -233,7 +383,7 @@ func CreateSheetHelper(spreadsheetID string, sheetName string) error {
 		},
 	}
 
-	_, err = service.Spreadsheets.BatchUpdate(spreadsheetID, req).Do()
+	_, err := svc.GetBackend().BatchUpdate(spreadsheetID, req)
 	if err != nil {
 		return err
 	}
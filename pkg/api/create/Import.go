@@ -0,0 +1,577 @@
+package create
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"personnel-api/pkg/a1"
+	"personnel-api/pkg/api/read"
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/cache"
+	"personnel-api/pkg/history"
+	"personnel-api/pkg/schema"
+	"personnel-api/pkg/svc"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// ImportBatchSize is the number of rows sent to Google per append.
+const ImportBatchSize = 500
+
+// MaxImportBytes caps the size of an uploaded file.
+const MaxImportBytes = 50 << 20
+
+const (
+	ImportAppend  = "append"
+	ImportReplace = "replace"
+)
+
+// ImportOptions controls how ImportCSVHelper reads the file and writes it.
+type ImportOptions struct {
+	Delimiter rune
+	Header    bool
+	Mode      string
+	DryRun    bool
+}
+
+// LineError is a CSV line that was not imported.
+type LineError struct {
+	Line    int    `json:"line"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportResult reports what an import wrote, or would write on a dry run.
+type ImportResult struct {
+	Imported int         `json:"imported"`
+	Skipped  int         `json:"skipped"`
+	Errors   []LineError `json:"errors"`
+}
+
+/*
+POST
+Query params: spreadsheetID=YOUR_SPREAD_SHEET_ID&sheetName=SHEET_NAME&delimiter=,&header=true&mode=append&dryRun=false
+Body: the file as text/csv, or a multipart/form-data upload with the file in a "file" field.
+With header=true the first line names the sheet columns each field goes to.
+mode=replace clears the data rows below the sheet header before importing, once the whole file has been checked.
+Errors carry the imported and skipped counts and the line errors so far in their details.
+Uploads larger than 50 MB are refused with 413.
+*/
+func ImportCSV(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	spreadsheetID := query.Get("spreadsheetID")
+	sheetName := query.Get("sheetName")
+	if spreadsheetID == "" || sheetName == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID and sheetName query parameters are required")
+		return
+	}
+
+	options, err := importOptions(query)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid import options")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MaxImportBytes)
+	file, err := importFile(r)
+	if err != nil {
+		apierror.WriteError(w, importError(err, nil))
+		return
+	}
+
+	result, err := ImportCSVHelper(spreadsheetID, sheetName, file, options)
+	if err != nil {
+		apierror.WriteError(w, importError(err, result))
+		return
+	}
+
+	response := struct {
+		SpreadsheetID string `json:"spreadsheetID"`
+		SheetName     string `json:"sheetName"`
+		Mode          string `json:"mode"`
+		DryRun        bool   `json:"dryRun"`
+		Message       string `json:"message"`
+		*ImportResult
+	}{
+		SpreadsheetID: spreadsheetID,
+		SheetName:     sheetName,
+		Mode:          options.Mode,
+		DryRun:        options.DryRun,
+		Message:       "Import successfully!",
+		ImportResult:  result,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// importError reports a failed import with the counts of rows already
+// imported and skipped, so the caller knows what was written.
+func importError(err error, result *ImportResult) *apierror.Error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		err = apierror.New(http.StatusRequestEntityTooLarge, apierror.CodeInvalidRequest, "upload is larger than %d bytes", tooLarge.Limit)
+	}
+	e := apierror.From(err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Cannot import CSV")
+	if result == nil {
+		return e
+	}
+
+	details := map[string]interface{}{}
+	if previous, ok := e.Details.(map[string]interface{}); ok {
+		for key, value := range previous {
+			details[key] = value
+		}
+	}
+	details["imported"] = result.Imported
+	details["skipped"] = result.Skipped
+	details["errors"] = result.Errors
+	e.Details = details
+	return e
+}
+
+func importOptions(query url.Values) (ImportOptions, error) {
+	options := ImportOptions{Delimiter: ',', Header: true, Mode: ImportAppend}
+
+	switch delimiter := query.Get("delimiter"); delimiter {
+	case "":
+	case "tab", `\t`:
+		options.Delimiter = '\t'
+	default:
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' || r == utf8.RuneError {
+			return options, fmt.Errorf("delimiter must be a single character other than a quote or newline")
+		}
+		options.Delimiter = r
+	}
+
+	for name, target := range map[string]*bool{"header": &options.Header, "dryRun": &options.DryRun} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return options, fmt.Errorf("%s must be true or false", name)
+			}
+			*target = parsed
+		}
+	}
+
+	switch mode := query.Get("mode"); mode {
+	case "", ImportAppend:
+	case ImportReplace:
+		options.Mode = ImportReplace
+	default:
+		return options, fmt.Errorf("mode must be %q or %q", ImportAppend, ImportReplace)
+	}
+
+	return options, nil
+}
+
+// importFile returns the uploaded file without reading it into memory: the
+// "file" part of a multipart upload or else the request body.
+func importFile(r *http.Request) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return r.Body, nil
+	}
+
+	parts, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			return nil, errors.New(`multipart upload has no "file" field`)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// ImportCSVHelper reads a CSV file into a sheet, appending rows in batches of
+// ImportBatchSize as the file is read. Lines that cannot be parsed, do not fit
+// the sheet or break its schema are skipped and reported; a header naming a
+// column the sheet lacks fails the whole import. An empty sheet gets the CSV
+// header as its header row. Nothing is written on a dry run.
+//
+// In replace mode the rows that pass are staged in a temporary file until the
+// whole file has been read and checked, and the sheet is only cleared then,
+// before the staged rows are appended batch by batch; if no line passes, the
+// sheet is left as it was. When an error occurs after rows were read, the
+// result so far is returned with it.
+//
+// Only one batch is held in memory at a time, except that the rows imported
+// so far are kept for the unique checks of a sheet with a schema.
+func ImportCSVHelper(spreadsheetID string, sheetName string, file io.Reader, options ImportOptions) (*ImportResult, error) {
+	defer cache.Default().Invalidate(spreadsheetID, sheetName)
+
+	table, err := read.GetTableHelper(spreadsheetID, sheetName)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := csv.NewReader(file)
+	reader.Comma = options.Delimiter
+	reader.FieldsPerRecord = -1
+
+	result := &ImportResult{Errors: []LineError{}}
+
+	// columns maps each CSV field to a sheet column, or is nil for fields
+	// taken in sheet order
+	var columns []int
+	if options.Header {
+		header, err := reader.Read()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "cannot read CSV header: %v", err)
+		}
+
+		if table == nil {
+			headerRow := make([]interface{}, len(header))
+			for i, name := range header {
+				headerRow[i] = strings.TrimSpace(name)
+			}
+			table = &read.Table{FirstRow: 1, Rows: [][]interface{}{headerRow}}
+			if !options.DryRun {
+				headerRange := fmt.Sprintf("%s!A1:%s1", sheetName, a1.ColumnLetter(len(headerRow)-1))
+				edit := history.NewEdit(spreadsheetID, sheetName, headerRange)
+				if _, err := svc.GetBackend().UpdateValues(spreadsheetID, headerRange, &sheets.ValueRange{Values: table.Rows}); err != nil {
+					return nil, err
+				}
+				edit.Write(headerRange, table.Rows)
+				edit.Done()
+			}
+		}
+
+		if columns, err = mapColumns(table.Rows[0], header); err != nil {
+			return nil, err
+		}
+	}
+
+	// rows are checked against the header alone in replace mode, as the data
+	// rows below it are cleared before the new ones are written
+	clearRange := ""
+	if table != nil && options.Mode == ImportReplace {
+		if len(table.Rows) > 1 {
+			clearRange = fmt.Sprintf("%s!%s%d:%s", sheetName,
+				a1.ColumnLetter(table.FirstColumn), table.FirstRow+1,
+				a1.ColumnLetter(table.FirstColumn+len(table.Rows[0])-1))
+		}
+		table = &read.Table{FirstRow: table.FirstRow, FirstColumn: table.FirstColumn, Rows: table.Rows[:1]}
+	}
+
+	appendRange := sheetName + "!A1"
+	width := 0
+	if table != nil {
+		appendRange = sheetName + "!" + table.ColumnRange()
+		width = len(table.Rows[0])
+	}
+
+	// rows passing the checks of a replace import, with their line numbers
+	var stage *importStage
+	if options.Mode == ImportReplace && !options.DryRun {
+		if stage, err = newImportStage(); err != nil {
+			return nil, err
+		}
+		defer stage.Close()
+	}
+	keepRows := schema.Default().Lookup(spreadsheetID, sheetName) != nil
+	passed := 0
+
+	var batch [][]interface{}
+	var lines []int
+
+	write := func(rows [][]interface{}, rowLines []int) error {
+		if !options.DryRun {
+			resp, err := svc.GetBackend().AppendValues(spreadsheetID, appendRange, &sheets.ValueRange{Values: rows})
+			if err != nil {
+				return fmt.Errorf("lines %d-%d: %w", rowLines[0], rowLines[len(rowLines)-1], err)
+			}
+			if resp.Updates != nil {
+				history.Appended(spreadsheetID, resp.Updates.UpdatedRange, rows)
+			}
+		}
+		result.Imported += len(rows)
+		return nil
+	}
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		rows, rowLines, err := validateImport(spreadsheetID, sheetName, table, batch, lines, result)
+		batch, lines = nil, nil
+		if err != nil || len(rows) == 0 {
+			return err
+		}
+
+		passed += len(rows)
+		if table != nil && keepRows {
+			table.Rows = append(table.Rows, rows...)
+		}
+		if stage != nil {
+			return stage.Write(rows, rowLines)
+		}
+		return write(rows, rowLines)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return result, err
+			}
+			result.skip(LineError{Line: parseErr.StartLine, Message: parseErr.Err.Error()})
+			continue
+		}
+		line, _ := reader.FieldPos(0)
+
+		row, lineErr := importRow(record, columns, width)
+		if lineErr != "" {
+			result.skip(LineError{Line: line, Message: lineErr})
+			continue
+		}
+
+		batch = append(batch, row)
+		lines = append(lines, line)
+		if len(batch) == ImportBatchSize {
+			if err := flush(); err != nil {
+				return result, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return result, err
+	}
+
+	if options.Mode != ImportReplace {
+		return result, nil
+	}
+	if passed == 0 && result.Skipped > 0 {
+		return result, apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "no line can be imported, the sheet was not cleared")
+	}
+	if stage == nil {
+		return result, nil
+	}
+	if err := stage.Rewind(); err != nil {
+		return result, err
+	}
+
+	if clearRange != "" {
+		edit := history.NewEdit(spreadsheetID, sheetName, clearRange)
+		if _, err := svc.GetBackend().ClearValues(spreadsheetID, clearRange); err != nil {
+			return result, err
+		}
+		edit.Clear(clearRange)
+		edit.Done()
+	}
+	for {
+		rows, rowLines, err := stage.Read(ImportBatchSize)
+		if err != nil {
+			return result, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		if err := write(rows, rowLines); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// mapColumns matches the CSV header to the sheet header by name, ignoring
+// case and surrounding spaces.
+func mapColumns(sheetHeader []interface{}, csvHeader []string) ([]int, error) {
+	index := map[string]int{}
+	for i, name := range sheetHeader {
+		index[strings.ToLower(strings.TrimSpace(fmt.Sprint(name)))] = i
+	}
+
+	columns := make([]int, len(csvHeader))
+	seen := map[int]bool{}
+	var unknown []string
+	for i, name := range csvHeader {
+		col, ok := index[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+		if seen[col] {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "CSV header names column %q twice", name)
+		}
+		seen[col] = true
+		columns[i] = col
+	}
+	if len(unknown) > 0 {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeColumnNotFound, "CSV columns not in the sheet header: %s", strings.Join(unknown, ", "))
+	}
+	return columns, nil
+}
+
+// importRow arranges the fields of a record in sheet column order. width is
+// the number of sheet columns, or 0 if the sheet has no header yet.
+func importRow(record []string, columns []int, width int) ([]interface{}, string) {
+	if columns == nil {
+		if width > 0 && len(record) > width {
+			return nil, fmt.Sprintf("line has %d fields but the sheet has %d columns", len(record), width)
+		}
+		row := make([]interface{}, len(record))
+		for i, field := range record {
+			row[i] = field
+		}
+		return row, ""
+	}
+
+	if len(record) > len(columns) {
+		return nil, fmt.Sprintf("line has %d fields but the header has %d", len(record), len(columns))
+	}
+	row := make([]interface{}, width)
+	for i := range row {
+		row[i] = ""
+	}
+	for i, field := range record {
+		row[columns[i]] = field
+	}
+	return row, ""
+}
+
+// validateImport checks a batch against the sheet's schema and returns the
+// rows that pass with their line numbers, recording the others in result.
+func validateImport(spreadsheetID string, sheetName string, table *read.Table, batch [][]interface{}, lines []int, result *ImportResult) ([][]interface{}, []int, error) {
+	err := read.ValidateRows(spreadsheetID, sheetName, table, nil, batch)
+	if err == nil {
+		return batch, lines, nil
+	}
+
+	var apiErr *apierror.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != apierror.CodeValidationFailed {
+		return nil, nil, err
+	}
+	details, _ := apiErr.Details.(map[string]interface{})
+	fields, _ := details["fields"].([]schema.FieldError)
+
+	failed := map[int]bool{}
+	for _, field := range fields {
+		failed[field.Index] = true
+		result.Errors = append(result.Errors, LineError{Line: lines[field.Index], Column: field.Column, Message: field.Message})
+	}
+
+	var rows [][]interface{}
+	var rowLines []int
+	for i, row := range batch {
+		if failed[i] {
+			result.Skipped++
+			continue
+		}
+		rows = append(rows, row)
+		rowLines = append(rowLines, lines[i])
+	}
+	return rows, rowLines, nil
+}
+
+// importStage keeps checked rows in a temporary CSV file, each record being
+// the row's line number followed by its cells.
+type importStage struct {
+	file   *os.File
+	writer *csv.Writer
+	reader *csv.Reader
+}
+
+func newImportStage() (*importStage, error) {
+	file, err := os.CreateTemp("", "import-*.csv")
+	if err != nil {
+		return nil, err
+	}
+	return &importStage{file: file, writer: csv.NewWriter(file)}, nil
+}
+
+// Write adds rows to the end of the file.
+func (s *importStage) Write(rows [][]interface{}, lines []int) error {
+	for i, row := range rows {
+		record := make([]string, 0, len(row)+1)
+		record = append(record, strconv.Itoa(lines[i]))
+		for _, v := range row {
+			record = append(record, fmt.Sprint(v))
+		}
+		if err := s.writer.Write(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rewind finishes writing and goes back to the first row for Read.
+func (s *importStage) Rewind() error {
+	s.writer.Flush()
+	if err := s.writer.Error(); err != nil {
+		return err
+	}
+	if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	s.reader = csv.NewReader(s.file)
+	s.reader.FieldsPerRecord = -1
+	return nil
+}
+
+// Read returns up to n of the next rows with their line numbers, or none
+// once every row has been read.
+func (s *importStage) Read(n int) ([][]interface{}, []int, error) {
+	var rows [][]interface{}
+	var lines []int
+	for len(rows) < n {
+		record, err := s.reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		line, _ := strconv.Atoi(record[0])
+		row := make([]interface{}, len(record)-1)
+		for i, cell := range record[1:] {
+			row[i] = cell
+		}
+		rows = append(rows, row)
+		lines = append(lines, line)
+	}
+	return rows, lines, nil
+}
+
+// Close removes the file.
+func (s *importStage) Close() error {
+	s.file.Close()
+	return os.Remove(s.file.Name())
+}
+
+func (r *ImportResult) skip(lineErr LineError) {
+	r.Errors = append(r.Errors, lineErr)
+	r.Skipped++
+}
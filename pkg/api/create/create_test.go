@@ -3,11 +3,21 @@ package create
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+
+	"personnel-api/pkg/schema"
+	"personnel-api/pkg/svc"
+	"personnel-api/pkg/svc/svctest"
+
+	"google.golang.org/api/sheets/v4"
 )
 
 type errorReader struct{}
@@ -218,3 +228,321 @@ func TestCreateSheet(t *testing.T) {
 		t.Errorf("Expected status code %d but got %d", http.StatusMethodNotAllowed, res_err.Code)
 	}
 }
+
+func TestCreateDataRecords(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+
+	requestBody := []byte(`{
+		"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w",
+		"sheetName": "Sheet1",
+		"records": [{"Email": "test5@gmail.com", "ID": "5", "Name": "test5"}, {"ID": "6"}]
+	}`)
+	res := httptest.NewRecorder()
+	CreateData(res, httptest.NewRequest(http.MethodPost, "/CreateData", bytes.NewReader(requestBody)))
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1!A6:C7")
+	expected := [][]interface{}{{"5", "test5", "test5@gmail.com"}, {"6"}}
+	if !reflect.DeepEqual(values.Values, expected) {
+		t.Errorf("Expected %v but got %v", expected, values.Values)
+	}
+
+	// test error handling
+	for _, body := range []string{
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "records": [{"Phone": "1"}]}`,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Extra", "records": [{"ID": "1"}]}`,
+	} {
+		res = httptest.NewRecorder()
+		CreateData(res, httptest.NewRequest(http.MethodPost, "/CreateData", bytes.NewReader([]byte(body))))
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Body %s: expected status code %d but got %d", body, http.StatusBadRequest, res.Code)
+		}
+	}
+}
+
+func TestCreateDataAtPosition(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+
+	for _, body := range []string{
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "rows": [["a"]], "position": {"before": 3}}`,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "rows": [["z"]], "position": {"after": 6}}`,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "records": [{"ID": "b"}, {"ID": "c"}], "position": {"beforeKey": {"column": "ID", "value": "3"}}}`,
+	} {
+		res := httptest.NewRecorder()
+		CreateData(res, httptest.NewRequest(http.MethodPost, "/CreateData", bytes.NewReader([]byte(body))))
+		if res.Code != http.StatusOK {
+			t.Fatalf("Body %s: expected status code %d but got %d: %s", body, http.StatusOK, res.Code, res.Body.String())
+		}
+	}
+
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1!A1:A9")
+	expected := [][]interface{}{{"ID"}, {"1"}, {"a"}, {"2"}, {"b"}, {"c"}, {"3"}, {"4"}, {"z"}}
+	if !reflect.DeepEqual(values.Values, expected) {
+		t.Errorf("Expected %v but got %v", expected, values.Values)
+	}
+
+	// test error handling
+	for body, status := range map[string]int{
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "rows": [["x"]], "position": {"before": 1}}`:                                  http.StatusBadRequest,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "rows": [["x"]], "position": {"after": 100}}`:                                 http.StatusBadRequest,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "rows": [["x"]], "position": {"before": 2, "after": 2}}`:                      http.StatusBadRequest,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "rows": [["x"]], "position": {"beforeKey": {"column": "ID", "value": "99"}}}`: http.StatusNotFound,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "rows": [["x"]], "position": {"beforeKey": {"column": "Age", "value": "1"}}}`: http.StatusBadRequest,
+	} {
+		res := httptest.NewRecorder()
+		CreateData(res, httptest.NewRequest(http.MethodPost, "/CreateData", bytes.NewReader([]byte(body))))
+		if res.Code != status {
+			t.Errorf("Body %s: expected status code %d but got %d", body, status, res.Code)
+		}
+	}
+
+	values, _ = backend.GetValues(svctest.SpreadsheetID, "Sheet1!A1:A10")
+	if len(values.Values) != 9 {
+		t.Errorf("Expected failed inserts to leave the sheet unchanged but got %v", values.Values)
+	}
+}
+
+func TestCreateDataValidatesSchema(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+
+	previous := schema.Default()
+	store, _ := schema.NewStore("")
+	schema.SetDefault(store)
+	defer schema.SetDefault(previous)
+
+	store.Set(&schema.Schema{
+		SpreadsheetID: svctest.SpreadsheetID,
+		SheetName:     "Sheet1",
+		Columns:       []schema.Column{{Name: "ID", Type: schema.TypeInt, Required: true, Unique: true}},
+	})
+
+	requestBody := []byte(`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "rows": [["5", "test5"], ["4", "test4"], ["6", "test6", "x", "extra"]]}`)
+	res := httptest.NewRecorder()
+	CreateData(res, httptest.NewRequest(http.MethodPost, "/CreateData", bytes.NewReader(requestBody)))
+
+	if res.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusUnprocessableEntity, res.Code, res.Body.String())
+	}
+
+	var response struct {
+		Error struct {
+			Code    string `json:"code"`
+			Details struct {
+				Fields []schema.FieldError `json:"fields"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	fields := response.Error.Details.Fields
+	if response.Error.Code != "VALIDATION_FAILED" || len(fields) != 2 || fields[0].Index != 2 || fields[1].Index != 1 || fields[1].Column != "ID" {
+		t.Errorf("Unexpected response %+v", response)
+	}
+
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1")
+	if len(values.Values) != 5 {
+		t.Errorf("Expected no rows to be written but got %v", values.Values)
+	}
+}
+
+func TestImportCSV(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+
+	previous := schema.Default()
+	store, _ := schema.NewStore("")
+	schema.SetDefault(store)
+	defer schema.SetDefault(previous)
+
+	store.Set(&schema.Schema{
+		SpreadsheetID: svctest.SpreadsheetID,
+		SheetName:     "Sheet1",
+		Columns:       []schema.Column{{Name: "ID", Type: schema.TypeInt, Required: true, Unique: true}},
+	})
+
+	importCSV := func(query string, contentType string, body string) (*httptest.ResponseRecorder, ImportResult) {
+		req := httptest.NewRequest(http.MethodPost, "/ImportCSV?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1&"+query, bytes.NewReader([]byte(body)))
+		req.Header.Set("Content-Type", contentType)
+		res := httptest.NewRecorder()
+		ImportCSV(res, req)
+
+		var result ImportResult
+		json.Unmarshal(res.Body.Bytes(), &result)
+		return res, result
+	}
+	sheetValues := func() [][]interface{} {
+		values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1")
+		return values.Values
+	}
+
+	file := "email;id\n" +
+		"test5@gmail.com;5\n" +
+		"bad;x\n" +
+		"test6@gmail.com;6;extra\n" +
+		"test1@gmail.com;1\n" +
+		"\"test7@gmail.com;7\n"
+
+	res, result := importCSV("delimiter=%3B&dryRun=true", "text/csv", file)
+	if res.Code != http.StatusOK || result.Imported != 1 || result.Skipped != 4 {
+		t.Fatalf("Unexpected dry run result %d %s", res.Code, res.Body.String())
+	}
+	lines := []int{}
+	for _, e := range result.Errors {
+		lines = append(lines, e.Line)
+	}
+	if !reflect.DeepEqual(lines, []int{4, 6, 3, 5}) {
+		t.Errorf("Expected errors on lines 4, 6, 3 and 5 but got %+v", result.Errors)
+	}
+	if len(sheetValues()) != 5 {
+		t.Errorf("Expected a dry run to leave the sheet unchanged")
+	}
+
+	res, result = importCSV("delimiter=%3B", "text/csv", file)
+	if res.Code != http.StatusOK || result.Imported != 1 {
+		t.Fatalf("Unexpected result %d %s", res.Code, res.Body.String())
+	}
+	if values := sheetValues(); len(values) != 6 || !reflect.DeepEqual(values[5], []interface{}{"5", "", "test5@gmail.com"}) {
+		t.Errorf("Expected the valid line appended in sheet column order but got %v", values)
+	}
+
+	// a multipart upload replacing the data rows
+	var upload bytes.Buffer
+	form := multipart.NewWriter(&upload)
+	form.WriteField("note", "ignored")
+	part, _ := form.CreateFormFile("file", "roster.csv")
+	part.Write([]byte("8,test8,test8@gmail.com\n9,test9,test9@gmail.com\n"))
+	form.Close()
+
+	res, result = importCSV("header=false&mode=replace", form.FormDataContentType(), upload.String())
+	if res.Code != http.StatusOK || result.Imported != 2 {
+		t.Fatalf("Unexpected result %d %s", res.Code, res.Body.String())
+	}
+	expected := [][]interface{}{
+		{"ID", "Name", "Email"},
+		{"8", "test8", "test8@gmail.com"},
+		{"9", "test9", "test9@gmail.com"},
+	}
+	if values := sheetValues(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected the data rows to be replaced but got %v", values)
+	}
+
+	// a replace where no line passes leaves the sheet as it was
+	res, _ = importCSV("mode=replace", "text/csv", "ID,Name\nx,bad\n,empty\n")
+	if res.Code != http.StatusUnprocessableEntity || !strings.Contains(res.Body.String(), `"skipped":2`) {
+		t.Errorf("Expected status code %d with the skipped count but got %d %s", http.StatusUnprocessableEntity, res.Code, res.Body.String())
+	}
+	if values := sheetValues(); !reflect.DeepEqual(values, expected) {
+		t.Errorf("Expected the sheet to be left as it was but got %v", values)
+	}
+
+	if res, _ := importCSV("", "text/csv", "ID,Salary\n10,100\n"); res.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an unknown column but got %d", http.StatusBadRequest, res.Code)
+	}
+	if res, _ := importCSV("mode=merge", "text/csv", "ID\n10\n"); res.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d for an unknown mode but got %d", http.StatusBadRequest, res.Code)
+	}
+}
+
+// failingAppends fails every append after the first few.
+type failingAppends struct {
+	*svc.MemoryBackend
+	appends int
+}
+
+func (f *failingAppends) AppendValues(spreadsheetID string, appendRange string, values *sheets.ValueRange) (*sheets.AppendValuesResponse, error) {
+	if f.appends == 0 {
+		return nil, errors.New("backend unavailable")
+	}
+	f.appends--
+	return f.MemoryBackend.AppendValues(spreadsheetID, appendRange, values)
+}
+
+func TestImportCSVReportsPartialImport(t *testing.T) {
+	backend := &failingAppends{MemoryBackend: svctest.NewBackend(), appends: 1}
+	svc.SetBackend(backend)
+
+	file := &strings.Builder{}
+	file.WriteString("ID,Name,Email\n")
+	for i := 0; i < ImportBatchSize+1; i++ {
+		fmt.Fprintf(file, "%d,name%d,mail%d@example.com\n", i+10, i, i)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/ImportCSV?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1", strings.NewReader(file.String()))
+	req.Header.Set("Content-Type", "text/csv")
+	res := httptest.NewRecorder()
+	ImportCSV(res, req)
+
+	var response struct {
+		Error struct {
+			Details struct {
+				Imported int `json:"imported"`
+				Skipped  int `json:"skipped"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &response)
+	if res.Code < 400 || response.Error.Details.Imported != ImportBatchSize || response.Error.Details.Skipped != 0 {
+		t.Errorf("Expected an error reporting %d imported rows but got %d %s", ImportBatchSize, res.Code, res.Body.String())
+	}
+}
+
+func TestImportCSVReplaceInBatches(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+
+	file := &strings.Builder{}
+	file.WriteString("ID,Name,Email\n")
+	for i := 0; i < ImportBatchSize+1; i++ {
+		fmt.Fprintf(file, "%d,name%d,mail%d@example.com\n", i+10, i, i)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/ImportCSV?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1&mode=replace", strings.NewReader(file.String()))
+	req.Header.Set("Content-Type", "text/csv")
+	res := httptest.NewRecorder()
+	ImportCSV(res, req)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	// the staged rows replace the old ones in file order
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1")
+	if len(values.Values) != ImportBatchSize+2 {
+		t.Fatalf("Expected the header and %d rows but got %d rows", ImportBatchSize+1, len(values.Values))
+	}
+	last := []interface{}{fmt.Sprint(ImportBatchSize + 10), fmt.Sprintf("name%d", ImportBatchSize), fmt.Sprintf("mail%d@example.com", ImportBatchSize)}
+	if !reflect.DeepEqual(values.Values[1][0], "10") || !reflect.DeepEqual(values.Values[ImportBatchSize+1], last) {
+		t.Errorf("Unexpected rows %v ... %v", values.Values[1], values.Values[ImportBatchSize+1])
+	}
+}
+
+func TestImportCSVTooLarge(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+
+	// a quoted field that never ends runs past the limit
+	body := io.MultiReader(strings.NewReader("ID,Name,Email\n1,\""), io.LimitReader(repeatReader('x'), MaxImportBytes))
+	req := httptest.NewRequest(http.MethodPost, "/ImportCSV?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1", body)
+	req.Header.Set("Content-Type", "text/csv")
+	res := httptest.NewRecorder()
+	ImportCSV(res, req)
+	if res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code %d but got %d: %s", http.StatusRequestEntityTooLarge, res.Code, res.Body.String())
+	}
+}
+
+// repeatReader reads as an endless run of one byte.
+type repeatReader byte
+
+func (b repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(b)
+	}
+	return len(p), nil
+}
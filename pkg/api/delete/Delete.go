@@ -5,11 +5,17 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 
+	"personnel-api/pkg/a1"
 	"personnel-api/pkg/api/read"
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/cache"
+	"personnel-api/pkg/history"
 	"personnel-api/pkg/svc"
+	"personnel-api/pkg/trash"
 
 	"google.golang.org/api/sheets/v4"
 )
@@ -19,14 +25,18 @@ DELETE
 Body: {
 		"spreadsheetID": "YOUR_SPREAD_SHEET_ID",
 		"sheetName": "SHEET_NAME",
-		"range": [3, 4]
+		"range": [3, 4],
+		"mode": "delete" | "clear"
 	  }
+
+The default mode removes the rows and shifts the rows below them up; clear
+only empties their values.
 */
 
 func DeleteDataRow(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
@@ -34,62 +44,265 @@ func DeleteDataRow(w http.ResponseWriter, r *http.Request) {
 		SpreadsheetID string        `json:"spreadsheetID"`
 		SheetName     string        `json:"sheetName"`
 		Range         []interface{} `json:"range"`
+		Mode          string        `json:"mode"`
 	}
 
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
 		return
 	}
 
 	spreadsheetID := req.SpreadsheetID
 	if spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
 	sheetName := req.SheetName
 	if sheetName == "" {
-		http.Error(w, "sheetName field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "sheetName field is required")
 		return
 	}
 
 	dataRange := req.Range
 	if len(dataRange) == 0 {
-		http.Error(w, "range field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "range field is required")
 		return
 	}
 
-	err = DeleteDataRowHelper(spreadsheetID, sheetName, dataRange)
+	deleteRows(w, spreadsheetID, sheetName, dataRange, req.Mode)
+}
+
+/*
+DELETE
+
+	Body: {
+			"spreadsheetID": "YOUR_SPREAD_SHEET_ID",
+			"sheetName": "SHEET_NAME",
+			"keyColumn": "ID",
+			"keys": ["3", "4"],
+			"mode": "delete" | "clear"
+		  }
+
+Deletes the row whose keyColumn holds each key. Responds 404 if a key matches
+no row and 409 if it matches more than one.
+*/
+func DeleteByKey(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Cannot delete the rows requested", http.StatusBadRequest)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
-	fmt.Fprint(w, "Delete successfully!")
-}
+	var req struct {
+		SpreadsheetID string        `json:"spreadsheetID"`
+		SheetName     string        `json:"sheetName"`
+		KeyColumn     string        `json:"keyColumn"`
+		Keys          []interface{} `json:"keys"`
+		Mode          string        `json:"mode"`
+	}
 
-func DeleteDataRowHelper(spreadsheetID string, sheetName string, dataRange []interface{}) error {
-	service, err := svc.SetupGoogleSheetsService()
+	err = json.Unmarshal(body, &req)
 	if err != nil {
-		return err
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
+		return
+	}
+
+	if req.SpreadsheetID == "" || req.SheetName == "" || req.KeyColumn == "" || len(req.Keys) == 0 {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID, sheetName, keyColumn and keys fields are required")
+		return
+	}
+
+	dataRange, err := read.RowsByKeyHelper(req.SpreadsheetID, req.SheetName, req.KeyColumn, req.Keys)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Cannot find the rows requested")
+		return
+	}
+
+	deleteRows(w, req.SpreadsheetID, req.SheetName, dataRange, req.Mode)
+}
+
+// deleteRows removes or clears rows according to mode and writes the
+// response.
+func deleteRows(w http.ResponseWriter, spreadsheetID string, sheetName string, dataRange []interface{}, mode string) {
+	switch mode {
+	case "", "delete":
+		result, err := DeleteRowsHelper(spreadsheetID, sheetName, dataRange)
+		if err != nil {
+			apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Cannot delete the rows requested")
+			return
+		}
+
+		response := struct {
+			SpreadsheetID string `json:"spreadsheetID"`
+			SheetName     string `json:"sheetName"`
+			Message       string `json:"message"`
+			*DeleteResult
+		}{
+			SpreadsheetID: spreadsheetID,
+			SheetName:     sheetName,
+			Message:       "Delete successfully!",
+			DeleteResult:  result,
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+	case "clear":
+		result, err := DeleteDataRowHelper(spreadsheetID, sheetName, dataRange)
+		if err != nil {
+			apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Cannot delete the rows requested")
+			return
+		}
+
+		writeClearResponse(w, spreadsheetID, sheetName, result)
+	default:
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "mode must be delete or clear")
 	}
+}
 
-	columnRange, _, _ := read.GetSheetDataHelper(spreadsheetID, sheetName)
+func writeClearResponse(w http.ResponseWriter, spreadsheetID string, sheetName string, result *ClearResult) {
+	response := struct {
+		SpreadsheetID string `json:"spreadsheetID"`
+		SheetName     string `json:"sheetName"`
+		Message       string `json:"message"`
+		*ClearResult
+	}{
+		SpreadsheetID: spreadsheetID,
+		SheetName:     sheetName,
+		Message:       "Delete successfully!",
+		ClearResult:   result,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// ClearResult reports the ranges emptied by a batch clear.
+type ClearResult struct {
+	ClearedRanges []string `json:"clearedRanges"`
+	ClearedCells  int      `json:"clearedCells"`
+}
+
+// rowNumber validates a 1-based sheet row number from a request range.
+func rowNumber(v interface{}) (string, error) {
+	rowNum := fmt.Sprint(v)
+	if n, err := strconv.Atoi(rowNum); err != nil || n < 1 {
+		return "", apierror.New(http.StatusBadRequest, apierror.CodeInvalidRange, "invalid row number: %v", v)
+	}
+	return rowNum, nil
+}
+
+// DeleteDataRowHelper clears the values of every row in a single batch clear.
+func DeleteDataRowHelper(spreadsheetID string, sheetName string, dataRange []interface{}) (*ClearResult, error) {
+	defer cache.Default().Invalidate(spreadsheetID, sheetName)
+
+	columnRange, sheetData, err := read.GetSheetDataHelper(spreadsheetID, sheetName)
+	if err != nil {
+		return nil, err
+	}
+	header, err := read.Header(sheetData)
+	if err != nil {
+		return nil, err
+	}
 	arr := strings.Split(columnRange, ":")
-	request := &sheets.ClearValuesRequest{}
 
+	req := &sheets.BatchClearValuesRequest{}
 	for i := range dataRange {
-		rowNum := dataRange[i].(string)
-		rowRange := sheetName + "!" + arr[0] + rowNum + ":" + arr[1] + rowNum
+		rowNum, err := rowNumber(dataRange[i])
+		if err != nil {
+			return nil, err
+		}
+		req.Ranges = append(req.Ranges, sheetName+"!"+arr[0]+rowNum+":"+arr[1]+rowNum)
+	}
+
+	response, err := batchClearValues(spreadsheetID, sheetName, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClearResult{ClearedRanges: response.ClearedRanges, ClearedCells: len(req.Ranges) * len(header)}, nil
+}
+
+// batchClearValues clears the ranges of req in one batch clear and notes the
+// values it changes on the sheet's history.
+func batchClearValues(spreadsheetID string, sheetName string, req *sheets.BatchClearValuesRequest) (*sheets.BatchClearValuesResponse, error) {
+	edit := history.NewEdit(spreadsheetID, sheetName, req.Ranges...)
 
-		_, err := service.Spreadsheets.Values.Clear(spreadsheetID, rowRange, request).Do()
+	response, err := svc.GetBackend().BatchClearValues(spreadsheetID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, clearRange := range req.Ranges {
+		edit.Clear(clearRange)
+	}
+	edit.Done()
+	return response, nil
+}
+
+// DeleteResult reports the rows removed by DeleteRowsHelper, highest first.
+type DeleteResult struct {
+	DeletedRows  []int `json:"deletedRows"`
+	DeletedCount int   `json:"deletedCount"`
+}
+
+// DeleteRowsHelper removes rows from a sheet in a single batch update. Rows
+// are deleted from the bottom up, merging adjacent rows into one request, so
+// that each deletion leaves the row numbers of the remaining ones unchanged.
+func DeleteRowsHelper(spreadsheetID string, sheetName string, dataRange []interface{}) (*DeleteResult, error) {
+	defer cache.Default().Invalidate(spreadsheetID, sheetName)
+
+	seen := map[int]bool{}
+	var rows []int
+	for i := range dataRange {
+		rowNum, err := rowNumber(dataRange[i])
 		if err != nil {
-			return err
+			return nil, err
+		}
+		n, _ := strconv.Atoi(rowNum)
+		if !seen[n] {
+			seen[n] = true
+			rows = append(rows, n)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(rows)))
+	if len(rows) == 0 {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "range field is required")
+	}
+
+	sheetID, err := read.SheetID(spreadsheetID, sheetName)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &sheets.BatchUpdateSpreadsheetRequest{}
+	for i := 0; i < len(rows); {
+		end := i + 1
+		for end < len(rows) && rows[end] == rows[end-1]-1 {
+			end++
 		}
+		req.Requests = append(req.Requests, &sheets.Request{
+			DeleteDimension: &sheets.DeleteDimensionRequest{
+				Range: &sheets.DimensionRange{
+					SheetId:    sheetID,
+					Dimension:  "ROWS",
+					StartIndex: int64(rows[end-1] - 1),
+					EndIndex:   int64(rows[i]),
+				},
+			},
+		})
+		i = end
+	}
+
+	edit := history.NewRowsEdit(spreadsheetID, sheetName, rows[len(rows)-1])
+	if _, err := svc.GetBackend().BatchUpdate(spreadsheetID, req); err != nil {
+		return nil, err
 	}
+	edit.DeleteRows(rows)
+	edit.Done()
 
-	return nil
+	return &DeleteResult{DeletedRows: rows, DeletedCount: len(rows)}, nil
 }
 
 /*
@@ -104,7 +317,7 @@ DELETE
 func DeleteDataCell(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
@@ -116,60 +329,64 @@ func DeleteDataCell(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
 		return
 	}
 
 	spreadsheetID := req.SpreadsheetID
 	if spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
 	sheetName := req.SheetName
 	if sheetName == "" {
-		http.Error(w, "sheetName field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "sheetName field is required")
 		return
 	}
 
 	dataRange := req.Range
 	if len(dataRange) == 0 {
-		http.Error(w, "range field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "range field is required")
 		return
 	}
 
-	err = DeleteDataCellHelper(spreadsheetID, sheetName, dataRange)
+	result, err := DeleteDataCellHelper(spreadsheetID, sheetName, dataRange)
 	if err != nil {
-		http.Error(w, "Cannot delete the rows requested", http.StatusBadRequest)
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Cannot delete the rows requested")
 		return
 	}
 
-	fmt.Fprint(w, "Delete successfully!")
+	writeClearResponse(w, spreadsheetID, sheetName, result)
 }
 
-func DeleteDataCellHelper(spreadsheetID string, sheetName string, dataRange [][]interface{}) error {
-	service, err := svc.SetupGoogleSheetsService()
-	if err != nil {
-		return err
-	}
-
-	request := &sheets.ClearValuesRequest{}
+// DeleteDataCellHelper clears every cell in a single batch clear.
+func DeleteDataCellHelper(spreadsheetID string, sheetName string, dataRange [][]interface{}) (*ClearResult, error) {
+	defer cache.Default().Invalidate(spreadsheetID, sheetName)
 
+	req := &sheets.BatchClearValuesRequest{}
 	for _, pos := range dataRange {
-		row := pos[0].(string)
-		col_int, err := strconv.Atoi(pos[1].(string))
-		if err != nil {
-			return err
+		if len(pos) != 2 {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRange, "each range entry must be [row, column]")
 		}
-		col := read.ColumnIndexToLetter(col_int)
-		rowRange := sheetName + "!" + col + row + ":" + col + row
-
-		_, err = service.Spreadsheets.Values.Clear(spreadsheetID, rowRange, request).Do()
+		row, err := rowNumber(pos[0])
 		if err != nil {
-			return err
+			return nil, err
 		}
+		col_int, err := strconv.Atoi(fmt.Sprint(pos[1]))
+		if err != nil || col_int < 0 {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRange, "invalid column index: %v", pos[1])
+		}
+		col := a1.ColumnLetter(col_int)
+		req.Ranges = append(req.Ranges, sheetName+"!"+col+row+":"+col+row)
 	}
-	return nil
+
+	response, err := batchClearValues(spreadsheetID, sheetName, req)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ClearResult{ClearedRanges: response.ClearedRanges, ClearedCells: len(req.Ranges)}, nil
 }
 
 /*
@@ -179,7 +396,7 @@ Body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID"}
 func DeleteSpreadsheet(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
@@ -189,27 +406,29 @@ func DeleteSpreadsheet(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
 		return
 	}
 
 	spreadsheetID := req.SpreadsheetID
 	if spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
-	err = DeleteSpreadsheetHelper(spreadsheetID)
+	item, err := DeleteSpreadsheetHelper(spreadsheetID)
 	if err != nil {
-		http.Error(w, "Cannot delete spreadsheet: "+err.Error(), http.StatusInternalServerError)
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Cannot delete spreadsheet")
 		return
 	}
 
 	response := struct {
 		SpreadsheetID string `json:"spreadsheetID"`
+		TrashID       string `json:"trashID"`
 		Message       string `json:"message"`
 	}{
 		SpreadsheetID: spreadsheetID,
+		TrashID:       item.ID,
 		Message:       "Spreadsheet deleted successfully",
 	}
 
@@ -217,28 +436,33 @@ func DeleteSpreadsheet(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func DeleteSpreadsheetHelper(spreadsheetID string) error {
-	service, err := svc.SetupGoogleSheetsService()
-	if err != nil {
-		return err
-	}
+// DeleteSpreadsheetHelper moves a spreadsheet to the Drive trash and lists it
+// in the trash store, from where it can be restored until it is purged.
+func DeleteSpreadsheetHelper(spreadsheetID string) (*trash.Item, error) {
+	defer cache.Default().InvalidateSpreadsheet(spreadsheetID)
+
+	backend := svc.GetBackend()
 
-	_, err = service.Spreadsheets.Get(spreadsheetID).Do()
+	spreadsheet, err := backend.GetSpreadsheet(spreadsheetID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	driveService, err := svc.SetupGoogleDriveService()
-	if err != nil {
-		return fmt.Errorf("unable to create drive service: %v", err)
+	item := &trash.Item{Kind: trash.KindSpreadsheet, SpreadsheetID: spreadsheetID}
+	if spreadsheet.Properties != nil {
+		item.Title = spreadsheet.Properties.Title
+	}
+	if err := trash.Default().Add(item); err != nil {
+		return nil, err
 	}
 
-	err = driveService.Files.Delete(spreadsheetID).Do()
+	err = backend.SetTrashed(spreadsheetID, true)
 	if err != nil {
-		return fmt.Errorf("failed to delete spreadsheet: %v", err)
+		trash.Default().Remove(item.ID)
+		return nil, fmt.Errorf("failed to delete spreadsheet: %w", err)
 	}
 
-	return nil
+	return item, nil
 }
 
 /*
@@ -247,13 +471,13 @@ Body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetID": 123456}
 */
 func DeleteSheet(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
@@ -264,34 +488,36 @@ func DeleteSheet(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
 		return
 	}
 
 	spreadsheetID := req.SpreadsheetID
 	if spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
 	if req.SheetID == 0 {
-		http.Error(w, "sheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "sheetID field is required")
 		return
 	}
 
-	err = DeleteSheetHelper(spreadsheetID, req.SheetID)
+	item, err := DeleteSheetHelper(spreadsheetID, req.SheetID)
 	if err != nil {
-		http.Error(w, "Cannot delete sheet: "+err.Error(), http.StatusInternalServerError)
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Cannot delete sheet")
 		return
 	}
 
 	response := struct {
 		SpreadsheetID string `json:"spreadsheetID"`
 		SheetID       int64  `json:"sheetID"`
+		TrashID       string `json:"trashID"`
 		Message       string `json:"message"`
 	}{
 		SpreadsheetID: spreadsheetID,
 		SheetID:       req.SheetID,
+		TrashID:       item.ID,
 		Message:       "Sheet deleted successfully",
 	}
 
@@ -299,10 +525,42 @@ func DeleteSheet(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func DeleteSheetHelper(spreadsheetID string, sheetID int64) error {
-	service, err := svc.SetupGoogleSheetsService()
+// DeleteSheetHelper saves the properties and values of a sheet to the trash
+// store before removing the sheet, so it can be restored until it is purged.
+func DeleteSheetHelper(spreadsheetID string, sheetID int64) (*trash.Item, error) {
+	defer cache.Default().InvalidateSpreadsheet(spreadsheetID)
+
+	backend := svc.GetBackend()
+
+	spreadsheet, err := backend.GetSpreadsheet(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+
+	var props *sheets.SheetProperties
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties != nil && sheet.Properties.SheetId == sheetID {
+			props = sheet.Properties
+		}
+	}
+	if props == nil {
+		return nil, apierror.New(http.StatusNotFound, apierror.CodeSheetNotFound, "sheet %d not found", sheetID)
+	}
+
+	values, err := backend.GetValues(spreadsheetID, props.Title)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	item := &trash.Item{
+		Kind:          trash.KindSheet,
+		SpreadsheetID: spreadsheetID,
+		Title:         props.Title,
+		Properties:    props,
+		Values:        values.Values,
+	}
+	if err := trash.Default().Add(item); err != nil {
+		return nil, err
 	}
 
 	req := &sheets.BatchUpdateSpreadsheetRequest{
@@ -315,10 +573,11 @@ func DeleteSheetHelper(spreadsheetID string, sheetID int64) error {
 		},
 	}
 
-	_, err = service.Spreadsheets.BatchUpdate(spreadsheetID, req).Do()
+	_, err = backend.BatchUpdate(spreadsheetID, req)
 	if err != nil {
-		return err
+		trash.Default().Remove(item.ID)
+		return nil, err
 	}
 
-	return nil
+	return item, nil
 }
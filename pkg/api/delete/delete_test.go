@@ -4,16 +4,29 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
+
+	"personnel-api/pkg/history"
+	"personnel-api/pkg/svc"
+	"personnel-api/pkg/svc/svctest"
+	"personnel-api/pkg/trash"
+
+	"google.golang.org/api/sheets/v4"
 )
 
 const testSpreadsheetID = "1Y6NRaduDsw_Wxu0yEhomYWXeCjBteFlnovj7TiPAyM8"
 
 type errorReader struct{}
 
+type clearResponse struct {
+	Message       string   `json:"message"`
+	ClearedRanges []string `json:"clearedRanges"`
+	ClearedCells  int      `json:"clearedCells"`
+}
+
 func (r *errorReader) Read(p []byte) (n int, err error) {
 	return 0, fmt.Errorf("simulated error while reading request body")
 }
@@ -26,7 +39,8 @@ func TestDeleteDataRow(t *testing.T) {
 		"range": [
 			"4",
 			"5"
-		]
+		],
+		"mode": "clear"
 	}`)
 	req := httptest.NewRequest(http.MethodPost, "/DeleteDataRow", bytes.NewReader(requestBody))
 	res := httptest.NewRecorder()
@@ -37,10 +51,13 @@ func TestDeleteDataRow(t *testing.T) {
 		t.Errorf("Expected status code %d but got %d", http.StatusOK, res.Code)
 	}
 
-	expectedResponse := "Delete successfully!"
-	actualResponse, _ := io.ReadAll(res.Body)
-	if string(actualResponse) != expectedResponse {
-		t.Errorf("Expected response body %q but got %q", expectedResponse, string(actualResponse))
+	var response clearResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expectedRanges := []string{"Sheet1!A4:C4", "Sheet1!A5:C5"}
+	if response.Message != "Delete successfully!" || !reflect.DeepEqual(response.ClearedRanges, expectedRanges) || response.ClearedCells != 6 {
+		t.Errorf("Unexpected response %+v", response)
 	}
 
 	// test for error handling
@@ -106,6 +123,154 @@ func TestDeleteDataRow(t *testing.T) {
 	}
 }
 
+func TestDeleteDataRowShiftsRowsUp(t *testing.T) {
+	previous := svc.GetBackend()
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+	defer svc.SetBackend(previous)
+
+	requestBody := []byte(`{
+		"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w",
+		"sheetName": "Sheet1",
+		"range": ["2", "5", "4"]
+	}`)
+	req := httptest.NewRequest(http.MethodPost, "/DeleteDataRow", bytes.NewReader(requestBody))
+	res := httptest.NewRecorder()
+
+	DeleteDataRow(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	var response struct {
+		DeletedRows  []int `json:"deletedRows"`
+		DeletedCount int   `json:"deletedCount"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if !reflect.DeepEqual(response.DeletedRows, []int{5, 4, 2}) || response.DeletedCount != 3 {
+		t.Errorf("Unexpected response %+v", response)
+	}
+
+	values, err := backend.GetValues(svctest.SpreadsheetID, "Sheet1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]interface{}{
+		{"ID", "Name", "Email"},
+		{"2", "test2", "test2@gmail.com"},
+	}
+	if !reflect.DeepEqual(values.Values, expected) {
+		t.Errorf("Expected %v but got %v", expected, values.Values)
+	}
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/DeleteDataRow", bytes.NewReader([]byte(`{
+		"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w",
+		"sheetName": "Missing",
+		"range": ["2"]
+	}`)))
+	DeleteDataRow(res, req)
+
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodPost, "/DeleteDataRow", bytes.NewReader([]byte(`{
+		"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w",
+		"sheetName": "Sheet1",
+		"range": ["2"],
+		"mode": "shift"
+	}`)))
+	DeleteDataRow(res, req)
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d but got %d", http.StatusBadRequest, res.Code)
+	}
+}
+
+func TestDeleteRowsRecordsHistory(t *testing.T) {
+	previous := svc.GetBackend()
+	svc.SetBackend(svctest.NewBackend())
+	defer svc.SetBackend(previous)
+	previousStore := history.Default()
+	store, _ := history.NewStore("")
+	history.SetDefault(store)
+	defer history.SetDefault(previousStore)
+
+	recording := store.Begin(svctest.SpreadsheetID, "Sheet1")
+	if _, err := DeleteRowsHelper(svctest.SpreadsheetID, "Sheet1", []interface{}{"3"}); err != nil {
+		t.Fatalf("DeleteRowsHelper returned error: %v", err)
+	}
+	change, err := recording.Commit("DeleteDataRow", "alice")
+	recording.End()
+	if err != nil || change == nil {
+		t.Fatalf("Expected a change but got %v, %v", change, err)
+	}
+
+	// the rows below the deleted one move up
+	if change.Range != "'Sheet1'!A3:C5" {
+		t.Errorf("Expected range 'Sheet1'!A3:C5 but got %s", change.Range)
+	}
+	expected := [][]interface{}{{"3", "test3", "test3@gmail.com"}, {"4", "test4", "test4@gmail.com"}, {"", "", ""}}
+	if !reflect.DeepEqual(change.After, expected) {
+		t.Errorf("Expected %v but got %v", expected, change.After)
+	}
+}
+
+func TestDeleteByKey(t *testing.T) {
+	previous := svc.GetBackend()
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+	defer svc.SetBackend(previous)
+
+	// the cases share the backend, so they run in order: the first one must
+	// find key 1 before the fourth deletes it
+	cases := []struct {
+		body   string
+		status int
+	}{
+		{`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "ID", "keys": ["1", "9"]}`, http.StatusNotFound},
+		{`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "Phone", "keys": ["1"]}`, http.StatusBadRequest},
+		{`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keys": ["1"]}`, http.StatusBadRequest},
+		{`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "ID", "keys": ["1", 3]}`, http.StatusOK},
+		{`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet2", "keyColumn": "Name", "keys": ["test2"]}`, http.StatusOK},
+	}
+	for _, c := range cases {
+		res := httptest.NewRecorder()
+		DeleteByKey(res, httptest.NewRequest(http.MethodDelete, "/DeleteByKey", bytes.NewReader([]byte(c.body))))
+		if res.Code != c.status {
+			t.Errorf("Body %s: expected status code %d but got %d", c.body, c.status, res.Code)
+		}
+	}
+
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1")
+	expected := [][]interface{}{
+		{"ID", "Name", "Email"},
+		{"2", "test2", "test2@gmail.com"},
+		{"4", "test4", "test4@gmail.com"},
+	}
+	if !reflect.DeepEqual(values.Values, expected) {
+		t.Errorf("Expected %v but got %v", expected, values.Values)
+	}
+
+	// an ambiguous key deletes nothing
+	backend.UpdateValues(svctest.SpreadsheetID, "Sheet1!B3", &sheets.ValueRange{Values: [][]interface{}{{"test2"}}})
+	res := httptest.NewRecorder()
+	DeleteByKey(res, httptest.NewRequest(http.MethodDelete, "/DeleteByKey", bytes.NewReader([]byte(`{
+		"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w",
+		"sheetName": "Sheet1",
+		"keyColumn": "Name",
+		"keys": ["test2"]
+	}`))))
+	if res.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d but got %d", http.StatusConflict, res.Code)
+	}
+}
+
 func TestDeleteDataCell(t *testing.T) {
 	// test for correct respond
 	requestBody := []byte(`{
@@ -131,10 +296,13 @@ func TestDeleteDataCell(t *testing.T) {
 		t.Errorf("Expected status code %d but got %d", http.StatusOK, res.Code)
 	}
 
-	expectedResponse := "Delete successfully!"
-	actualResponse, _ := io.ReadAll(res.Body)
-	if string(actualResponse) != expectedResponse {
-		t.Errorf("Expected response body %q but got %q", expectedResponse, string(actualResponse))
+	var response clearResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expectedRanges := []string{"Sheet1!B4:B4", "Sheet1!C5:C5"}
+	if response.Message != "Delete successfully!" || !reflect.DeepEqual(response.ClearedRanges, expectedRanges) || response.ClearedCells != 2 {
+		t.Errorf("Unexpected response %+v", response)
 	}
 
 	// test for error handling
@@ -376,3 +544,41 @@ func TestDeleteSheet(t *testing.T) {
 		t.Errorf("Expected status code %d but got %d", http.StatusMethodNotAllowed, res_err.Code)
 	}
 }
+
+func TestDeleteMovesToTrash(t *testing.T) {
+	previous := svc.GetBackend()
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+	defer svc.SetBackend(previous)
+
+	item, err := DeleteSheetHelper(svctest.SpreadsheetID, 1)
+	if err != nil {
+		t.Fatalf("DeleteSheetHelper returned error: %v", err)
+	}
+	if got := trash.Default().Get(item.ID); got == nil || got.Title != "Sheet2" || len(got.Values) != 4 || got.Properties.SheetId != 1 {
+		t.Errorf("Expected a snapshot of Sheet2 in the trash but got %+v", got)
+	}
+	if spreadsheet, _ := backend.GetSpreadsheet(svctest.SpreadsheetID); len(spreadsheet.Sheets) != 2 {
+		t.Errorf("Expected Sheet2 to be removed")
+	}
+	if _, err := DeleteSheetHelper(svctest.SpreadsheetID, 99); err == nil {
+		t.Errorf("Expected an error for a missing sheet")
+	}
+
+	item, err = DeleteSpreadsheetHelper(svctest.SpreadsheetID)
+	if err != nil {
+		t.Fatalf("DeleteSpreadsheetHelper returned error: %v", err)
+	}
+	if got := trash.Default().Get(item.ID); got == nil || got.Kind != trash.KindSpreadsheet || got.Title != "Personnel" {
+		t.Errorf("Expected the spreadsheet in the trash but got %+v", got)
+	}
+	files, _ := backend.ListSpreadsheets()
+	for _, file := range files {
+		if file.Id == svctest.SpreadsheetID {
+			t.Errorf("Expected the trashed spreadsheet to be hidden from the listing")
+		}
+	}
+	if _, err := backend.GetSpreadsheet(svctest.SpreadsheetID); err != nil {
+		t.Errorf("Expected the trashed spreadsheet to still exist: %v", err)
+	}
+}
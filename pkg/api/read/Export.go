@@ -0,0 +1,262 @@
+package read
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/xlsx"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	ExportCSV  = "csv"
+	ExportTSV  = "tsv"
+	ExportXLSX = "xlsx"
+)
+
+var exportContentTypes = map[string]string{
+	ExportCSV:  "text/csv; charset=utf-8",
+	ExportTSV:  "text/tab-separated-values; charset=utf-8",
+	ExportXLSX: xlsx.ContentType,
+}
+
+/*
+GET
+Query params: spreadsheetID=YOUR_SPREAD_SHEET_ID&sheetName=SHEET_NAME
+Optional: format=csv|tsv|xlsx; without it the format is picked from the Accept header and defaults to csv
+Optional: fields, filter and sort as for GetRows, and limit and offset to export part of the rows; every matching row is exported by default
+The file is sent as an attachment named after the spreadsheet and the sheet, e.g. "Personnel - Sheet1.csv"
+CSV and TSV cells starting with =, +, -, @, tab or CR get a leading ' so they are not run as formulas; plain numbers such as -1.5 are kept
+*/
+func ExportSheet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	params := map[string]string{}
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 {
+			params[key] = values[0]
+		}
+	}
+
+	spreadsheetID := params["spreadsheetID"]
+	if spreadsheetID == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID parameter is required")
+		return
+	}
+
+	sheetName := params["sheetName"]
+	if sheetName == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "sheetName parameter is required")
+		return
+	}
+
+	format := strings.ToLower(params["format"])
+	if format == "" {
+		var ok bool
+		format, ok = NegotiateExportFormat(r.Header.Get("Accept"))
+		if !ok {
+			apierror.Write(w, http.StatusNotAcceptable, apierror.CodeNotAcceptable, "Accept must allow text/csv, text/tab-separated-values or "+xlsx.ContentType)
+			return
+		}
+	} else if _, ok := exportContentTypes[format]; !ok {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "format must be csv, tsv or xlsx")
+		return
+	}
+
+	query, err := ParseRowQuery(params)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "")
+		return
+	}
+	if query.PageToken != "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "pageToken is not supported by exports, use offset instead")
+		return
+	}
+
+	spreadsheet, err := GetSpreadsheetByIdHelper(spreadsheetID)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Failed to retrieve spreadsheet")
+		return
+	}
+
+	_, sheetData, err := GetSheetDataHelper(spreadsheetID, sheetName)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Failed to retrieve sheet data")
+		return
+	}
+
+	page, err := QueryRows(sheetData, query)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "")
+		return
+	}
+
+	title := sheetName
+	if spreadsheet.Properties != nil && spreadsheet.Properties.Title != "" {
+		title = spreadsheet.Properties.Title + " - " + sheetName
+	}
+
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	w.Header().Set("Content-Disposition", ContentDisposition(title+"."+format))
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	w.Header().Add("Vary", "Accept")
+
+	// the status line has gone out once rows are written, so a failure from
+	// here on can only cut the file short
+	if err := ExportRowsHelper(w, format, sheetName, page.Rows); err != nil {
+		log.Printf("export: %s/%s as %s: %v", spreadsheetID, sheetName, format, err)
+	}
+}
+
+// ExportRowsHelper writes rows, as returned by QueryRows, to w in format.
+func ExportRowsHelper(w io.Writer, format string, sheetName string, rows []interface{}) error {
+	switch format {
+	case ExportCSV, ExportTSV:
+		writer := csv.NewWriter(w)
+		if format == ExportTSV {
+			writer.Comma = '\t'
+		}
+		record := []string{}
+		for _, row := range rows {
+			record = record[:0]
+			for _, cell := range row.([]interface{}) {
+				if cell == nil {
+					record = append(record, "")
+					continue
+				}
+				record = append(record, escapeFormula(fmt.Sprint(cell)))
+			}
+			if err := writer.Write(record); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case ExportXLSX:
+		writer, err := xlsx.NewWriter(w, sheetName)
+		if err != nil {
+			return err
+		}
+		for _, row := range rows {
+			if err := writer.WriteRow(row.([]interface{})); err != nil {
+				return err
+			}
+		}
+		return writer.Close()
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// escapeFormula puts a ' in front of a cell that Excel or LibreOffice would
+// otherwise run as a formula when the file is opened.
+func escapeFormula(cell string) string {
+	if cell != "" && strings.IndexByte("=+-@\t\r", cell[0]) >= 0 && !isPlainNumber(cell) {
+		return "'" + cell
+	}
+	return cell
+}
+
+// isPlainNumber reports whether s is a signed decimal number such as -1.5,
+// which is safe to leave as is.
+func isPlainNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil && strings.Trim(s, "+-.0123456789") == ""
+}
+
+// NegotiateExportFormat picks the export format preferred by an Accept
+// header. An empty header, */* and text/* get csv. It returns false when the
+// header allows none of the formats.
+func NegotiateExportFormat(accept string) (string, bool) {
+	if strings.TrimSpace(accept) == "" {
+		return ExportCSV, true
+	}
+
+	type candidate struct {
+		format string
+		q      float64
+	}
+	var candidates []candidate
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+		if q <= 0 {
+			continue
+		}
+
+		var format string
+		switch mediaType {
+		case "text/csv", "text/*", "*/*":
+			format = ExportCSV
+		case "text/tab-separated-values":
+			format = ExportTSV
+		case xlsx.ContentType:
+			format = ExportXLSX
+		default:
+			continue
+		}
+		candidates = append(candidates, candidate{format, q})
+	}
+	if len(candidates) == 0 {
+		return "", false
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].q > candidates[j].q
+	})
+	return candidates[0].format, true
+}
+
+// ContentDisposition returns an attachment header for filename. Characters
+// that are not allowed in file names are replaced; names that are not plain
+// ASCII also get an RFC 5987 filename* parameter with an ASCII fallback.
+func ContentDisposition(filename string) string {
+	filename = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(`"\/:*?<>|`, r) {
+			return '_'
+		}
+		return r
+	}, filename)
+
+	fallback := strings.Map(func(r rune) rune {
+		if r > 0x7e {
+			return '_'
+		}
+		return r
+	}, filename)
+	if fallback == filename {
+		return `attachment; filename="` + filename + `"`
+	}
+	return `attachment; filename="` + fallback + `"; filename*=UTF-8''` + encodeExtValue(filename)
+}
+
+// encodeExtValue percent-encodes every byte of s outside the attr-char set of
+// RFC 5987.
+func encodeExtValue(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.IndexByte("!#$&+-.^_`|~", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
@@ -0,0 +1,304 @@
+package read
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/filter"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultPageSize = 1000
+	MaxPageSize     = 10000
+)
+
+// SortKey orders rows by one column.
+type SortKey struct {
+	Column string
+	Desc   bool
+}
+
+// RowQuery selects, orders and pages the data rows of a sheet. A zero Limit
+// returns every row after Offset.
+type RowQuery struct {
+	Filter    string
+	Sort      []SortKey
+	Fields    []string
+	Limit     int
+	Offset    int
+	PageToken string
+}
+
+// RowPage is one page of a RowQuery. Rows starts with the projected header
+// row; Total counts the data rows matching the filter on all pages. From and
+// To are the indexes in the sheet data of the first and last data rows the
+// page was read from, with To 0 when the page runs to the last row. A filtered
+// or sorted page can take its rows from anywhere, so it is read from all rows.
+type RowPage struct {
+	Rows          []interface{}
+	Total         int
+	Offset        int
+	NextPageToken string
+	From          int
+	To            int
+}
+
+// pageToken marks the last row of a page by its sort key values and its
+// position in the sheet, so the next page starts after it even when rows were
+// added or removed in between.
+type pageToken struct {
+	Keys  []string `json:"k,omitempty"`
+	Index int      `json:"i"`
+	Query uint32   `json:"q"`
+}
+
+type indexedRow struct {
+	index int
+	cells []interface{}
+	keys  []string
+}
+
+// ParseRowQuery reads filter, sort, fields (or column), limit, offset and
+// pageToken from the request parameters.
+// sort is a comma separated list of columns, each optionally prefixed with -
+// or suffixed with :asc or :desc, e.g. sort=-Score,Name or sort=Score:desc,Name.
+func ParseRowQuery(params map[string]string) (RowQuery, error) {
+	q := RowQuery{
+		Filter:    params["filter"],
+		PageToken: params["pageToken"],
+	}
+
+	if params["sort"] != "" {
+		for _, part := range strings.Split(params["sort"], ",") {
+			part = strings.TrimSpace(part)
+			key := SortKey{Column: part}
+			switch {
+			case strings.HasPrefix(part, "-"):
+				key = SortKey{Column: part[1:], Desc: true}
+			case strings.HasPrefix(part, "+"):
+				key = SortKey{Column: part[1:]}
+			case strings.Contains(part, ":"):
+				i := strings.LastIndex(part, ":")
+				key.Column = part[:i]
+				switch strings.ToLower(part[i+1:]) {
+				case "asc":
+				case "desc":
+					key.Desc = true
+				default:
+					return q, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "sort direction must be asc or desc: %v", part)
+				}
+			}
+			if key.Column == "" {
+				return q, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "sort column is empty")
+			}
+			q.Sort = append(q.Sort, key)
+		}
+	}
+
+	fields := params["fields"]
+	if fields == "" {
+		fields = params["column"]
+	}
+	if fields != "" {
+		for _, field := range strings.Split(fields, ",") {
+			q.Fields = append(q.Fields, strings.TrimSpace(field))
+		}
+	}
+
+	var err error
+	if q.Limit, err = parseCount(params, "limit"); err != nil {
+		return q, err
+	}
+	if q.Limit > MaxPageSize {
+		return q, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "limit must not exceed %d", MaxPageSize)
+	}
+	if q.Offset, err = parseCount(params, "offset"); err != nil {
+		return q, err
+	}
+	if q.PageToken != "" && q.Offset > 0 {
+		return q, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "offset cannot be combined with pageToken")
+	}
+
+	return q, nil
+}
+
+func parseCount(params map[string]string, name string) (int, error) {
+	if params[name] == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(params[name])
+	if err != nil || n < 0 {
+		return 0, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "%s must be a non-negative integer", name)
+	}
+	return n, nil
+}
+
+// QueryRows applies q to data returned by GetSheetDataHelper: rows are
+// filtered, sorted with filter.CompareCells, paged and projected to q.Fields.
+func QueryRows(sheetData []interface{}, q RowQuery) (*RowPage, error) {
+	page := &RowPage{Rows: []interface{}{}, From: 1}
+	if len(sheetData) == 0 || len(sheetData[0].([][]interface{})) == 0 {
+		return page, nil
+	}
+	data := sheetData[0].([][]interface{})
+	header := data[0]
+
+	var match filter.Expr
+	if q.Filter != "" {
+		var err error
+		match, err = filter.Compile(q.Filter, header)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sortIdx := make([]int, len(q.Sort))
+	for i, key := range q.Sort {
+		columnIdx, err := columnIndex(header, key.Column)
+		if err != nil {
+			return nil, err
+		}
+		sortIdx[i] = columnIdx
+	}
+
+	fieldIdx := make([]int, len(q.Fields))
+	for i, field := range q.Fields {
+		columnIdx, err := columnIndex(header, field)
+		if err != nil {
+			return nil, err
+		}
+		fieldIdx[i] = columnIdx
+	}
+
+	var rows []indexedRow
+	for i, cells := range data[1:] {
+		if match != nil && !match.Match(cells) {
+			continue
+		}
+		row := indexedRow{index: i + 1, cells: cells}
+		for _, columnIdx := range sortIdx {
+			row.keys = append(row.keys, cellText(cells, columnIdx))
+		}
+		rows = append(rows, row)
+	}
+
+	compare := func(a, b indexedRow) int {
+		for i, key := range q.Sort {
+			c := filter.CompareCells(a.keys[i], b.keys[i])
+			if key.Desc {
+				c = -c
+			}
+			if c != 0 {
+				return c
+			}
+		}
+		return a.index - b.index
+	}
+	if len(q.Sort) > 0 {
+		sort.SliceStable(rows, func(i, j int) bool {
+			return compare(rows[i], rows[j]) < 0
+		})
+	}
+
+	fingerprint := queryFingerprint(q)
+	start := q.Offset
+	if q.PageToken != "" {
+		token, err := decodePageToken(q.PageToken)
+		if err != nil || token.Query != fingerprint || len(token.Keys) != len(q.Sort) {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "pageToken is invalid or was issued for a different filter or sort")
+		}
+		last := indexedRow{index: token.Index, keys: token.Keys}
+		start = sort.Search(len(rows), func(i int) bool {
+			return compare(rows[i], last) > 0
+		})
+	}
+	if start > len(rows) {
+		start = len(rows)
+	}
+
+	end := len(rows)
+	if q.Limit > 0 && start+q.Limit < end {
+		end = start + q.Limit
+		last := rows[end-1]
+		page.NextPageToken = encodePageToken(pageToken{Keys: last.keys, Index: last.index, Query: fingerprint})
+	}
+
+	page.Total = len(rows)
+	page.Offset = start
+	page.From = 1
+	if match == nil && len(q.Sort) == 0 {
+		page.From = start + 1
+		if page.NextPageToken != "" {
+			page.To = end
+		}
+	}
+	page.Rows = append(page.Rows, project(header, fieldIdx))
+	for _, row := range rows[start:end] {
+		page.Rows = append(page.Rows, project(row.cells, fieldIdx))
+	}
+
+	return page, nil
+}
+
+func columnIndex(header []interface{}, name string) (int, error) {
+	for i, column := range header {
+		if fmt.Sprint(column) == name {
+			return i, nil
+		}
+	}
+	return 0, apierror.New(http.StatusBadRequest, apierror.CodeColumnNotFound, "no column found with that name: %v", name)
+}
+
+func cellText(cells []interface{}, index int) string {
+	if index < len(cells) && cells[index] != nil {
+		return fmt.Sprint(cells[index])
+	}
+	return ""
+}
+
+// project returns the cells at fieldIdx, or all cells when no fields are set.
+func project(cells []interface{}, fieldIdx []int) []interface{} {
+	if len(fieldIdx) == 0 {
+		return cells
+	}
+	projected := make([]interface{}, len(fieldIdx))
+	for i, columnIdx := range fieldIdx {
+		projected[i] = ""
+		if columnIdx < len(cells) {
+			projected[i] = cells[columnIdx]
+		}
+	}
+	return projected
+}
+
+// queryFingerprint identifies the filter and sort order a page token belongs
+// to. The projection is left out so clients can change fields between pages.
+func queryFingerprint(q RowQuery) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(q.Filter))
+	for _, key := range q.Sort {
+		fmt.Fprintf(h, "\x00%s\x00%t", key.Column, key.Desc)
+	}
+	return h.Sum32()
+}
+
+func encodePageToken(token pageToken) string {
+	data, _ := json.Marshal(token)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageToken(s string) (pageToken, error) {
+	var token pageToken
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return token, err
+	}
+	err = json.Unmarshal(data, &token)
+	return token, err
+}
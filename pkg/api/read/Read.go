@@ -1,10 +1,17 @@
 package read
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
+	"personnel-api/pkg/a1"
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/cache"
+	"personnel-api/pkg/etag"
+	"personnel-api/pkg/filter"
+	"personnel-api/pkg/schema"
 	"personnel-api/pkg/svc"
 	"strconv"
 	"strings"
@@ -14,36 +21,56 @@ import (
 )
 
 // GET
-// Body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID"}
+// Query params: spreadsheetID=YOUR_SPREAD_SHEET_ID
+// Optional: limit=N&offset=N pages the data rows of every sheet; the header row is always kept
+// Deprecated body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID"}
 func GetAll(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+	req, ok := readParams(w, r)
+	if !ok {
 		return
 	}
 
-	var req map[string]string
-	err = json.Unmarshal(body, &req)
-	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+	spreadsheetID, ok := req["spreadsheetID"]
+	if !ok || spreadsheetID == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
-	spreadsheetID, ok := req["spreadsheetID"]
-	if !ok || spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+	limit, err := parseCount(req, "limit")
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "")
+		return
+	}
+	offset, err := parseCount(req, "offset")
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "")
 		return
 	}
 
 	allData, err := GetAllHelper(spreadsheetID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to retrieve data from all sheets: %v", err), http.StatusInternalServerError)
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Failed to retrieve data from all sheets")
 		return
 	}
 
+	if limit > 0 || offset > 0 {
+		for i, sheetData := range allData {
+			data := sheetData.([]interface{})
+			if len(data) == 0 {
+				continue
+			}
+			page, err := QueryRows(data, RowQuery{Limit: limit, Offset: offset})
+			if err != nil {
+				apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "")
+				return
+			}
+			allData[i] = []interface{}{page.Rows}
+		}
+	}
+
 	dataJSON, err := json.Marshal(allData)
 	if err != nil {
-		http.Error(w, "Failed to convert data to JSON", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to convert data to JSON")
 		return
 	}
 
@@ -52,20 +79,16 @@ func GetAll(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetAllHelper(spreadsheetID string) ([]interface{}, error) {
-	service, err := svc.SetupGoogleSheetsService()
+	spreadsheet, err := svc.GetBackend().GetSpreadsheet(spreadsheetID)
 	if err != nil {
-		return nil, err
-	}
-	spreadsheet, err := service.Spreadsheets.Get(spreadsheetID).Do()
-	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve spreadsheet: %v", err)
+		return nil, fmt.Errorf("failed to retrieve spreadsheet: %w", err)
 	}
 	var allData []interface{}
 
 	for _, sheet := range spreadsheet.Sheets {
 		_, sheetData, err := GetSheetDataHelper(spreadsheetID, sheet.Properties.Title)
 		if err != nil {
-			return nil, fmt.Errorf("failed to retrieve sheet data: %v", err)
+			return nil, fmt.Errorf("failed to retrieve sheet data: %w", err)
 		}
 
 		allData = append(allData, sheetData)
@@ -74,41 +97,67 @@ func GetAllHelper(spreadsheetID string) ([]interface{}, error) {
 }
 
 // GET
-// Body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetName": "SHEET_NAME"}
+// Query params: spreadsheetID=YOUR_SPREAD_SHEET_ID&sheetName=SHEET_NAME
+// Optional: format=records returns [{"COLUMN_NAME": VALUE, ...}] keyed by the header row
+// Optional: fields, filter, sort, limit, offset and pageToken as for GetRows; the number of
+// matching rows is returned in X-Total-Count and the next page token in X-Next-Page-Token
+// Deprecated body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetName": "SHEET_NAME"}
 func GetSheetData(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
-		return
-	}
-
-	var req map[string]string
-	err = json.Unmarshal(body, &req)
-	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+	req, ok := readParams(w, r)
+	if !ok {
 		return
 	}
 
 	spreadsheetID, ok := req["spreadsheetID"]
 	if !ok || spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
 	sheetName, ok := req["sheetName"]
 	if !ok || sheetName == "" {
-		http.Error(w, "sheetName field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "sheetName field is required")
+		return
 	}
 
-	_, data, err := GetSheetDataHelper(spreadsheetID, sheetName)
+	query, err := ParseRowQuery(req)
 	if err != nil {
-		http.Error(w, "failed to retrieve data from sheet", http.StatusBadRequest)
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "")
 		return
 	}
 
-	dataJSON, err := json.Marshal(data)
+	data, values, table, err := sheetDataWithValues(spreadsheetID, sheetName)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to retrieve data from sheet")
+		return
+	}
+
+	page, err := QueryRows(data, query)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "")
+		return
+	}
+	if writeETag(w, r, pageETag(values, table, page)) {
+		return
+	}
+
+	var response interface{} = data
+	if len(data) > 0 {
+
+		w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+		if page.NextPageToken != "" {
+			w.Header().Set("X-Next-Page-Token", page.NextPageToken)
+		}
+
+		response = []interface{}{page.Rows}
+		if req["format"] == "records" {
+			response = ToRecords(page.Rows)
+		}
+	}
+
+	dataJSON, err := json.Marshal(response)
 	if err != nil {
-		http.Error(w, "Failed to convert data to JSON", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to convert data to JSON")
 		return
 	}
 
@@ -116,105 +165,413 @@ func GetSheetData(w http.ResponseWriter, r *http.Request) {
 	w.Write(dataJSON)
 }
 
+// GetSheetDataHelper reads a sheet through the default cache, so repeated
+// reads of the same sheet within the cache TTL cost a single API call.
 func GetSheetDataHelper(spreadsheetID string, sheetName string) (string, []interface{}, error) {
-	service, err := svc.SetupGoogleSheetsService()
+	table, err := GetTableHelper(spreadsheetID, sheetName)
 	if err != nil {
 		return "", nil, err
 	}
 
-	spreadsheet, err := service.Spreadsheets.Values.Get(spreadsheetID, sheetName).Do()
+	var allData []interface{}
+	if table == nil {
+		return "", allData, nil
+	}
+	allData = append(allData, table.Rows)
+
+	return table.ColumnRange(), allData, nil
+}
+
+// Table is the block of data found on a sheet: the rows from the first
+// non-empty one, which is the header, cut to start at the header's first
+// non-empty column.
+type Table struct {
+	FirstRow    int
+	FirstColumn int
+	Rows        [][]interface{}
+}
+
+// GetTableHelper reads a sheet through the default cache and locates its
+// data. It returns nil for an empty sheet.
+func GetTableHelper(spreadsheetID string, sheetName string) (*Table, error) {
+	values, err := fetchValues(spreadsheetID, sheetName)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to retrieve spreadsheet: %v", err)
+		return nil, err
 	}
+	return newTable(values), nil
+}
 
-	var allData []interface{}
+func fetchValues(spreadsheetID string, sheetName string) ([][]interface{}, error) {
+	values, err := cache.Default().Fetch(spreadsheetID, sheetName, func() ([][]interface{}, error) {
+		valueRange, err := svc.GetBackend().GetValues(spreadsheetID, sheetName)
+		if err != nil {
+			return nil, err
+		}
+		return valueRange.Values, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve spreadsheet: %w", err)
+	}
+	return values, nil
+}
 
-	if len(spreadsheet.Values) == 0 {
-		return "", allData, nil
+// sheetDataWithValues reads a sheet like GetSheetDataHelper and also returns
+// its values and table, from which pageETag computes the ETag of a page.
+func sheetDataWithValues(spreadsheetID string, sheetName string) ([]interface{}, [][]interface{}, *Table, error) {
+	values, err := fetchValues(spreadsheetID, sheetName)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	var sheetData []interface{}
+	table := newTable(values)
+	if table != nil {
+		sheetData = append(sheetData, table.Rows)
+	}
+	return sheetData, values, table, nil
+}
+
+// pageETag returns the ETag of a page of table: it covers the header row and
+// the data rows the page was read from, so edits to other rows leave it as it
+// was. A write sent with it in If-Match re-checks the same rows.
+func pageETag(values [][]interface{}, table *Table, page *RowPage) string {
+	if table == nil {
+		return etag.Compute(values, etag.Rows{First: 1})
+	}
+	header := etag.Rows{First: table.FirstRow, Last: table.FirstRow}
+	data := etag.Rows{First: table.RowNumber(page.From)}
+	if page.To != 0 {
+		data.Last = table.RowNumber(page.To)
+	}
+	return etag.Compute(values, header, data)
+}
+
+// writeETag sets the ETag header and answers 304 Not Modified, returning
+// true, when the client's If-None-Match already names it.
+func writeETag(w http.ResponseWriter, r *http.Request, tag string) bool {
+	w.Header().Set("ETag", tag)
+	if header := r.Header.Get("If-None-Match"); header != "" && etag.Match(header, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// newTable locates the data in the values of a sheet. It returns nil for an
+// empty sheet.
+func newTable(values [][]interface{}) *Table {
+	if len(values) == 0 {
+		return nil
 	}
 
 	startRow := 0
 	startColumn := 0
 
-	if len(spreadsheet.Values) > 0 {
-		for i, row := range spreadsheet.Values {
-			if len(row) > 0 {
-				startRow = i
+	for i, row := range values {
+		if len(row) > 0 {
+			startRow = i
+			break
+		}
+	}
+
+	if startRow > 0 {
+		for j, value := range values[startRow] {
+			if value != nil && value != "" {
+				startColumn = j
 				break
 			}
 		}
+	}
 
-		if startRow > 0 {
-			for j, value := range spreadsheet.Values[startRow] {
-				if value != nil && value != "" {
-					startColumn = j
-					break
-				}
+	// cut the rows into a new slice, leaving the cached values as the backend
+	// returned them for the ETags computed from them
+	data := make([][]interface{}, len(values)-startRow)
+	for i, row := range values[startRow:] {
+		if len(row) < startColumn {
+			row = row[:0]
+		} else {
+			row = row[startColumn:]
+		}
+		data[i] = row
+	}
+
+	return &Table{FirstRow: startRow + 1, FirstColumn: startColumn, Rows: data}
+}
+
+// ColumnRange returns the columns spanned by the header, e.g. A:C.
+func (t *Table) ColumnRange() string {
+	return a1.ColumnLetter(t.FirstColumn) + ":" + a1.ColumnLetter(t.FirstColumn+len(t.Rows[0])-1)
+}
+
+// RowNumber returns the sheet row number of Rows[i].
+func (t *Table) RowNumber(i int) int {
+	return t.FirstRow + i
+}
+
+// LastRow returns the sheet row number of the last row of data.
+func (t *Table) LastRow() int {
+	return t.RowNumber(len(t.Rows) - 1)
+}
+
+// KeyRows returns the sheet row number of the one data row whose value in
+// columnName equals each key. A key matching no row is a 404 and a key
+// matching several rows a 409, as the caller cannot tell which was meant.
+func (t *Table) KeyRows(columnName string, keys []interface{}) ([]int, error) {
+	var rows []int
+	for _, key := range keys {
+		matches, err := t.MatchRows(columnName, key)
+		if err != nil {
+			return nil, err
+		}
+		switch len(matches) {
+		case 0:
+			return nil, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "no row found with %v = %v", columnName, key)
+		case 1:
+			rows = append(rows, t.RowNumber(matches[0]))
+		default:
+			return nil, apierror.New(http.StatusConflict, apierror.CodeConflict, "%d rows found with %v = %v", len(matches), columnName, key)
+		}
+	}
+	return rows, nil
+}
+
+// RowsByKeyHelper locates the row of each key, see Table.KeyRows, and returns
+// the row numbers in the form the row update and delete helpers take.
+func RowsByKeyHelper(spreadsheetID string, sheetName string, keyColumn string, keys []interface{}) ([]interface{}, error) {
+	table, err := GetTableHelper(spreadsheetID, sheetName)
+	if err != nil {
+		return nil, err
+	}
+	if table == nil {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "sheet has no header row")
+	}
+
+	rows, err := table.KeyRows(keyColumn, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	dataRange := make([]interface{}, len(rows))
+	for i, row := range rows {
+		dataRange[i] = row
+	}
+	return dataRange, nil
+}
+
+// ValidateRows checks rows about to be written against the schema registered
+// for the sheet, if any, before they are sent to Google. rowNumbers holds the
+// sheet row each row overwrites, or 0 for a new row. table may be nil, in
+// which case the sheet is read only if it has a schema.
+func ValidateRows(spreadsheetID string, sheetName string, table *Table, rowNumbers []int, rows [][]interface{}) error {
+	sc := schema.Default().Lookup(spreadsheetID, sheetName)
+	if sc == nil {
+		return nil
+	}
+
+	if table == nil {
+		var err error
+		if table, err = GetTableHelper(spreadsheetID, sheetName); err != nil {
+			return err
+		}
+		if table == nil {
+			return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "sheet has no header row")
+		}
+	}
+
+	replaces := make([]int, len(rows))
+	for i := range rows {
+		replaces[i] = -1
+		if i < len(rowNumbers) {
+			if idx := rowNumbers[i] - table.FirstRow; idx >= 1 && idx < len(table.Rows) {
+				replaces[i] = idx - 1
 			}
 		}
 	}
 
-	data := spreadsheet.Values[startRow:]
-	for i, row := range data {
-		data[i] = row[startColumn:]
+	return sc.Validate(table.Rows[0], table.Rows[1:], rows, replaces)
+}
+
+// Cell is a value written to a single cell, at a 1-based sheet row and a
+// 0-based sheet column.
+type Cell struct {
+	Row    int
+	Column int
+	Value  interface{}
+}
+
+// ValidateCells applies cells to the current values of their rows and checks
+// the resulting rows with ValidateRows.
+func ValidateCells(spreadsheetID string, sheetName string, cells []Cell) error {
+	if schema.Default().Lookup(spreadsheetID, sheetName) == nil {
+		return nil
+	}
+
+	table, err := GetTableHelper(spreadsheetID, sheetName)
+	if err != nil {
+		return err
+	}
+	if table == nil {
+		return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "sheet has no header row")
 	}
-	allData = append(allData, data)
 
-	dataRange := ColumnIndexToLetter(startColumn) + ":" + ColumnIndexToLetter(startColumn+len(data[0])-1)
-	return dataRange, allData, nil
+	var rowNumbers []int
+	var rows [][]interface{}
+	byRow := map[int]int{}
+	for _, cell := range cells {
+		column := cell.Column - table.FirstColumn
+		if cell.Row <= table.FirstRow || column < 0 {
+			return apierror.New(http.StatusBadRequest, apierror.CodeInvalidRange, "cell %d,%d is outside the data of the sheet", cell.Row, cell.Column)
+		}
+
+		i, ok := byRow[cell.Row]
+		if !ok {
+			var row []interface{}
+			if idx := cell.Row - table.FirstRow; idx < len(table.Rows) {
+				row = append(row, table.Rows[idx]...)
+			}
+			i = len(rows)
+			byRow[cell.Row] = i
+			rowNumbers = append(rowNumbers, cell.Row)
+			rows = append(rows, row)
+		}
+		for len(rows[i]) <= column {
+			rows[i] = append(rows[i], "")
+		}
+		rows[i][column] = cell.Value
+	}
+
+	return ValidateRows(spreadsheetID, sheetName, table, rowNumbers, rows)
 }
 
-func ColumnIndexToLetter(index int) string {
-	var result string
-	for {
-		result = string(rune('A'+(index%26))) + result
-		if index /= 26; index <= 0 {
+// MatchRows returns the indexes in Rows of the data rows whose value in
+// columnName equals value.
+func (t *Table) MatchRows(columnName string, value interface{}) ([]int, error) {
+	columnIdx := -1
+	for i, name := range t.Rows[0] {
+		if name == columnName {
+			columnIdx = i
 			break
 		}
 	}
-	return result
+	if columnIdx == -1 {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeColumnNotFound, "no column found with that name: %v", columnName)
+	}
+
+	want := fmt.Sprint(value)
+	var matches []int
+	for i, row := range t.Rows[1:] {
+		if columnIdx < len(row) && fmt.Sprint(row[columnIdx]) == want {
+			matches = append(matches, i+1)
+		}
+	}
+	return matches, nil
 }
 
-// GET
-// Body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetName": "SHEET_NAME", "columnName": "COLUMN_NAME"}
-func GetByColumn(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
-		return
+// Header returns the header row of data returned by GetSheetDataHelper, the
+// first non-empty row of the sheet.
+func Header(sheetData []interface{}) ([]interface{}, error) {
+	if len(sheetData) == 0 || len(sheetData[0].([][]interface{})) == 0 {
+		return nil, fmt.Errorf("sheet has no header row")
 	}
 
-	var req map[string]string
-	err = json.Unmarshal(body, &req)
-	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+	return sheetData[0].([][]interface{})[0], nil
+}
+
+// ToRecords converts rows whose first element is the header into objects
+// keyed by column name. Columns without a name are left out and missing cells
+// are returned as empty strings.
+func ToRecords(rows []interface{}) []interface{} {
+	records := []interface{}{}
+	if len(rows) == 0 {
+		return records
+	}
+
+	header := rows[0].([]interface{})
+	for _, row := range rows[1:] {
+		cells := row.([]interface{})
+		record := map[string]interface{}{}
+		for i, name := range header {
+			columnName := fmt.Sprint(name)
+			if columnName == "" {
+				continue
+			}
+			if i < len(cells) {
+				record[columnName] = cells[i]
+			} else {
+				record[columnName] = ""
+			}
+		}
+		records = append(records, record)
+	}
+
+	return records
+}
+
+// FromRecord builds a positional row in header order from a record keyed by
+// column name. Cells not present in the record are taken from base, which may
+// be nil for a new row.
+func FromRecord(header []interface{}, record map[string]interface{}, base []interface{}) ([]interface{}, error) {
+	row := make([]interface{}, len(header))
+	for i := range row {
+		row[i] = ""
+		if i < len(base) {
+			row[i] = base[i]
+		}
+	}
+
+	for key, value := range record {
+		columnIdx := -1
+		for i, name := range header {
+			if fmt.Sprint(name) == key {
+				columnIdx = i
+				break
+			}
+		}
+		if columnIdx == -1 {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeColumnNotFound, "no column found with that name: %v", key)
+		}
+		row[columnIdx] = value
+	}
+
+	return row, nil
+}
+
+// GET
+// Query params: spreadsheetID=YOUR_SPREAD_SHEET_ID&sheetName=SHEET_NAME&columnName=COLUMN_NAME
+// Deprecated body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetName": "SHEET_NAME", "columnName": "COLUMN_NAME"}
+func GetByColumn(w http.ResponseWriter, r *http.Request) {
+	req, ok := readParams(w, r)
+	if !ok {
 		return
 	}
 
 	spreadsheetID, ok := req["spreadsheetID"]
 	if !ok || spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
 	sheetName, ok := req["sheetName"]
 	if !ok || sheetName == "" {
-		http.Error(w, "sheetName field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "sheetName field is required")
+		return
 	}
 
 	columnName, ok := req["columnName"]
 	if !ok || columnName == "" {
-		http.Error(w, "sheetName field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "columnName field is required")
+		return
 	}
 
 	_, data, err := GetByColumnHelper(spreadsheetID, sheetName, columnName)
 	if err != nil {
-		http.Error(w, "cannot extract column data", http.StatusInternalServerError)
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Cannot extract column data")
+		return
 	}
 
 	dataJSON, err := json.Marshal(data)
 	if err != nil {
-		http.Error(w, "Failed to convert data to JSON", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to convert data to JSON")
 		return
 	}
 
@@ -225,7 +582,17 @@ func GetByColumn(w http.ResponseWriter, r *http.Request) {
 func GetByColumnHelper(spreadsheetID string, sheetName string, columnName string) (int, []interface{}, error) {
 	_, sheetData, err := GetSheetDataHelper(spreadsheetID, sheetName)
 	if err != nil {
-		return -1, nil, fmt.Errorf("failed to retrieve spreadsheet data: %v", err)
+		return -1, nil, fmt.Errorf("failed to retrieve spreadsheet data: %w", err)
+	}
+
+	return ExtractColumn(sheetData, columnName)
+}
+
+// ExtractColumn finds columnName in the header row of data returned by
+// GetSheetDataHelper and returns its index along with every value in it.
+func ExtractColumn(sheetData []interface{}, columnName string) (int, []interface{}, error) {
+	if len(sheetData) == 0 || len(sheetData[0].([][]interface{})) == 0 {
+		return -1, nil, fmt.Errorf("sheet has no data")
 	}
 
 	columnIdx := -1
@@ -238,7 +605,7 @@ func GetByColumnHelper(spreadsheetID string, sheetName string, columnName string
 	}
 
 	if columnIdx == -1 {
-		return -1, nil, fmt.Errorf("no column found with that name: %v", err)
+		return -1, nil, apierror.New(http.StatusBadRequest, apierror.CodeColumnNotFound, "no column found with that name: %v", columnName)
 	}
 
 	var allData []interface{}
@@ -255,55 +622,67 @@ func GetByColumnHelper(spreadsheetID string, sheetName string, columnName string
 }
 
 // GET
-// Body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetName": "SHEET_NAME", "columnName": "COLUMN_NAME", "operator": "OP", "value": "VALUE"}
+// Query params: spreadsheetID=YOUR_SPREAD_SHEET_ID&sheetName=SHEET_NAME&filter=EXPRESSION
+// EXPRESSION uses the filter package syntax, e.g. Score >= 9 AND Name startsWith 'te' NOCASE
+// Legacy single-column form: spreadsheetID=YOUR_SPREAD_SHEET_ID&sheetName=SHEET_NAME&columnName=COLUMN_NAME&operator=OP&value=VALUE
+// Deprecated body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetName": "SHEET_NAME", "columnName": "COLUMN_NAME", "operator": "OP", "value": "VALUE"}
 func GetByFilter(w http.ResponseWriter, r *http.Request) {
-	body, err := io.ReadAll(r.Body)
-	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
-		return
-	}
-
-	var req map[string]string
-	err = json.Unmarshal(body, &req)
-	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+	req, ok := readParams(w, r)
+	if !ok {
 		return
 	}
 
 	spreadsheetID, ok := req["spreadsheetID"]
 	if !ok || spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
 	sheetName, ok := req["sheetName"]
 	if !ok || sheetName == "" {
-		http.Error(w, "sheetName field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "sheetName field is required")
+		return
+	}
+
+	if expression := req["filter"]; expression != "" {
+		filteredData, err := GetByExpressionHelper(spreadsheetID, sheetName, expression)
+		if err != nil {
+			apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Cannot filter column data")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(filteredData)
+		return
 	}
 
 	columnName, ok := req["columnName"]
 	if !ok || columnName == "" {
-		http.Error(w, "sheetName field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "columnName field is required")
+		return
 	}
 
 	operator, ok := req["operator"]
 	if !ok || operator == "" {
-		http.Error(w, "operator field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "operator field is required")
+		return
 	}
 
 	value, ok := req["value"]
 	if !ok || value == "" {
-		http.Error(w, "value field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "value field is required")
+		return
 	}
 
 	filteredData, err := GetByFilterHelper(spreadsheetID, sheetName, columnName, operator, value)
 	if err != nil {
-		http.Error(w, "cannot filter column data", http.StatusInternalServerError)
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Cannot filter column data")
+		return
 	}
 
 	dataJSON, err := json.Marshal(filteredData)
 	if err != nil {
-		http.Error(w, "Failed to convert data to JSON", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to convert data to JSON")
 		return
 	}
 
@@ -311,15 +690,55 @@ func GetByFilter(w http.ResponseWriter, r *http.Request) {
 	w.Write(dataJSON)
 }
 
+// GetByExpressionHelper returns the header row and every row matching a
+// filter expression.
+func GetByExpressionHelper(spreadsheetID string, sheetName string, expression string) ([]interface{}, error) {
+	_, sheetData, err := GetSheetDataHelper(spreadsheetID, sheetName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve sheet data: %w", err)
+	}
+
+	return FilterRowsByExpression(sheetData, expression)
+}
+
+// FilterRowsByExpression keeps the header row of data returned by
+// GetSheetDataHelper and every row matching expression.
+func FilterRowsByExpression(sheetData []interface{}, expression string) ([]interface{}, error) {
+	header, err := Header(sheetData)
+	if err != nil {
+		return nil, err
+	}
+
+	expr, err := filter.Compile(expression, header)
+	if err != nil {
+		return nil, err
+	}
+
+	filteredData := []interface{}{header}
+	for _, row := range sheetData[0].([][]interface{})[1:] {
+		if expr.Match(row) {
+			filteredData = append(filteredData, row)
+		}
+	}
+
+	return filteredData, nil
+}
+
 func GetByFilterHelper(spreadsheetID string, sheetName string, columnName string, operator string, value string) ([]interface{}, error) {
 	_, sheetData, err := GetSheetDataHelper(spreadsheetID, sheetName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve sheet data: %v", err)
+		return nil, fmt.Errorf("failed to retrieve sheet data: %w", err)
 	}
 
-	columnIdx, columnData, err := GetByColumnHelper(spreadsheetID, sheetName, columnName)
+	return FilterRows(sheetData, columnName, operator, value)
+}
+
+// FilterRows keeps the header row of data returned by GetSheetDataHelper and
+// every row whose columnName value satisfies operator and value.
+func FilterRows(sheetData []interface{}, columnName string, operator string, value string) ([]interface{}, error) {
+	columnIdx, columnData, err := ExtractColumn(sheetData, columnName)
 	if err != nil {
-		return nil, fmt.Errorf("failed to retrieve column data: %v", err)
+		return nil, fmt.Errorf("failed to retrieve column data: %w", err)
 	}
 
 	dataType := 0
@@ -338,14 +757,14 @@ func GetByFilterHelper(spreadsheetID string, sheetName string, columnName string
 	} else {
 		dataType, err = CheckStringType(columnData[1].(string))
 		if err != nil {
-			return nil, fmt.Errorf("unknown type: %v", err)
+			return nil, fmt.Errorf("unknown type: %w", err)
 		}
 		valueType, err = CheckStringType(value)
 		if err != nil {
-			return nil, fmt.Errorf("unknown type: %v", err)
+			return nil, fmt.Errorf("unknown type: %w", err)
 		}
 		if dataType != 3 && valueType != dataType {
-			return nil, fmt.Errorf("incorrect value type: %v", err)
+			return nil, fmt.Errorf("incorrect value type: %v", value)
 		}
 	}
 
@@ -419,25 +838,146 @@ func CheckStringType(value string) (int, error) {
 	return 3, nil
 }
 
+/*
+GET /v1/spreadsheets/{spreadsheetID}/sheets/{sheetName}/rows
+Query params (optional): fields=COLUMN_NAME,...&filter=EXPRESSION&sort=COLUMN_NAME:desc,...&limit=N&offset=N&pageToken=TOKEN&format=records
+EXPRESSION uses the filter package syntax, e.g. filter=Score>9.5 or
+filter=Score between 9 and 10 AND (Email contains .com OR Name in ('a', 'b') NOCASE)
+sort=-Score,Name is the same as sort=Score:desc,Name:asc; numbers and dates compare by value
+limit defaults to DefaultPageSize; pass nextPageToken from the response as pageToken for the next page
+column=COLUMN_NAME is an alias for fields with a single column
+format=records returns each row as an object keyed by the header row instead of an array
+*/
+func GetRows(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	params := map[string]string{}
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 {
+			params[key] = values[0]
+		}
+	}
+
+	spreadsheetID := params["spreadsheetID"]
+	if spreadsheetID == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID parameter is required")
+		return
+	}
+
+	sheetName := params["sheetName"]
+	if sheetName == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "sheetName parameter is required")
+		return
+	}
+
+	format := params["format"]
+	if format != "" && format != "rows" && format != "records" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "format must be rows or records")
+		return
+	}
+
+	query, err := ParseRowQuery(params)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "")
+		return
+	}
+	if query.Limit == 0 {
+		query.Limit = DefaultPageSize
+	}
+
+	sheetData, values, table, err := sheetDataWithValues(spreadsheetID, sheetName)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Failed to retrieve sheet data")
+		return
+	}
+
+	page, err := QueryRows(sheetData, query)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "")
+		return
+	}
+	if writeETag(w, r, pageETag(values, table, page)) {
+		return
+	}
+
+	rows := page.Rows
+	if format == "records" {
+		rows = ToRecords(rows)
+	}
+
+	response := struct {
+		SpreadsheetID string        `json:"spreadsheetID"`
+		SheetName     string        `json:"sheetName"`
+		Rows          []interface{} `json:"rows"`
+		Total         int           `json:"total"`
+		Offset        int           `json:"offset"`
+		Limit         int           `json:"limit"`
+		NextPageToken string        `json:"nextPageToken,omitempty"`
+	}{
+		SpreadsheetID: spreadsheetID,
+		SheetName:     sheetName,
+		Rows:          rows,
+		Total:         page.Total,
+		Offset:        page.Offset,
+		Limit:         query.Limit,
+		NextPageToken: page.NextPageToken,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// readParams collects the request fields from the query string. The JSON body
+// sent by older clients on GET requests is still read, but query parameters
+// take precedence over it.
+func readParams(w http.ResponseWriter, r *http.Request) (map[string]string, bool) {
+	req := map[string]string{}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
+		return nil, false
+	}
+
+	if len(bytes.TrimSpace(body)) > 0 {
+		err = json.Unmarshal(body, &req)
+		if err != nil {
+			apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
+			return nil, false
+		}
+	}
+
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 && values[0] != "" {
+			req[key] = values[0]
+		}
+	}
+
+	return req, true
+}
+
 /*
 GET
 Query params: spreadsheetID=YOUR_SPREAD_SHEET_ID
 */
 func GetSheets(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	spreadsheetID := r.URL.Query().Get("spreadsheetID")
 	if spreadsheetID == "" {
-		http.Error(w, "spreadsheetID parameter is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID parameter is required")
 		return
 	}
 
 	sheets, err := GetSheetsHelper(spreadsheetID)
 	if err != nil {
-		http.Error(w, "Cannot get sheets info: "+err.Error(), http.StatusInternalServerError)
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Cannot get sheets info")
 		return
 	}
 
@@ -474,17 +1014,27 @@ func GetSheets(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetSheetsHelper(spreadsheetID string) ([]*sheets.Sheet, error) {
-	service, err := svc.SetupGoogleSheetsService()
+	spreadsheet, err := svc.GetBackend().GetSpreadsheet(spreadsheetID)
 	if err != nil {
 		return nil, err
 	}
 
-	spreadsheet, err := service.Spreadsheets.Get(spreadsheetID).Do()
+	return spreadsheet.Sheets, nil
+}
+
+// SheetID looks up the id of a sheet by title, which row and column requests
+// of a batch update need in place of the name.
+func SheetID(spreadsheetID string, sheetName string) (int64, error) {
+	sheets, err := GetSheetsHelper(spreadsheetID)
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-
-	return spreadsheet.Sheets, nil
+	for _, sheet := range sheets {
+		if sheet.Properties != nil && strings.EqualFold(sheet.Properties.Title, sheetName) {
+			return sheet.Properties.SheetId, nil
+		}
+	}
+	return 0, apierror.New(http.StatusNotFound, apierror.CodeSheetNotFound, "no sheet found with that name: %v", sheetName)
 }
 
 // GET
@@ -492,13 +1042,13 @@ func GetSheetsHelper(spreadsheetID string) ([]*sheets.Sheet, error) {
 // Returns a list of all spreadsheets accessible to the user
 func ListAllSpreadsheets(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	spreadsheets, err := ListAllSpreadsheetsHelper()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to list spreadsheets: %v", err), http.StatusInternalServerError)
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Failed to list spreadsheets")
 		return
 	}
 
@@ -537,25 +1087,12 @@ func ListAllSpreadsheets(w http.ResponseWriter, r *http.Request) {
 }
 
 func ListAllSpreadsheetsHelper() ([]*drive.File, error) {
-	service, err := svc.SetupGoogleDriveService()
+	files, err := svc.GetBackend().ListSpreadsheets()
 	if err != nil {
-		return nil, fmt.Errorf("failed to setup Google Drive service: %v", err)
+		return nil, fmt.Errorf("failed to list spreadsheets: %w", err)
 	}
 
-	// Query to find all Google Sheets files
-	query := "mimeType='application/vnd.google-apps.spreadsheet'"
-
-	// List all spreadsheets
-	results, err := service.Files.List().
-		Q(query).
-		Fields("files(id, name, createdTime, modifiedTime)").
-		OrderBy("modifiedTime desc").
-		Do()
-	if err != nil {
-		return nil, fmt.Errorf("failed to list spreadsheets: %v", err)
-	}
-
-	return results.Files, nil
+	return files, nil
 }
 
 // GET
@@ -563,19 +1100,19 @@ func ListAllSpreadsheetsHelper() ([]*drive.File, error) {
 // Returns basic information about a specific spreadsheet
 func GetSpreadsheetById(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	spreadsheetID := r.URL.Query().Get("spreadsheetID")
 	if spreadsheetID == "" {
-		http.Error(w, "spreadsheetID parameter is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID parameter is required")
 		return
 	}
 
 	spreadsheet, err := GetSpreadsheetByIdHelper(spreadsheetID)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to get spreadsheet info: %v", err), http.StatusInternalServerError)
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Failed to get spreadsheet info")
 		return
 	}
 
@@ -592,15 +1129,45 @@ func GetSpreadsheetById(w http.ResponseWriter, r *http.Request) {
 }
 
 func GetSpreadsheetByIdHelper(spreadsheetID string) (*sheets.Spreadsheet, error) {
-	service, err := svc.SetupGoogleSheetsService()
+	spreadsheet, err := svc.GetBackend().GetSpreadsheet(spreadsheetID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to setup Google Sheets service: %v", err)
+		return nil, fmt.Errorf("failed to get spreadsheet: %w", err)
 	}
 
-	spreadsheet, err := service.Spreadsheets.Get(spreadsheetID).Fields("spreadsheetId,properties(title)").Do()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get spreadsheet: %v", err)
+	return spreadsheet, nil
+}
+
+/*
+GET /v1/cache/stats
+Returns the hit, miss, eviction and invalidation counts of the sheet cache
+along with the number of cached sheets and cells.
+*/
+func GetCacheStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
 	}
 
-	return spreadsheet, nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cache.Default().Stats())
+}
+
+/*
+GET /v1/backend/stats
+Returns per-operation call, retry, failure and throttling counts of the
+Google API calls made by the backend, e.g. {"values.get": {"calls": 12, ...}}.
+*/
+func GetBackendStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	stats := map[string]svc.OperationStats{}
+	if reporter, ok := svc.GetBackend().(svc.StatsReporter); ok {
+		stats = reporter.Stats()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
 }
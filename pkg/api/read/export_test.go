@@ -0,0 +1,122 @@
+package read
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"personnel-api/pkg/xlsx"
+)
+
+func TestExportSheet(t *testing.T) {
+	base := "/export?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet2"
+	cases := []struct {
+		name        string
+		url         string
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"csv by default", base, "", http.StatusOK, "text/csv; charset=utf-8", "ID,Name,Score\n1,test1,9.8\n2,test2,8.5\n3,test3,9.9\n"},
+		{"tsv by accept", base, "text/html, text/tab-separated-values;q=0.9, text/csv;q=0.5", http.StatusOK, "text/tab-separated-values; charset=utf-8", "ID\tName\tScore\n1\ttest1\t9.8\n2\ttest2\t8.5\n3\ttest3\t9.9\n"},
+		{"format overrides accept", base + "&format=csv", "text/tab-separated-values", http.StatusOK, "text/csv; charset=utf-8", "ID,Name,Score\n1,test1,9.8\n2,test2,8.5\n3,test3,9.9\n"},
+		{"filtered and projected", base + "&fields=Name,Score&sort=-Score&filter=" + url.QueryEscape("Score>9.5"), "", http.StatusOK, "text/csv; charset=utf-8", "Name,Score\ntest3,9.9\ntest1,9.8\n"},
+		{"not acceptable", base, "application/json", http.StatusNotAcceptable, "", ""},
+		{"unknown format", base + "&format=pdf", "", http.StatusBadRequest, "", ""},
+		{"unknown column", base + "&fields=Phone", "", http.StatusBadRequest, "", ""},
+		{"unknown sheet", "/export?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Missing", "", http.StatusNotFound, "", ""},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, c.url, nil)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		res := httptest.NewRecorder()
+		ExportSheet(res, req)
+
+		if res.Code != c.status {
+			t.Errorf("%s: expected status code %d but got %d: %s", c.name, c.status, res.Code, res.Body.String())
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		if got := res.Header().Get("Content-Type"); got != c.contentType {
+			t.Errorf("%s: expected Content-Type %q but got %q", c.name, c.contentType, got)
+		}
+		if got := res.Body.String(); got != c.body {
+			t.Errorf("%s: expected body %q but got %q", c.name, c.body, got)
+		}
+	}
+}
+
+func TestExportSheetXLSX(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/export?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1", nil)
+	req.Header.Set("Accept", xlsx.ContentType)
+	res := httptest.NewRecorder()
+	ExportSheet(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	if got := res.Header().Get("Content-Disposition"); got != `attachment; filename="Personnel - Sheet1.xlsx"` {
+		t.Errorf("Unexpected Content-Disposition %q", got)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(res.Body.Bytes()), int64(res.Body.Len()))
+	if err != nil {
+		t.Fatalf("Expected a zip archive: %v", err)
+	}
+	var sheet string
+	for _, f := range archive.File {
+		if f.Name != "xl/worksheets/sheet1.xml" {
+			continue
+		}
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		sheet = string(data)
+	}
+	for _, want := range []string{`<c r="A1" t="inlineStr"><is><t xml:space="preserve">ID</t></is></c>`, `<c r="A2"><v>1</v></c>`, "test1@gmail.com"} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("Expected the worksheet to contain %s but got %s", want, sheet)
+		}
+	}
+}
+
+func TestExportEscapesFormulas(t *testing.T) {
+	var buf bytes.Buffer
+	rows := []interface{}{
+		[]interface{}{"Name", "Note"},
+		[]interface{}{"=HYPERLINK(\"http://x\")", "+cmd|' /C calc'!A0"},
+		[]interface{}{"@SUM(A1)", "-1.5"},
+	}
+	if err := ExportRowsHelper(&buf, ExportCSV, "Sheet1", rows); err != nil {
+		t.Fatalf("ExportRowsHelper returned error: %v", err)
+	}
+
+	want := "Name,Note\n\"'=HYPERLINK(\"\"http://x\"\")\",'+cmd|' /C calc'!A0\n'@SUM(A1),-1.5\n"
+	if got := buf.String(); got != want {
+		t.Errorf("Expected %q but got %q", want, got)
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	cases := map[string]string{
+		"Personnel - Sheet1.csv": `attachment; filename="Personnel - Sheet1.csv"`,
+		`Q1/Q2 "final".csv`:      `attachment; filename="Q1_Q2 _final_.csv"`,
+		"Nhân sự - Sheet1.csv":   `attachment; filename="Nh_n s_ - Sheet1.csv"; filename*=UTF-8''Nh%C3%A2n%20s%E1%BB%B1%20-%20Sheet1.csv`,
+	}
+	for filename, want := range cases {
+		if got := ContentDisposition(filename); got != want {
+			t.Errorf("ContentDisposition(%q): expected %q but got %q", filename, want, got)
+		}
+	}
+}
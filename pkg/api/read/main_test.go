@@ -0,0 +1,14 @@
+package read
+
+import (
+	"os"
+	"testing"
+
+	"personnel-api/pkg/svc"
+	"personnel-api/pkg/svc/svctest"
+)
+
+func TestMain(m *testing.M) {
+	svc.SetBackend(svctest.NewBackend())
+	os.Exit(m.Run())
+}
@@ -6,7 +6,16 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
 	"testing"
+
+	"personnel-api/pkg/cache"
+	"personnel-api/pkg/svc"
+	"personnel-api/pkg/svc/svctest"
+
+	"google.golang.org/api/sheets/v4"
 )
 
 type errorReader struct{}
@@ -62,8 +71,8 @@ func TestGetAll(t *testing.T) {
 
 	GetAll(res_err, req_err)
 
-	if res_err.Code != http.StatusInternalServerError {
-		t.Errorf("Expected status code %d but got %d", http.StatusInternalServerError, res_err.Code)
+	if res_err.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res_err.Code)
 	}
 }
 
@@ -182,8 +191,8 @@ func TestGetByColumn(t *testing.T) {
 
 	GetByColumn(res_err, req_err)
 
-	if res_err.Code != http.StatusInternalServerError {
-		t.Errorf("Expected status code %d but got %d", http.StatusInternalServerError, res_err.Code)
+	if res_err.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res_err.Code)
 	}
 }
 
@@ -381,8 +390,8 @@ func TestGetByFilter(t *testing.T) {
 
 	GetByFilter(res_err, req_err)
 
-	if res_err.Code != http.StatusInternalServerError {
-		t.Errorf("Expected status code %d but got %d", http.StatusInternalServerError, res_err.Code)
+	if res_err.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res_err.Code)
 	}
 
 	res_err = httptest.NewRecorder()
@@ -475,8 +484,8 @@ func TestGetSheets(t *testing.T) {
 	req_err = httptest.NewRequest(http.MethodGet, "/GetSheets?spreadsheetID=invalid", nil)
 	GetSheets(res_err, req_err)
 
-	if res_err.Code != http.StatusInternalServerError {
-		t.Errorf("Expected status code %d but got %d", http.StatusInternalServerError, res_err.Code)
+	if res_err.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res_err.Code)
 	}
 
 	// Test wrong method
@@ -488,3 +497,348 @@ func TestGetSheets(t *testing.T) {
 		t.Errorf("Expected status code %d but got %d", http.StatusMethodNotAllowed, res_err.Code)
 	}
 }
+
+func TestGetSheetDataQueryParams(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/GetSheetData?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet2", nil)
+	res := httptest.NewRecorder()
+
+	GetSheetData(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d", http.StatusOK, res.Code)
+	}
+	if !strings.Contains(res.Body.String(), "Score") {
+		t.Errorf("Expected Sheet2 data but got %s", res.Body.String())
+	}
+
+	// query params take precedence over the deprecated body
+	req = httptest.NewRequest(http.MethodGet, "/GetByColumn?columnName=Name", bytes.NewReader([]byte(`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "columnName": "Email"}`)))
+	res = httptest.NewRecorder()
+
+	GetByColumn(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d", http.StatusOK, res.Code)
+	}
+	if strings.Contains(res.Body.String(), "gmail.com") {
+		t.Errorf("Expected Name column but got %s", res.Body.String())
+	}
+}
+
+func TestGetRows(t *testing.T) {
+	cases := []struct {
+		name   string
+		url    string
+		status int
+		rows   [][]interface{}
+	}{
+		{"all rows", "/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet2", http.StatusOK, [][]interface{}{
+			{"ID", "Name", "Score"}, {"1", "test1", "9.8"}, {"2", "test2", "8.5"}, {"3", "test3", "9.9"},
+		}},
+		{"column", "/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet2&column=Name", http.StatusOK, [][]interface{}{
+			{"Name"}, {"test1"}, {"test2"}, {"test3"},
+		}},
+		{"filter", "/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet2&filter=" + url.QueryEscape("Score>9.5"), http.StatusOK, [][]interface{}{
+			{"ID", "Name", "Score"}, {"1", "test1", "9.8"}, {"3", "test3", "9.9"},
+		}},
+		{"filter and column", "/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1&column=ID&filter=" + url.QueryEscape("Email contain test2"), http.StatusOK, [][]interface{}{
+			{"ID"}, {"2"},
+		}},
+		{"missing sheetName", "/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", http.StatusBadRequest, nil},
+		{"invalid filter", "/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1&filter=ID", http.StatusBadRequest, nil},
+		{"unknown column", "/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1&column=Phone", http.StatusBadRequest, nil},
+		{"unknown spreadsheet", "/rows?spreadsheetID=error&sheetName=Sheet1", http.StatusNotFound, nil},
+		{"unknown sheet", "/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Missing", http.StatusNotFound, nil},
+	}
+
+	for _, c := range cases {
+		res := httptest.NewRecorder()
+		GetRows(res, httptest.NewRequest(http.MethodGet, c.url, nil))
+
+		if res.Code != c.status {
+			t.Errorf("%s: expected status code %d but got %d", c.name, c.status, res.Code)
+			continue
+		}
+		if c.rows == nil {
+			continue
+		}
+
+		var response struct {
+			Rows [][]interface{} `json:"rows"`
+		}
+		if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", c.name, err)
+		}
+		if !reflect.DeepEqual(response.Rows, c.rows) {
+			t.Errorf("%s: expected rows %v but got %v", c.name, c.rows, response.Rows)
+		}
+	}
+}
+
+func TestGetRowsRecords(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet2&format=records&filter="+url.QueryEscape("Score>9.5"), nil)
+	res := httptest.NewRecorder()
+
+	GetRows(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, res.Code)
+	}
+
+	var response struct {
+		Rows []map[string]interface{} `json:"rows"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expected := []map[string]interface{}{
+		{"ID": "1", "Name": "test1", "Score": "9.8"},
+		{"ID": "3", "Name": "test3", "Score": "9.9"},
+	}
+	if !reflect.DeepEqual(response.Rows, expected) {
+		t.Errorf("Expected records %v but got %v", expected, response.Rows)
+	}
+
+	// the legacy route returns the records array directly
+	req = httptest.NewRequest(http.MethodGet, "/GetSheetData?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1&format=records", nil)
+	res = httptest.NewRecorder()
+
+	GetSheetData(res, req)
+
+	var records []map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&records); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(records) != 4 || records[0]["Email"] != "test1@gmail.com" {
+		t.Errorf("Expected 4 Sheet1 records but got %v", records)
+	}
+
+	res = httptest.NewRecorder()
+	GetRows(res, httptest.NewRequest(http.MethodGet, "/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1&format=xml", nil))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d but got %d", http.StatusBadRequest, res.Code)
+	}
+}
+
+func TestGetByFilterExpression(t *testing.T) {
+	cases := []struct {
+		name   string
+		filter string
+		status int
+		rows   int
+	}{
+		{"and group", "Score >= 9 AND Name != test3", http.StatusOK, 2},
+		{"or group", "(ID = 1 OR ID = 2) AND Score < 9", http.StatusOK, 2},
+		{"in nocase", "Name in ('TEST1', 'Test3') NOCASE", http.StatusOK, 3},
+		{"between", "Score between 8 and 9", http.StatusOK, 2},
+		{"bad expression", "Score >=", http.StatusBadRequest, 0},
+		{"unknown column", "Phone is empty", http.StatusBadRequest, 0},
+	}
+
+	for _, c := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/GetByFilter?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet2&filter="+url.QueryEscape(c.filter), nil)
+		res := httptest.NewRecorder()
+
+		GetByFilter(res, req)
+
+		if res.Code != c.status {
+			t.Errorf("%s: expected status code %d but got %d", c.name, c.status, res.Code)
+			continue
+		}
+		if c.status != http.StatusOK {
+			if !strings.Contains(res.Body.String(), `"code":"INVALID_FILTER"`) && !strings.Contains(res.Body.String(), `"code":"COLUMN_NOT_FOUND"`) {
+				t.Errorf("%s: expected a filter error code but got %s", c.name, res.Body.String())
+			}
+			continue
+		}
+
+		var rows [][]interface{}
+		if err := json.NewDecoder(res.Body).Decode(&rows); err != nil {
+			t.Fatalf("%s: failed to decode response: %v", c.name, err)
+		}
+		if len(rows) != c.rows {
+			t.Errorf("%s: expected %d rows including the header but got %v", c.name, c.rows, rows)
+		}
+	}
+}
+
+func TestGetRowsPagination(t *testing.T) {
+	base := "/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet2"
+
+	type page struct {
+		Rows          [][]interface{} `json:"rows"`
+		Total         int             `json:"total"`
+		Offset        int             `json:"offset"`
+		NextPageToken string          `json:"nextPageToken"`
+	}
+	get := func(url string) (int, page) {
+		res := httptest.NewRecorder()
+		GetRows(res, httptest.NewRequest(http.MethodGet, url, nil))
+		var p page
+		if res.Code == http.StatusOK {
+			if err := json.NewDecoder(res.Body).Decode(&p); err != nil {
+				t.Fatalf("%s: failed to decode response: %v", url, err)
+			}
+		}
+		return res.Code, p
+	}
+
+	status, first := get(base + "&sort=-Score&fields=Name,Score&limit=2")
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, status)
+	}
+	expected := [][]interface{}{{"Name", "Score"}, {"test3", "9.9"}, {"test1", "9.8"}}
+	if !reflect.DeepEqual(first.Rows, expected) || first.Total != 3 || first.NextPageToken == "" {
+		t.Fatalf("Unexpected first page %+v", first)
+	}
+
+	status, second := get(base + "&sort=Score:desc&fields=ID&limit=2&pageToken=" + first.NextPageToken)
+	if status != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, status)
+	}
+	expected = [][]interface{}{{"ID"}, {"2"}}
+	if !reflect.DeepEqual(second.Rows, expected) || second.Offset != 2 || second.NextPageToken != "" {
+		t.Errorf("Unexpected second page %+v", second)
+	}
+
+	_, offset := get(base + "&sort=Name&limit=1&offset=1&filter=" + url.QueryEscape("Score > 9"))
+	expected = [][]interface{}{{"ID", "Name", "Score"}, {"3", "test3", "9.9"}}
+	if !reflect.DeepEqual(offset.Rows, expected) || offset.Total != 2 || offset.NextPageToken != "" {
+		t.Errorf("Unexpected offset page %+v", offset)
+	}
+
+	errorCases := []string{
+		base + "&sort=Score&pageToken=" + first.NextPageToken,
+		base + "&pageToken=garbage",
+		base + "&limit=-1",
+		base + "&limit=abc",
+		base + "&offset=1&pageToken=" + first.NextPageToken,
+		base + "&sort=Score:up",
+		base + "&sort=Phone",
+		base + "&fields=ID,Phone",
+	}
+	for _, c := range errorCases {
+		if status, _ := get(c); status != http.StatusBadRequest {
+			t.Errorf("%s: expected status code %d but got %d", c, http.StatusBadRequest, status)
+		}
+	}
+}
+
+func TestGetSheetDataPagination(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/GetSheetData?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet2&sort=Score&limit=1", nil)
+	res := httptest.NewRecorder()
+
+	GetSheetData(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, res.Code)
+	}
+	if res.Header().Get("X-Total-Count") != "3" || res.Header().Get("X-Next-Page-Token") == "" {
+		t.Errorf("Expected paging headers but got %v", res.Header())
+	}
+
+	var data [][][]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&data); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expected := [][][]interface{}{{{"ID", "Name", "Score"}, {"2", "test2", "8.5"}}}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected %v but got %v", expected, data)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/GetAll?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&limit=1", nil)
+	res = httptest.NewRecorder()
+
+	GetAll(res, req)
+
+	var all [][][][]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&all); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	for _, sheet := range all {
+		if len(sheet) > 0 && len(sheet[0]) > 2 {
+			t.Errorf("Expected at most the header and one row but got %v", sheet)
+		}
+	}
+}
+
+func TestGetRowsETag(t *testing.T) {
+	url := "/v1/spreadsheets/13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w/sheets/Sheet1/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1"
+	res := httptest.NewRecorder()
+	GetRows(res, httptest.NewRequest(http.MethodGet, url, nil))
+
+	tag := res.Header().Get("ETag")
+	if res.Code != http.StatusOK || tag == "" {
+		t.Fatalf("Expected status %d with an ETag but got %d and %q", http.StatusOK, res.Code, tag)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	req.Header.Set("If-None-Match", tag)
+	res = httptest.NewRecorder()
+	GetRows(res, req)
+	if res.Code != http.StatusNotModified || res.Body.Len() != 0 {
+		t.Errorf("Expected status %d with no body but got %d", http.StatusNotModified, res.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/GetSheetData?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1", nil)
+	res = httptest.NewRecorder()
+	GetSheetData(res, req)
+	if res.Header().Get("ETag") != tag {
+		t.Errorf("Expected GetSheetData to return the same ETag %s but got %q", tag, res.Header().Get("ETag"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, url+"&limit=1", nil)
+	req.Header.Set("If-None-Match", tag)
+	res = httptest.NewRecorder()
+	GetRows(res, req)
+	if res.Code != http.StatusOK || res.Header().Get("ETag") == tag {
+		t.Errorf("Expected a page of other rows to have another ETag, got %d %q", res.Code, res.Header().Get("ETag"))
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/GetSheetData?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet2", nil)
+	req.Header.Set("If-None-Match", tag)
+	res = httptest.NewRecorder()
+	GetSheetData(res, req)
+	if res.Code != http.StatusOK || res.Header().Get("ETag") == tag {
+		t.Errorf("Expected another sheet to have another ETag, got %d %q", res.Code, res.Header().Get("ETag"))
+	}
+}
+
+func TestGetRowsETagCoversPage(t *testing.T) {
+	previous := svc.GetBackend()
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+	defer svc.SetBackend(previous)
+	defer cache.Default().Invalidate(svctest.SpreadsheetID, "Sheet1")
+
+	url := "/v1/spreadsheets/13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w/sheets/Sheet1/rows?spreadsheetID=13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w&sheetName=Sheet1&limit=2"
+	get := func(tag string) *httptest.ResponseRecorder {
+		cache.Default().Invalidate(svctest.SpreadsheetID, "Sheet1")
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if tag != "" {
+			req.Header.Set("If-None-Match", tag)
+		}
+		res := httptest.NewRecorder()
+		GetRows(res, req)
+		return res
+	}
+	edit := func(cell string) {
+		backend.UpdateValues(svctest.SpreadsheetID, "Sheet1!"+cell, &sheets.ValueRange{Values: [][]interface{}{{"changed"}}})
+	}
+
+	tag := get("").Header().Get("ETag")
+	if !strings.HasPrefix(tag, `"1-3.`) {
+		t.Fatalf("Expected the ETag to cover the header and first two rows but got %q", tag)
+	}
+
+	// edits to rows outside the page leave its tag as it was
+	edit("B5")
+	if res := get(tag); res.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d after an edit outside the page but got %d", http.StatusNotModified, res.Code)
+	}
+
+	edit("B3")
+	if res := get(tag); res.Code != http.StatusOK || res.Header().Get("ETag") == tag {
+		t.Errorf("Expected status %d and a new ETag after an edit inside the page but got %d %q", http.StatusOK, res.Code, res.Header().Get("ETag"))
+	}
+}
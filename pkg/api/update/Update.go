@@ -8,7 +8,11 @@ import (
 	"strconv"
 	"strings"
 
+	"personnel-api/pkg/a1"
 	"personnel-api/pkg/api/read"
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/cache"
+	"personnel-api/pkg/history"
 	"personnel-api/pkg/svc"
 
 	"google.golang.org/api/sheets/v4"
@@ -16,94 +20,512 @@ import (
 
 /*
 PUT
-Body: {
-		"spreadsheetID": "YOUR_SPREAD_SHEET_ID",
-		"sheetName": "SHEET_NAME",
-		"rows":[ ["3", "test1", "test1@gmail.com"], ["4", "test2", "test2@gmail.com"]],
-		"range": [row1, row2, ...]
-	  }
+
+	Body: {
+			"spreadsheetID": "YOUR_SPREAD_SHEET_ID",
+			"sheetName": "SHEET_NAME",
+			"rows":[ ["3", "test1", "test1@gmail.com"], ["4", "test2", "test2@gmail.com"]],
+			"range": [row1, row2, ...]
+		  }
+
+or with "records":[ {"Email": "test1@gmail.com"}, {"Name": "test2"} ] in place of "rows";
+columns missing from a record keep their current value.
 */
-// check for valid length of input not included (rows and range has to match length)
 func UpdateDataRow(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
 	var req struct {
-		SpreadsheetID string          `json:"spreadsheetID"`
-		SheetName     string          `json:"sheetName"`
-		Rows          [][]interface{} `json:"rows"`
-		Range         []interface{}   `json:"range"`
+		SpreadsheetID string                   `json:"spreadsheetID"`
+		SheetName     string                   `json:"sheetName"`
+		Rows          [][]interface{}          `json:"rows"`
+		Records       []map[string]interface{} `json:"records"`
+		Range         []interface{}            `json:"range"`
 	}
 
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
 		return
 	}
 
 	spreadsheetID := req.SpreadsheetID
 	if spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
 	sheetName := req.SheetName
 	if sheetName == "" {
-		http.Error(w, "sheetName field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "sheetName field is required")
 		return
 	}
 
 	rows := req.Rows
-	if len(rows) == 0 {
-		http.Error(w, "rows data field is required", http.StatusBadRequest)
+	if len(rows) == 0 && len(req.Records) == 0 {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "rows or records data field is required")
 		return
 	}
 
 	dataRange := req.Range
 	if len(dataRange) == 0 {
-		http.Error(w, "range field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "range field is required")
 		return
 	}
 
-	err = UpdateDataRowHelper(spreadsheetID, sheetName, dataRange, rows)
+	if len(rows) == 0 && len(req.Records) != len(dataRange) {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "records and range must have the same length")
+		return
+	}
+
+	updateRows(w, spreadsheetID, sheetName, dataRange, rows, req.Records)
+}
+
+/*
+PUT
+
+	Body: {
+			"spreadsheetID": "YOUR_SPREAD_SHEET_ID",
+			"sheetName": "SHEET_NAME",
+			"keyColumn": "ID",
+			"keys": ["3", "4"],
+			"records":[ {"Email": "test1@gmail.com"}, {"Name": "test2"} ]
+		  }
+
+or with "rows" in place of "records". Updates the row whose keyColumn holds
+each key. Responds 404 if a key matches no row and 409 if it matches more than
+one.
+*/
+func UpdateByKey(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Cannot update the rows requested", http.StatusBadRequest)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
-	fmt.Fprint(w, "Update successfully!")
+	var req struct {
+		SpreadsheetID string                   `json:"spreadsheetID"`
+		SheetName     string                   `json:"sheetName"`
+		KeyColumn     string                   `json:"keyColumn"`
+		Keys          []interface{}            `json:"keys"`
+		Rows          [][]interface{}          `json:"rows"`
+		Records       []map[string]interface{} `json:"records"`
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
+		return
+	}
+
+	if req.SpreadsheetID == "" || req.SheetName == "" || req.KeyColumn == "" || len(req.Keys) == 0 {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID, sheetName, keyColumn and keys fields are required")
+		return
+	}
+
+	if len(req.Rows) == 0 && len(req.Records) == 0 {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "rows or records data field is required")
+		return
+	}
+
+	if len(req.Rows)+len(req.Records) != len(req.Keys) {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "rows or records and keys must have the same length")
+		return
+	}
+
+	dataRange, err := read.RowsByKeyHelper(req.SpreadsheetID, req.SheetName, req.KeyColumn, req.Keys)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Cannot find the rows requested")
+		return
+	}
+
+	updateRows(w, req.SpreadsheetID, req.SheetName, dataRange, req.Rows, req.Records)
 }
 
-func UpdateDataRowHelper(spreadsheetID string, sheetName string, dataRange []interface{}, rows [][]interface{}) error {
-	service, err := svc.SetupGoogleSheetsService()
+// updateRows writes rows, or merges records when no rows are given, into the
+// rows of dataRange and writes the response.
+func updateRows(w http.ResponseWriter, spreadsheetID string, sheetName string, dataRange []interface{}, rows [][]interface{}, records []map[string]interface{}) {
+	var result *sheets.BatchUpdateValuesResponse
+	var err error
+	if len(rows) == 0 {
+		result, err = UpdateDataRecordsHelper(spreadsheetID, sheetName, dataRange, records)
+	} else {
+		result, err = UpdateDataRowHelper(spreadsheetID, sheetName, dataRange, rows)
+	}
 	if err != nil {
-		return err
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Cannot update the rows requested")
+		return
+	}
+
+	writeUpdateResponse(w, spreadsheetID, sheetName, result)
+}
+
+// writeUpdateResponse reports the ranges and number of cells written by a
+// batch update.
+func writeUpdateResponse(w http.ResponseWriter, spreadsheetID string, sheetName string, result *sheets.BatchUpdateValuesResponse) {
+	response := struct {
+		SpreadsheetID string   `json:"spreadsheetID"`
+		SheetName     string   `json:"sheetName"`
+		Message       string   `json:"message"`
+		UpdatedRanges []string `json:"updatedRanges"`
+		UpdatedRows   int64    `json:"updatedRows"`
+		UpdatedCells  int64    `json:"updatedCells"`
+	}{
+		SpreadsheetID: spreadsheetID,
+		SheetName:     sheetName,
+		Message:       "Update successfully!",
+		UpdatedRanges: []string{},
+		UpdatedRows:   result.TotalUpdatedRows,
+		UpdatedCells:  result.TotalUpdatedCells,
+	}
+	for _, updated := range result.Responses {
+		response.UpdatedRanges = append(response.UpdatedRanges, updated.UpdatedRange)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// rowNumber validates a 1-based sheet row number from a request range.
+func rowNumber(v interface{}) (string, error) {
+	rowNum := fmt.Sprint(v)
+	if n, err := strconv.Atoi(rowNum); err != nil || n < 1 {
+		return "", apierror.New(http.StatusBadRequest, apierror.CodeInvalidRange, "invalid row number: %v", v)
+	}
+	return rowNum, nil
+}
+
+// UpdateDataRowHelper writes every row in a single batch update, so either all
+// rows change or none do.
+func UpdateDataRowHelper(spreadsheetID string, sheetName string, dataRange []interface{}, rows [][]interface{}) (*sheets.BatchUpdateValuesResponse, error) {
+	defer cache.Default().Invalidate(spreadsheetID, sheetName)
+
+	if len(rows) != len(dataRange) {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "rows and range must have the same length")
 	}
 
 	columnRange, _, err := read.GetSheetDataHelper(spreadsheetID, sheetName)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	arr := strings.Split(columnRange, ":")
 
+	req := &sheets.BatchUpdateValuesRequest{ValueInputOption: "USER_ENTERED"}
+	rowNumbers := make([]int, len(rows))
 	for i, row := range rows {
-		valueRange := &sheets.ValueRange{
+		rowNum, err := rowNumber(dataRange[i])
+		if err != nil {
+			return nil, err
+		}
+		rowNumbers[i], _ = strconv.Atoi(rowNum)
+		req.Data = append(req.Data, &sheets.ValueRange{
+			Range:  sheetName + "!" + arr[0] + rowNum + ":" + arr[1] + rowNum,
 			Values: [][]interface{}{row},
+		})
+	}
+
+	if err := read.ValidateRows(spreadsheetID, sheetName, nil, rowNumbers, rows); err != nil {
+		return nil, err
+	}
+
+	return batchUpdateValues(spreadsheetID, sheetName, req)
+}
+
+// UpdateDataRecordsHelper merges each record into the current values of its
+// row, so only the columns named in the record change. The current values are
+// read in one call and all rows are written in one batch update.
+func UpdateDataRecordsHelper(spreadsheetID string, sheetName string, dataRange []interface{}, records []map[string]interface{}) (*sheets.BatchUpdateValuesResponse, error) {
+	defer cache.Default().Invalidate(spreadsheetID, sheetName)
+
+	columnRange, sheetData, err := read.GetSheetDataHelper(spreadsheetID, sheetName)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := read.Header(sheetData)
+	if err != nil {
+		return nil, err
+	}
+	arr := strings.Split(columnRange, ":")
+
+	current, err := svc.GetBackend().GetValues(spreadsheetID, sheetName+"!"+arr[0]+":"+arr[1])
+	if err != nil {
+		return nil, err
+	}
+
+	req := &sheets.BatchUpdateValuesRequest{ValueInputOption: "USER_ENTERED"}
+	rowNumbers := make([]int, len(records))
+	rows := make([][]interface{}, len(records))
+	for i, record := range records {
+		rowNum, err := rowNumber(dataRange[i])
+		if err != nil {
+			return nil, err
 		}
 
-		rowNum := dataRange[i].(string)
-		rowRange := sheetName + "!" + arr[0] + rowNum + ":" + arr[1] + rowNum
+		var base []interface{}
+		n, _ := strconv.Atoi(rowNum)
+		if n <= len(current.Values) {
+			base = current.Values[n-1]
+		}
 
-		_, err := service.Spreadsheets.Values.Update(spreadsheetID, rowRange, valueRange).ValueInputOption("USER_ENTERED").Do()
+		row, err := read.FromRecord(header, record, base)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		rowNumbers[i], rows[i] = n, row
+
+		req.Data = append(req.Data, &sheets.ValueRange{
+			Range:  sheetName + "!" + arr[0] + rowNum + ":" + arr[1] + rowNum,
+			Values: [][]interface{}{row},
+		})
 	}
 
-	return nil
+	if err := read.ValidateRows(spreadsheetID, sheetName, nil, rowNumbers, rows); err != nil {
+		return nil, err
+	}
+
+	return batchUpdateValues(spreadsheetID, sheetName, req)
+}
+
+/*
+PUT
+
+	Body: {
+			"spreadsheetID": "YOUR_SPREAD_SHEET_ID",
+			"sheetName": "SHEET_NAME",
+			"keyColumn": "ID",
+			"records":[ {"ID": "3", "Email": "test3@gmail.com"}, {"ID": "9", "Name": "test9"} ]
+		  }
+
+or with "rows" in place of "records". Rows whose key is already in the sheet
+are updated and the others appended.
+*/
+func Upsert(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
+		return
+	}
+
+	var req struct {
+		SpreadsheetID string                   `json:"spreadsheetID"`
+		SheetName     string                   `json:"sheetName"`
+		KeyColumn     string                   `json:"keyColumn"`
+		Rows          [][]interface{}          `json:"rows"`
+		Records       []map[string]interface{} `json:"records"`
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
+		return
+	}
+
+	if req.SpreadsheetID == "" || req.SheetName == "" || req.KeyColumn == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID, sheetName and keyColumn fields are required")
+		return
+	}
+
+	if len(req.Rows) == 0 && len(req.Records) == 0 {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "rows or records data field is required")
+		return
+	}
+
+	result, err := UpsertHelper(req.SpreadsheetID, req.SheetName, req.KeyColumn, req.Rows, req.Records)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Cannot upsert the rows requested")
+		return
+	}
+
+	response := struct {
+		SpreadsheetID string `json:"spreadsheetID"`
+		SheetName     string `json:"sheetName"`
+		Message       string `json:"message"`
+		*UpsertResult
+	}{
+		SpreadsheetID: req.SpreadsheetID,
+		SheetName:     req.SheetName,
+		Message:       "Upsert successfully!",
+		UpsertResult:  result,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// UpsertResult counts the rows of an upsert by outcome.
+type UpsertResult struct {
+	Inserted      int      `json:"inserted"`
+	Updated       int      `json:"updated"`
+	Unchanged     int      `json:"unchanged"`
+	UpdatedRanges []string `json:"updatedRanges"`
+}
+
+// UpsertHelper matches each row, or record when no rows are given, to the
+// existing row with the same value in keyColumn. Changed rows are rewritten
+// and new keys are written below the last row, all in one batch update.
+// Records only change the columns they name. A key repeated in the request is
+// rejected, and a key found in several rows of the sheet is a 409.
+func UpsertHelper(spreadsheetID string, sheetName string, keyColumn string, rows [][]interface{}, records []map[string]interface{}) (*UpsertResult, error) {
+	defer cache.Default().Invalidate(spreadsheetID, sheetName)
+
+	table, err := read.GetTableHelper(spreadsheetID, sheetName)
+	if err != nil {
+		return nil, err
+	}
+	if table == nil {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "sheet has no header row")
+	}
+	header := table.Rows[0]
+
+	keyIdx := -1
+	for i, name := range header {
+		if fmt.Sprint(name) == keyColumn {
+			keyIdx = i
+			break
+		}
+	}
+	if keyIdx == -1 {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeColumnNotFound, "no column found with that name: %v", keyColumn)
+	}
+
+	count := len(rows)
+	if count == 0 {
+		count = len(records)
+	}
+
+	columns := strings.Split(table.ColumnRange(), ":")
+	rowRange := func(rowNum int) string {
+		return fmt.Sprintf("%s!%s%d:%s%d", sheetName, columns[0], rowNum, columns[1], rowNum)
+	}
+
+	result := &UpsertResult{UpdatedRanges: []string{}}
+	req := &sheets.BatchUpdateValuesRequest{ValueInputOption: "USER_ENTERED"}
+	seen := map[string]bool{}
+	next := table.LastRow() + 1
+	var rowNumbers []int
+	var checked [][]interface{}
+
+	for i := 0; i < count; i++ {
+		var key interface{}
+		if len(rows) > 0 {
+			if keyIdx < len(rows[i]) {
+				key = rows[i][keyIdx]
+			}
+		} else {
+			key = records[i][keyColumn]
+		}
+		if key == nil || fmt.Sprint(key) == "" {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "row %d has no value for %v", i+1, keyColumn)
+		}
+		if seen[fmt.Sprint(key)] {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "%v = %v appears more than once in the request", keyColumn, key)
+		}
+		seen[fmt.Sprint(key)] = true
+
+		matches, err := table.MatchRows(keyColumn, key)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) > 1 {
+			return nil, apierror.New(http.StatusConflict, apierror.CodeConflict, "%d rows found with %v = %v", len(matches), keyColumn, key)
+		}
+
+		var current []interface{}
+		if len(matches) == 1 {
+			current = table.Rows[matches[0]]
+		}
+
+		var row []interface{}
+		if len(rows) > 0 {
+			row = rows[i]
+		} else {
+			row, err = read.FromRecord(header, records[i], current)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		checked = append(checked, row)
+		switch {
+		case current == nil:
+			req.Data = append(req.Data, &sheets.ValueRange{Range: rowRange(next), Values: [][]interface{}{row}})
+			rowNumbers = append(rowNumbers, 0)
+			result.Inserted++
+			next++
+		case sameRow(current, row):
+			rowNumbers = append(rowNumbers, table.RowNumber(matches[0]))
+			result.Unchanged++
+		default:
+			req.Data = append(req.Data, &sheets.ValueRange{Range: rowRange(table.RowNumber(matches[0])), Values: [][]interface{}{row}})
+			rowNumbers = append(rowNumbers, table.RowNumber(matches[0]))
+			result.Updated++
+		}
+	}
+
+	if err := read.ValidateRows(spreadsheetID, sheetName, table, rowNumbers, checked); err != nil {
+		return nil, err
+	}
+
+	if len(req.Data) == 0 {
+		return result, nil
+	}
+
+	response, err := batchUpdateValues(spreadsheetID, sheetName, req)
+	if err != nil {
+		return nil, err
+	}
+	for _, updated := range response.Responses {
+		result.UpdatedRanges = append(result.UpdatedRanges, updated.UpdatedRange)
+	}
+
+	return result, nil
+}
+
+// batchUpdateValues writes req in one batch update and notes the values it
+// changes on the sheet's history.
+func batchUpdateValues(spreadsheetID string, sheetName string, req *sheets.BatchUpdateValuesRequest) (*sheets.BatchUpdateValuesResponse, error) {
+	ranges := make([]string, len(req.Data))
+	for i, data := range req.Data {
+		ranges[i] = data.Range
+	}
+	edit := history.NewEdit(spreadsheetID, sheetName, ranges...)
+
+	response, err := svc.GetBackend().BatchUpdateValues(spreadsheetID, req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, data := range req.Data {
+		edit.Write(data.Range, data.Values)
+	}
+	edit.Done()
+	return response, nil
+}
+
+// sameRow compares rows by their text, treating missing trailing cells as
+// empty, as the sheet returns numbers as text and drops trailing blanks.
+func sameRow(current []interface{}, row []interface{}) bool {
+	n := len(current)
+	if len(row) > n {
+		n = len(row)
+	}
+	for i := 0; i < n; i++ {
+		var a, b interface{} = "", ""
+		if i < len(current) {
+			a = current[i]
+		}
+		if i < len(row) {
+			b = row[i]
+		}
+		if fmt.Sprint(a) != fmt.Sprint(b) {
+			return false
+		}
+	}
+	return true
 }
 
 /*
@@ -115,12 +537,13 @@ PUT
 			"cells":["test5", "test5@gmail.com"],
 			"range": [["4", "1"], ["5", "2"]]
 		  }
+
+	Cells are checked against the sheet's schema, if one is registered.
 */
-//No type check yet
 func UpdateDataCell(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
@@ -133,70 +556,80 @@ func UpdateDataCell(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
 		return
 	}
 
 	spreadsheetID := req.SpreadsheetID
 	if spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
 	sheetName := req.SheetName
 	if sheetName == "" {
-		http.Error(w, "sheetName field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "sheetName field is required")
 		return
 	}
 
 	cells := req.Cells
 	if len(cells) == 0 {
-		http.Error(w, "cells data field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "cells data field is required")
 		return
 	}
 
 	dataRange := req.Range
 	if len(dataRange) == 0 {
-		http.Error(w, "range field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "range field is required")
 		return
 	}
 
-	err = UpdateDataCellHelper(spreadsheetID, sheetName, cells, dataRange)
+	result, err := UpdateDataCellHelper(spreadsheetID, sheetName, cells, dataRange)
 	if err != nil {
-		http.Error(w, "Cannot update the cells requested", http.StatusBadRequest)
+		apierror.WriteFrom(w, err, http.StatusBadRequest, apierror.CodeInvalidRequest, "Cannot update the cells requested")
 		return
 	}
 
-	fmt.Fprint(w, "Update successfully!")
+	writeUpdateResponse(w, spreadsheetID, sheetName, result)
 }
 
-func UpdateDataCellHelper(spreadsheetID string, sheetName string, cells []interface{}, dataRange [][]interface{}) error {
-	service, err := svc.SetupGoogleSheetsService()
-	if err != nil {
-		return err
+// UpdateDataCellHelper writes every cell in a single batch update.
+func UpdateDataCellHelper(spreadsheetID string, sheetName string, cells []interface{}, dataRange [][]interface{}) (*sheets.BatchUpdateValuesResponse, error) {
+	defer cache.Default().Invalidate(spreadsheetID, sheetName)
+
+	if len(cells) != len(dataRange) {
+		return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRequest, "cells and range must have the same length")
 	}
 
+	req := &sheets.BatchUpdateValuesRequest{ValueInputOption: "USER_ENTERED"}
+	var checks []read.Cell
 	for i, pos := range dataRange {
-		row := pos[0].(string)
-		col_int, err := strconv.Atoi(pos[1].(string))
+		if len(pos) != 2 {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRange, "each range entry must be [row, column]")
+		}
+		row, err := rowNumber(pos[0])
 		if err != nil {
-			return err
+			return nil, err
 		}
-		col := read.ColumnIndexToLetter(col_int)
-		rowRange := sheetName + "!" + col + row + ":" + col + row
-
-		cell_data := cells[i]
-		valueRange := &sheets.ValueRange{
-			Values: [][]interface{}{{cell_data}},
+		col_int, err := strconv.Atoi(fmt.Sprint(pos[1]))
+		if err != nil || col_int < 0 {
+			return nil, apierror.New(http.StatusBadRequest, apierror.CodeInvalidRange, "invalid column index: %v", pos[1])
 		}
+		col := a1.ColumnLetter(col_int)
+		row_int, _ := strconv.Atoi(row)
+		checks = append(checks, read.Cell{Row: row_int, Column: col_int, Value: cells[i]})
 
-		_, err = service.Spreadsheets.Values.Update(spreadsheetID, rowRange, valueRange).ValueInputOption("USER_ENTERED").Do()
-		if err != nil {
-			return err
-		}
+		req.Data = append(req.Data, &sheets.ValueRange{
+			Range:  sheetName + "!" + col + row + ":" + col + row,
+			Values: [][]interface{}{{cells[i]}},
+		})
 	}
 
-	return nil
+	if err := read.ValidateCells(spreadsheetID, sheetName, checks); err != nil {
+		return nil, err
+	}
+
+	return batchUpdateValues(spreadsheetID, sheetName, req)
 }
 
 /*
@@ -206,7 +639,7 @@ Body: {"spreadsheetID": "your-spreadsheet-id", "title": "New Title"}
 func UpdateSpreadsheet(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
@@ -217,18 +650,18 @@ func UpdateSpreadsheet(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
 		return
 	}
 
 	if req.SpreadsheetID == "" || req.Title == "" {
-		http.Error(w, "Missing spreadsheetID or title", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Missing spreadsheetID or title")
 		return
 	}
 
 	err = UpdateSpreadsheetHelper(req.SpreadsheetID, req.Title)
 	if err != nil {
-		http.Error(w, "Failed to update spreadsheet: "+err.Error(), http.StatusInternalServerError)
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Failed to update spreadsheet")
 		return
 	}
 
@@ -247,11 +680,6 @@ func UpdateSpreadsheet(w http.ResponseWriter, r *http.Request) {
 }
 
 func UpdateSpreadsheetHelper(spreadsheetID, title string) error {
-	service, err := svc.SetupGoogleSheetsService()
-	if err != nil {
-		return err
-	}
-
 	requests := []*sheets.Request{
 		{
 			UpdateSpreadsheetProperties: &sheets.UpdateSpreadsheetPropertiesRequest{
@@ -267,7 +695,7 @@ func UpdateSpreadsheetHelper(spreadsheetID, title string) error {
 		Requests: requests,
 	}
 
-	_, err = service.Spreadsheets.BatchUpdate(spreadsheetID, batchUpdateRequest).Do()
+	_, err := svc.GetBackend().BatchUpdate(spreadsheetID, batchUpdateRequest)
 	return err
 }
 
@@ -277,13 +705,13 @@ Body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetID": 123456, "newSheetName
 */
 func UpdateSheet(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPut {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
 		return
 	}
 
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, "Failed to read request body", http.StatusInternalServerError)
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
 		return
 	}
 
@@ -295,29 +723,29 @@ func UpdateSheet(w http.ResponseWriter, r *http.Request) {
 
 	err = json.Unmarshal(body, &req)
 	if err != nil {
-		http.Error(w, "Failed to parse request body", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
 		return
 	}
 
 	spreadsheetID := req.SpreadsheetID
 	if spreadsheetID == "" {
-		http.Error(w, "spreadsheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
 		return
 	}
 
 	if req.SheetID == 0 {
-		http.Error(w, "sheetID field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "sheetID field is required")
 		return
 	}
 
 	if req.NewSheetName == "" {
-		http.Error(w, "newSheetName field is required", http.StatusBadRequest)
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "newSheetName field is required")
 		return
 	}
 
 	err = UpdateSheetHelper(spreadsheetID, req.SheetID, req.NewSheetName)
 	if err != nil {
-		http.Error(w, "Cannot update sheet: "+err.Error(), http.StatusInternalServerError)
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Cannot update sheet")
 		return
 	}
 
@@ -338,10 +766,7 @@ func UpdateSheet(w http.ResponseWriter, r *http.Request) {
 }
 
 func UpdateSheetHelper(spreadsheetID string, sheetID int64, newSheetName string) error {
-	service, err := svc.SetupGoogleSheetsService()
-	if err != nil {
-		return err
-	}
+	defer cache.Default().InvalidateSpreadsheet(spreadsheetID)
 
 	req := &sheets.BatchUpdateSpreadsheetRequest{
 		Requests: []*sheets.Request{
@@ -357,7 +782,7 @@ func UpdateSheetHelper(spreadsheetID string, sheetID int64, newSheetName string)
 		},
 	}
 
-	_, err = service.Spreadsheets.BatchUpdate(spreadsheetID, req).Do()
+	_, err := svc.GetBackend().BatchUpdate(spreadsheetID, req)
 	if err != nil {
 		return err
 	}
@@ -4,14 +4,31 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"testing"
+	"time"
+
+	"personnel-api/pkg/api/read"
+	"personnel-api/pkg/cache"
+	"personnel-api/pkg/history"
+	"personnel-api/pkg/schema"
+	"personnel-api/pkg/svc"
+	"personnel-api/pkg/svc/svctest"
+
+	"google.golang.org/api/sheets/v4"
 )
 
 type errorReader struct{}
 
+type updateResponse struct {
+	Message       string   `json:"message"`
+	UpdatedRanges []string `json:"updatedRanges"`
+	UpdatedRows   int64    `json:"updatedRows"`
+	UpdatedCells  int64    `json:"updatedCells"`
+}
+
 func (r *errorReader) Read(p []byte) (n int, err error) {
 	return 0, fmt.Errorf("simulated error while reading request body")
 }
@@ -47,10 +64,13 @@ func TestUpdateDataRow(t *testing.T) {
 		t.Errorf("Expected status code %d but got %d", http.StatusOK, res.Code)
 	}
 
-	expectedResponse := "Update successfully!"
-	actualResponse, _ := io.ReadAll(res.Body)
-	if string(actualResponse) != expectedResponse {
-		t.Errorf("Expected response body %q but got %q", expectedResponse, string(actualResponse))
+	var response updateResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expectedRanges := []string{"Sheet1!A4:C4", "Sheet1!A5:C5"}
+	if response.Message != "Update successfully!" || !reflect.DeepEqual(response.UpdatedRanges, expectedRanges) || response.UpdatedRows != 2 || response.UpdatedCells != 6 {
+		t.Errorf("Unexpected response %+v", response)
 	}
 
 	// test for error handling
@@ -141,8 +161,8 @@ func TestUpdateDataRow(t *testing.T) {
 		]}`)))
 	UpdateDataRow(res_err, req_err)
 
-	if res_err.Code != http.StatusBadRequest {
-		t.Errorf("Expected status code %d but got %d", http.StatusBadRequest, res_err.Code)
+	if res_err.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res_err.Code)
 	}
 }
 
@@ -175,10 +195,12 @@ func TestUpdateDataCell(t *testing.T) {
 		t.Errorf("Expected status code %d but got %d", http.StatusOK, res.Code)
 	}
 
-	expectedResponse := "Update successfully!"
-	actualResponse, _ := io.ReadAll(res.Body)
-	if string(actualResponse) != expectedResponse {
-		t.Errorf("Expected response body %q but got %q", expectedResponse, string(actualResponse))
+	var response updateResponse
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if response.Message != "Update successfully!" || len(response.UpdatedRanges) != 2 || response.UpdatedCells != 2 {
+		t.Errorf("Unexpected response %+v", response)
 	}
 
 	// test for error handling
@@ -407,3 +429,294 @@ func TestUpdateSheet(t *testing.T) {
 		t.Errorf("Expected status code %d but got %d", http.StatusMethodNotAllowed, res_err.Code)
 	}
 }
+
+func TestUpdateDataRowRecords(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+
+	requestBody := []byte(`{
+		"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w",
+		"sheetName": "Sheet1",
+		"records": [{"Email": "new2@gmail.com"}, {"Name": "new3"}],
+		"range": ["3", 4]
+	}`)
+	res := httptest.NewRecorder()
+	UpdateDataRow(res, httptest.NewRequest(http.MethodPut, "/UpdateDataRow", bytes.NewReader(requestBody)))
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1!A3:C4")
+	expected := [][]interface{}{{"2", "test2", "new2@gmail.com"}, {"3", "new3", "test3@gmail.com"}}
+	if !reflect.DeepEqual(values.Values, expected) {
+		t.Errorf("Expected %v but got %v", expected, values.Values)
+	}
+
+	// test error handling
+	for _, body := range []string{
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "records": [{"Phone": "1"}], "range": ["3"]}`,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "records": [{"Name": "a"}], "range": ["3", "4"]}`,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "records": [{"Name": "a"}], "range": ["x"]}`,
+	} {
+		res = httptest.NewRecorder()
+		UpdateDataRow(res, httptest.NewRequest(http.MethodPut, "/UpdateDataRow", bytes.NewReader([]byte(body))))
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Body %s: expected status code %d but got %d", body, http.StatusBadRequest, res.Code)
+		}
+	}
+}
+
+func TestUpdateRecordsHistory(t *testing.T) {
+	svc.SetBackend(svctest.NewBackend())
+	previous := history.Default()
+	store, _ := history.NewStore("")
+	history.SetDefault(store)
+	defer history.SetDefault(previous)
+
+	recording := store.Begin(svctest.SpreadsheetID, "Sheet1")
+	records := []map[string]interface{}{{"Email": "new2@gmail.com"}, {"Name": "new3"}}
+	if _, err := UpdateDataRecordsHelper(svctest.SpreadsheetID, "Sheet1", []interface{}{"3", "4"}, records); err != nil {
+		t.Fatalf("UpdateDataRecordsHelper returned error: %v", err)
+	}
+	change, err := recording.Commit("UpdateDataRow", "alice")
+	recording.End()
+	if err != nil || change == nil {
+		t.Fatalf("Expected a change but got %v, %v", change, err)
+	}
+
+	if change.Range != "'Sheet1'!B3:C4" {
+		t.Errorf("Expected range 'Sheet1'!B3:C4 but got %s", change.Range)
+	}
+	if !reflect.DeepEqual(change.Before, [][]interface{}{{"test2", "test2@gmail.com"}, {"test3", "test3@gmail.com"}}) {
+		t.Errorf("Unexpected before values %v", change.Before)
+	}
+	if !reflect.DeepEqual(change.After, [][]interface{}{{"test2", "new2@gmail.com"}, {"new3", "test3@gmail.com"}}) {
+		t.Errorf("Unexpected after values %v", change.After)
+	}
+}
+
+func TestUpdateInvalidatesCache(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+	cache.SetDefault(cache.New(time.Minute, 10, 0))
+	defer cache.SetDefault(cache.New(0, 0, 0))
+
+	cell := func() interface{} {
+		_, sheetData, err := read.GetSheetDataHelper(svctest.SpreadsheetID, "Sheet1")
+		if err != nil {
+			t.Fatalf("GetSheetDataHelper returned error: %v", err)
+		}
+		return sheetData[0].([][]interface{})[1][1]
+	}
+
+	if got := cell(); got != "test1" {
+		t.Fatalf("Expected test1 but got %v", got)
+	}
+
+	// writes that bypass the helpers are not seen until the entry is invalidated
+	backend.UpdateValues(svctest.SpreadsheetID, "Sheet1!B2", &sheets.ValueRange{Values: [][]interface{}{{"direct"}}})
+	if got := cell(); got != "test1" {
+		t.Errorf("Expected the cached test1 but got %v", got)
+	}
+
+	_, err := UpdateDataCellHelper(svctest.SpreadsheetID, "Sheet1", []interface{}{"helper"}, [][]interface{}{{"2", "1"}})
+	if err != nil {
+		t.Fatalf("UpdateDataCellHelper returned error: %v", err)
+	}
+	if got := cell(); got != "helper" {
+		t.Errorf("Expected helper after the update but got %v", got)
+	}
+
+	if stats := cache.Default().Stats(); stats.Hits != 1 || stats.Misses != 2 || stats.Invalidations != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestUpdateDataRowAllOrNothing(t *testing.T) {
+	svc.SetBackend(svctest.NewBackend())
+
+	requestBody := []byte(`{
+		"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w",
+		"sheetName": "Sheet1",
+		"rows": [["2", "changed", "changed@gmail.com"], ["3", "changed", "changed@gmail.com"]],
+		"range": ["3", "x"]
+	}`)
+	res := httptest.NewRecorder()
+
+	UpdateDataRow(res, httptest.NewRequest(http.MethodPut, "/UpdateDataRow", bytes.NewReader(requestBody)))
+
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d but got %d", http.StatusBadRequest, res.Code)
+	}
+
+	values, _ := svc.GetBackend().GetValues(svctest.SpreadsheetID, "Sheet1!A3:C3")
+	if values.Values[0][1] == "changed" {
+		t.Errorf("Expected no row to change but got %v", values.Values)
+	}
+}
+
+func TestUpdateByKey(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+
+	requestBody := []byte(`{
+		"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w",
+		"sheetName": "Sheet1",
+		"keyColumn": "ID",
+		"keys": ["4", 2],
+		"records": [{"Email": "new4@gmail.com"}, {"Name": "test1"}]
+	}`)
+	res := httptest.NewRecorder()
+	UpdateByKey(res, httptest.NewRequest(http.MethodPut, "/UpdateByKey", bytes.NewReader(requestBody)))
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1!A3:C5")
+	expected := [][]interface{}{{"2", "test1", "test2@gmail.com"}, {"3", "test3", "test3@gmail.com"}, {"4", "test4", "new4@gmail.com"}}
+	if !reflect.DeepEqual(values.Values, expected) {
+		t.Errorf("Expected %v but got %v", expected, values.Values)
+	}
+
+	// test error handling
+	for body, status := range map[string]int{
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "ID", "keys": ["9"], "rows": [["9"]]}`:               http.StatusNotFound,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "Name", "keys": ["test1"], "rows": [["1"]]}`:         http.StatusConflict,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "Phone", "keys": ["1"], "rows": [["1"]]}`:            http.StatusBadRequest,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "ID", "keys": ["1", "3"], "records": [{"ID": "1"}]}`: http.StatusBadRequest,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "ID", "rows": [["1"]]}`:                              http.StatusBadRequest,
+	} {
+		res = httptest.NewRecorder()
+		UpdateByKey(res, httptest.NewRequest(http.MethodPut, "/UpdateByKey", bytes.NewReader([]byte(body))))
+		if res.Code != status {
+			t.Errorf("Body %s: expected status code %d but got %d", body, status, res.Code)
+		}
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+
+	requestBody := []byte(`{
+		"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w",
+		"sheetName": "Sheet1",
+		"keyColumn": "ID",
+		"records": [
+			{"ID": "2", "Email": "new2@gmail.com"},
+			{"ID": 3, "Name": "test3"},
+			{"ID": "5", "Name": "test5"},
+			{"ID": "6"}
+		]
+	}`)
+	res := httptest.NewRecorder()
+	Upsert(res, httptest.NewRequest(http.MethodPut, "/Upsert", bytes.NewReader(requestBody)))
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	var response struct {
+		Inserted      int      `json:"inserted"`
+		Updated       int      `json:"updated"`
+		Unchanged     int      `json:"unchanged"`
+		UpdatedRanges []string `json:"updatedRanges"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	expectedRanges := []string{"Sheet1!A3:C3", "Sheet1!A6:C6", "Sheet1!A7:C7"}
+	if response.Inserted != 2 || response.Updated != 1 || response.Unchanged != 1 || !reflect.DeepEqual(response.UpdatedRanges, expectedRanges) {
+		t.Errorf("Unexpected response %+v", response)
+	}
+
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1!A3:C7")
+	expected := [][]interface{}{
+		{"2", "test2", "new2@gmail.com"},
+		{"3", "test3", "test3@gmail.com"},
+		{"4", "test4", "test4@gmail.com"},
+		{"5", "test5"},
+		{"6"},
+	}
+	if !reflect.DeepEqual(values.Values, expected) {
+		t.Errorf("Expected %v but got %v", expected, values.Values)
+	}
+
+	// test error handling
+	backend.UpdateValues(svctest.SpreadsheetID, "Sheet1!B3", &sheets.ValueRange{Values: [][]interface{}{{"test1"}}})
+	for body, status := range map[string]int{
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "Name", "rows": [["9", "test1"]]}`:         http.StatusConflict,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "ID", "rows": [["9"], ["9"]]}`:             http.StatusBadRequest,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "ID", "records": [{"Name": "test9"}]}`:     http.StatusBadRequest,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "Phone", "rows": [["9"]]}`:                 http.StatusBadRequest,
+		`{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "ID", "records": [{"ID": "9", "Age": 1}]}`: http.StatusBadRequest,
+	} {
+		res = httptest.NewRecorder()
+		Upsert(res, httptest.NewRequest(http.MethodPut, "/Upsert", bytes.NewReader([]byte(body))))
+		if res.Code != status {
+			t.Errorf("Body %s: expected status code %d but got %d", body, status, res.Code)
+		}
+	}
+}
+
+func TestUpdateValidatesSchema(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+
+	previous := schema.Default()
+	store, _ := schema.NewStore("")
+	schema.SetDefault(store)
+	defer schema.SetDefault(previous)
+
+	err := store.Set(&schema.Schema{
+		SpreadsheetID: svctest.SpreadsheetID,
+		SheetName:     "Sheet1",
+		Columns: []schema.Column{
+			{Name: "ID", Type: schema.TypeInt, Required: true, Unique: true},
+			{Name: "Email", Type: schema.TypeEmail},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, body := range map[string]string{
+		"/UpdateDataCell": `{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "cells": ["bad", "1"], "range": [["2", "2"], ["3", "0"]]}`,
+		"/UpdateDataRow":  `{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "rows": [["2", "test2", "bad"]], "range": ["3"]}`,
+		"/Upsert":         `{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "keyColumn": "ID", "records": [{"ID": "x"}]}`,
+	} {
+		res := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, path, bytes.NewReader([]byte(body)))
+		switch path {
+		case "/UpdateDataCell":
+			UpdateDataCell(res, req)
+		case "/UpdateDataRow":
+			UpdateDataRow(res, req)
+		default:
+			Upsert(res, req)
+		}
+
+		if res.Code != http.StatusUnprocessableEntity {
+			t.Errorf("%s: expected status code %d but got %d: %s", path, http.StatusUnprocessableEntity, res.Code, res.Body.String())
+		}
+	}
+
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1")
+	if len(values.Values) != 5 || values.Values[1][2] != "test1@gmail.com" || values.Values[2][0] != "2" {
+		t.Errorf("Expected no change but got %v", values.Values)
+	}
+
+	// a row may keep its own unique value
+	res := httptest.NewRecorder()
+	UpdateDataRow(res, httptest.NewRequest(http.MethodPut, "/UpdateDataRow", bytes.NewReader([]byte(`{
+		"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w",
+		"sheetName": "Sheet1",
+		"rows": [["2", "renamed", "new2@gmail.com"]],
+		"range": ["3"]
+	}`))))
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+}
@@ -0,0 +1,167 @@
+// Package apierror writes the JSON error body shared by every endpoint:
+//
+//	{"error": {"code": "SHEET_NOT_FOUND", "message": "...", "details": {...}}}
+//
+// Errors coming back from the Google APIs keep their meaning, so clients can
+// tell a missing spreadsheet from a permission problem or an exhausted quota.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	CodeInvalidRequest      = "INVALID_REQUEST"
+	CodeInvalidRange        = "INVALID_RANGE"
+	CodeInvalidFilter       = "INVALID_FILTER"
+	CodeMethodNotAllowed    = "METHOD_NOT_ALLOWED"
+	CodeUnauthenticated     = "UNAUTHENTICATED"
+	CodePermissionDenied    = "PERMISSION_DENIED"
+	CodeNotFound            = "NOT_FOUND"
+	CodeSpreadsheetNotFound = "SPREADSHEET_NOT_FOUND"
+	CodeSheetNotFound       = "SHEET_NOT_FOUND"
+	CodeColumnNotFound      = "COLUMN_NOT_FOUND"
+	CodeConflict            = "CONFLICT"
+	CodeValidationFailed    = "VALIDATION_FAILED"
+	CodePreconditionFailed  = "PRECONDITION_FAILED"
+	CodeNotAcceptable       = "NOT_ACCEPTABLE"
+	CodeQuotaExceeded       = "QUOTA_EXCEEDED"
+	CodeBackendAuth         = "BACKEND_UNAUTHENTICATED"
+	CodeBackendUnavailable  = "BACKEND_UNAVAILABLE"
+	CodeBackendError        = "BACKEND_ERROR"
+	CodeInternal            = "INTERNAL"
+)
+
+// Error is an error with the HTTP status and code it should be reported with.
+// Helpers return it when they know better than the handler what went wrong.
+type Error struct {
+	Status  int         `json:"-"`
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func New(status int, code string, format string, args ...interface{}) *Error {
+	return &Error{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// From classifies err. An *Error anywhere in the chain is used as is and a
+// *googleapi.Error is mapped to a matching status and code; anything else is
+// reported with the given status and code. message prefixes the description.
+func From(err error, status int, code string, message string) *Error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		e := *apiErr
+		if message != "" {
+			e.Message = message + ": " + e.Message
+		}
+		return &e
+	}
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		e := fromGoogle(googleErr)
+		if message != "" {
+			e.Message = message + ": " + e.Message
+		}
+		return e
+	}
+
+	if err != nil && message != "" {
+		message = message + ": " + err.Error()
+	} else if err != nil {
+		message = err.Error()
+	}
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func fromGoogle(err *googleapi.Error) *Error {
+	reason := ""
+	if len(err.Errors) > 0 {
+		reason = err.Errors[0].Reason
+	}
+	message := err.Message
+	if message == "" {
+		message = http.StatusText(err.Code)
+	}
+
+	e := &Error{
+		Message: message,
+		Details: map[string]interface{}{
+			"backendStatus": err.Code,
+		},
+	}
+	if reason != "" {
+		e.Details.(map[string]interface{})["reason"] = reason
+	}
+
+	switch {
+	case err.Code == http.StatusBadRequest && strings.HasPrefix(message, "Unable to parse range: "):
+		// A bare sheet name only fails to parse when the sheet does not exist.
+		if strings.Contains(strings.TrimPrefix(message, "Unable to parse range: "), "!") {
+			e.Status, e.Code = http.StatusBadRequest, CodeInvalidRange
+		} else {
+			e.Status, e.Code = http.StatusNotFound, CodeSheetNotFound
+		}
+	case err.Code == http.StatusBadRequest:
+		e.Status, e.Code = http.StatusBadRequest, CodeInvalidRequest
+	case err.Code == http.StatusUnauthorized:
+		e.Status, e.Code = http.StatusBadGateway, CodeBackendAuth
+	case err.Code == http.StatusForbidden && isRateLimit(reason):
+		e.Status, e.Code = http.StatusTooManyRequests, CodeQuotaExceeded
+	case err.Code == http.StatusForbidden:
+		e.Status, e.Code = http.StatusForbidden, CodePermissionDenied
+	case err.Code == http.StatusNotFound:
+		e.Status, e.Code = http.StatusNotFound, CodeSpreadsheetNotFound
+	case err.Code == http.StatusConflict:
+		e.Status, e.Code = http.StatusConflict, CodeConflict
+	case err.Code == http.StatusTooManyRequests:
+		e.Status, e.Code = http.StatusTooManyRequests, CodeQuotaExceeded
+	case err.Code >= 500:
+		e.Status, e.Code = http.StatusBadGateway, CodeBackendUnavailable
+	default:
+		e.Status, e.Code = http.StatusBadGateway, CodeBackendError
+	}
+	return e
+}
+
+func isRateLimit(reason string) bool {
+	switch reason {
+	case "rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded", "dailyLimitExceeded":
+		return true
+	}
+	return false
+}
+
+// Write sends a JSON error with the given status and code.
+func Write(w http.ResponseWriter, status int, code string, message string) {
+	WriteError(w, &Error{Status: status, Code: code, Message: message})
+}
+
+// WriteFrom classifies err with From and sends it.
+func WriteFrom(w http.ResponseWriter, err error, status int, code string, message string) {
+	WriteError(w, From(err, status, code, message))
+}
+
+func WriteError(w http.ResponseWriter, e *Error) {
+	response := struct {
+		Error *Error `json:"error"`
+	}{
+		Error: e,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(response)
+}
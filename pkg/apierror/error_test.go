@@ -0,0 +1,73 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestFrom(t *testing.T) {
+	cases := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"plain error", errors.New("boom"), http.StatusInternalServerError, CodeInternal},
+		{"api error", New(http.StatusBadRequest, CodeColumnNotFound, "no column"), http.StatusBadRequest, CodeColumnNotFound},
+		{"wrapped api error", fmt.Errorf("outer: %w", New(http.StatusConflict, CodeConflict, "inner")), http.StatusConflict, CodeConflict},
+		{"spreadsheet not found", &googleapi.Error{Code: 404, Message: "Requested entity was not found."}, http.StatusNotFound, CodeSpreadsheetNotFound},
+		{"sheet not found", &googleapi.Error{Code: 400, Message: "Unable to parse range: Employees"}, http.StatusNotFound, CodeSheetNotFound},
+		{"invalid range", &googleapi.Error{Code: 400, Message: "Unable to parse range: Sheet1!@4"}, http.StatusBadRequest, CodeInvalidRange},
+		{"bad request", &googleapi.Error{Code: 400, Message: "Invalid requests[0].deleteSheet"}, http.StatusBadRequest, CodeInvalidRequest},
+		{"permission denied", &googleapi.Error{Code: 403, Message: "The caller does not have permission"}, http.StatusForbidden, CodePermissionDenied},
+		{"rate limit as 403", &googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "userRateLimitExceeded"}}}, http.StatusTooManyRequests, CodeQuotaExceeded},
+		{"quota exceeded", &googleapi.Error{Code: 429, Message: "Quota exceeded"}, http.StatusTooManyRequests, CodeQuotaExceeded},
+		{"backend credentials", &googleapi.Error{Code: 401}, http.StatusBadGateway, CodeBackendAuth},
+		{"backend down", fmt.Errorf("failed to retrieve spreadsheet: %w", &googleapi.Error{Code: 503}), http.StatusBadGateway, CodeBackendUnavailable},
+	}
+
+	for _, c := range cases {
+		e := From(c.err, http.StatusInternalServerError, CodeInternal, "Request failed")
+		if e.Status != c.status || e.Code != c.code {
+			t.Errorf("%s: expected %d %s but got %d %s", c.name, c.status, c.code, e.Status, e.Code)
+		}
+	}
+}
+
+func TestWriteFrom(t *testing.T) {
+	res := httptest.NewRecorder()
+	WriteFrom(res, &googleapi.Error{Code: 404, Message: "Requested entity was not found."}, http.StatusInternalServerError, CodeInternal, "Cannot get sheets info")
+
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res.Code)
+	}
+	if res.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected a JSON response but got %q", res.Header().Get("Content-Type"))
+	}
+
+	var body struct {
+		Error struct {
+			Code    string                 `json:"code"`
+			Message string                 `json:"message"`
+			Details map[string]interface{} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.Error.Code != CodeSpreadsheetNotFound {
+		t.Errorf("Expected code %s but got %s", CodeSpreadsheetNotFound, body.Error.Code)
+	}
+	if body.Error.Message != "Cannot get sheets info: Requested entity was not found." {
+		t.Errorf("Unexpected message %q", body.Error.Message)
+	}
+	if body.Error.Details["backendStatus"] != float64(404) {
+		t.Errorf("Expected backend status in details but got %v", body.Error.Details)
+	}
+}
@@ -0,0 +1,63 @@
+package audit
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"personnel-api/pkg/apierror"
+)
+
+/*
+GET
+Query params: from=2024-01-02T00:00:00Z&to=2024-01-03T00:00:00Z&principal=PRINCIPAL&spreadsheetID=YOUR_SPREAD_SHEET_ID&limit=100
+Events are listed newest first. from is inclusive and to exclusive; every
+parameter is optional.
+*/
+func QueryAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	filter := Filter{
+		Principal:     query.Get("principal"),
+		SpreadsheetID: query.Get("spreadsheetID"),
+		Limit:         100,
+	}
+
+	for name, target := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, name+" must be an RFC 3339 timestamp")
+				return
+			}
+			*target = parsed
+		}
+	}
+
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "limit must be a positive integer")
+			return
+		}
+		filter.Limit = parsed
+	}
+
+	events, err := Default().Query(filter)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, err.Error())
+		return
+	}
+
+	response := struct {
+		Events []*Event `json:"events"`
+	}{Events: events}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
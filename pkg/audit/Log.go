@@ -0,0 +1,257 @@
+// Package audit records one structured event per API call to an append-only
+// JSON Lines file, rotated by size, and answers queries over the events kept.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event is the record of one API call.
+type Event struct {
+	Time          time.Time `json:"time"`
+	Principal     string    `json:"principal,omitempty"`
+	Method        string    `json:"method"`
+	Route         string    `json:"route"`
+	SpreadsheetID string    `json:"spreadsheetID,omitempty"`
+	SheetName     string    `json:"sheetName,omitempty"`
+	Range         string    `json:"range,omitempty"`
+	Status        int       `json:"status"`
+	LatencyMs     float64   `json:"latencyMs"`
+}
+
+// Filter selects events in Query. Zero fields match everything.
+type Filter struct {
+	From          time.Time
+	To            time.Time
+	Principal     string
+	SpreadsheetID string
+	Limit         int
+}
+
+func (f Filter) match(e *Event) bool {
+	if !f.From.IsZero() && e.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.Time.Before(f.To) {
+		return false
+	}
+	if f.Principal != "" && e.Principal != f.Principal {
+		return false
+	}
+	if f.SpreadsheetID != "" && e.SpreadsheetID != f.SpreadsheetID {
+		return false
+	}
+	return true
+}
+
+// Log appends events to its file. Once the file would grow past maxSize it
+// is renamed to file.1, older files moving up to file.maxFiles, beyond which
+// they are removed.
+type Log struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+	mu       sync.Mutex
+}
+
+// NewLog returns a log writing to path. An empty path discards events and
+// maxSize 0 never rotates.
+func NewLog(path string, maxSize int64, maxFiles int) *Log {
+	return &Log{path: path, maxSize: maxSize, maxFiles: maxFiles}
+}
+
+// NewLogFromEnv configures a log from AUDIT_FILE (default audit.jsonl),
+// AUDIT_MAX_SIZE_MB (default 10) and AUDIT_MAX_FILES (default 5).
+func NewLogFromEnv() (*Log, error) {
+	path := os.Getenv("AUDIT_FILE")
+	if path == "" {
+		path = "audit.jsonl"
+	}
+
+	maxSize := 10
+	if value := os.Getenv("AUDIT_MAX_SIZE_MB"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return nil, fmt.Errorf("invalid AUDIT_MAX_SIZE_MB: %q", value)
+		}
+		maxSize = parsed
+	}
+
+	maxFiles := 5
+	if value := os.Getenv("AUDIT_MAX_FILES"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			return nil, fmt.Errorf("invalid AUDIT_MAX_FILES: %q", value)
+		}
+		maxFiles = parsed
+	}
+
+	return NewLog(path, int64(maxSize)*1024*1024, maxFiles), nil
+}
+
+var (
+	defaultMu  sync.RWMutex
+	defaultLog = NewLog("", 0, 0)
+)
+
+// SetDefault replaces the log returned by Default.
+func SetDefault(l *Log) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLog = l
+}
+
+// Default returns the log used by the middleware and handler. It discards
+// events until SetDefault is called.
+func Default() *Log {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultLog
+}
+
+// Write appends event to the log, rotating the file first if needed.
+func (l *Log) Write(event *Event) error {
+	if l.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxSize > 0 {
+		info, err := os.Stat(l.path)
+		if err == nil && info.Size() > 0 && info.Size()+int64(len(data)) > l.maxSize {
+			if err := l.rotate(); err != nil {
+				return fmt.Errorf("unable to rotate audit log: %v", err)
+			}
+		}
+	}
+
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("unable to write audit log: %v", err)
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return fmt.Errorf("unable to write audit log: %v", err)
+	}
+	return file.Close()
+}
+
+func (l *Log) rotate() error {
+	if err := os.Remove(l.rotated(l.maxFiles)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		if err := os.Rename(l.rotated(i), l.rotated(i+1)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(l.path, l.rotated(1))
+}
+
+func (l *Log) rotated(i int) string {
+	return l.path + "." + strconv.Itoa(i)
+}
+
+// Query returns the events matching filter from the current and rotated
+// files, newest first.
+func (l *Log) Query(filter Filter) ([]*Event, error) {
+	if l.path == "" {
+		return []*Event{}, nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := []*Event{}
+	files := []string{l.path}
+	for i := 1; i <= l.maxFiles; i++ {
+		files = append(files, l.rotated(i))
+	}
+	for _, path := range files {
+		found, err := readEvents(path, filter)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, found...)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.After(events[j].Time)
+	})
+	if filter.Limit > 0 && len(events) > filter.Limit {
+		events = events[:filter.Limit]
+	}
+	return events, nil
+}
+
+// readEvents returns the matching events of one file. A missing file holds
+// no events and lines that do not parse are skipped.
+func readEvents(path string, filter Filter) ([]*Event, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read audit log: %v", err)
+	}
+	defer file.Close()
+
+	var events []*Event
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var event Event
+		if json.Unmarshal(scanner.Bytes(), &event) != nil {
+			continue
+		}
+		if filter.match(&event) {
+			events = append(events, &event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read audit log: %v", err)
+	}
+	return events, nil
+}
+
+type eventKey struct{}
+
+// WithEvent returns a copy of ctx carrying the event of the current call, so
+// handlers and inner middleware can add to it.
+func WithEvent(ctx context.Context, event *Event) context.Context {
+	return context.WithValue(ctx, eventKey{}, event)
+}
+
+// SetTarget records the spreadsheet and sheet a call operates on, as resolved
+// by authorization, on the event carried by ctx, if there is one.
+func SetTarget(ctx context.Context, spreadsheetID string, sheetName string) {
+	if event, ok := ctx.Value(eventKey{}).(*Event); ok {
+		event.SpreadsheetID = spreadsheetID
+		event.SheetName = sheetName
+	}
+}
+
+// SetRange records the range a call affected on the event carried by ctx, if
+// there is one.
+func SetRange(ctx context.Context, affected string) {
+	if event, ok := ctx.Value(eventKey{}).(*Event); ok {
+		event.Range = affected
+	}
+}
@@ -0,0 +1,86 @@
+package audit
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLogRotationAndQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	log := NewLog(path, 400, 2)
+
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 12; i++ {
+		principal := "alice"
+		if i%2 == 1 {
+			principal = "bob"
+		}
+		err := log.Write(&Event{
+			Time:          start.Add(time.Duration(i) * time.Hour),
+			Principal:     principal,
+			Method:        http.MethodPut,
+			Route:         "/UpdateDataCell",
+			SpreadsheetID: "ss",
+			SheetName:     "Sheet1",
+			Status:        http.StatusOK,
+		})
+		if err != nil {
+			t.Fatalf("Write returned error: %v", err)
+		}
+	}
+
+	if _, err := os.Stat(path + ".2"); err != nil {
+		t.Errorf("Expected the log to be rotated twice: %v", err)
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Errorf("Expected at most 2 rotated files")
+	}
+	if info, _ := os.Stat(path); info.Size() > 400 {
+		t.Errorf("Expected the current file to stay under the size limit but it has %d bytes", info.Size())
+	}
+
+	all, err := log.Query(Filter{})
+	if err != nil {
+		t.Fatalf("Query returned error: %v", err)
+	}
+	if len(all) == 0 || len(all) >= 12 || !all[0].Time.Equal(start.Add(11*time.Hour)) {
+		t.Fatalf("Expected the kept events newest first, the oldest rotated away, but got %d", len(all))
+	}
+
+	found, _ := log.Query(Filter{From: start.Add(9 * time.Hour), To: start.Add(11 * time.Hour), Principal: "bob"})
+	if len(found) != 1 || !found[0].Time.Equal(start.Add(9*time.Hour)) {
+		t.Errorf("Expected the 09:00 event of bob but got %+v", found)
+	}
+	if found, _ := log.Query(Filter{SpreadsheetID: "other"}); len(found) != 0 {
+		t.Errorf("Expected no events of another spreadsheet but got %d", len(found))
+	}
+	if found, _ := log.Query(Filter{Limit: 2}); len(found) != 2 {
+		t.Errorf("Expected 2 events but got %d", len(found))
+	}
+}
+
+func TestQueryAudit(t *testing.T) {
+	previous := Default()
+	log := NewLog(filepath.Join(t.TempDir(), "audit.jsonl"), 0, 1)
+	SetDefault(log)
+	defer SetDefault(previous)
+
+	log.Write(&Event{Time: time.Now().UTC(), Principal: "alice", Method: http.MethodDelete, Route: "/DeleteSpreadsheet", SpreadsheetID: "ss", Status: http.StatusOK})
+
+	res := httptest.NewRecorder()
+	QueryAudit(res, httptest.NewRequest(http.MethodGet, "/QueryAudit?principal=alice&spreadsheetID=ss", nil))
+	if res.Code != http.StatusOK || !bytes.Contains(res.Body.Bytes(), []byte(`"route":"/DeleteSpreadsheet"`)) {
+		t.Errorf("Unexpected response %d %s", res.Code, res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	QueryAudit(res, httptest.NewRequest(http.MethodGet, "/QueryAudit?from=yesterday", nil))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d but got %d", http.StatusBadRequest, res.Code)
+	}
+}
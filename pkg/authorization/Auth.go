@@ -1,11 +1,267 @@
 package authorization
 
-import "net/http"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
 
+	"personnel-api/pkg/apierror"
+
+	"github.com/casbin/casbin/v2"
+)
+
+var (
+	enforcerMu sync.RWMutex
+	enforcer   *casbin.SyncedEnforcer
+)
+
+// SetEnforcer sets the enforcer managed by the policy endpoints. It must be
+// the same instance used by middleware.Authorize so changes apply at once.
+func SetEnforcer(e *casbin.SyncedEnforcer) {
+	enforcerMu.Lock()
+	defer enforcerMu.Unlock()
+	enforcer = e
+}
+
+func getEnforcer() *casbin.SyncedEnforcer {
+	enforcerMu.RLock()
+	defer enforcerMu.RUnlock()
+	return enforcer
+}
+
+// Allowed asks the enforcer whether the principal set on r by
+// middleware.Authorize may call r's route on a spreadsheet and sheet.
+// Handlers acting on a target they load, such as the spreadsheet of a logged
+// change, check it with Allowed, as Authorize only sees the target named in
+// the request.
+func Allowed(r *http.Request, spreadsheetID string, sheetName string) (bool, error) {
+	e := getEnforcer()
+	if e == nil {
+		return false, errors.New("no policy enforcer is set")
+	}
+	return e.Enforce(PrincipalFromContext(r.Context()), r.URL.Path, r.Method, spreadsheetID, sheetName)
+}
+
+// policyRequest is either a permission (subject, object, action, optionally
+// narrowed to a spreadsheet and sheet) or a role grouping (user, role).
+// Object, spreadsheet and sheet accept keyMatch patterns such as "/v1/*";
+// an empty spreadsheet or sheet means "*".
+type policyRequest struct {
+	Subject     string `json:"subject,omitempty"`
+	Object      string `json:"object,omitempty"`
+	Action      string `json:"action,omitempty"`
+	Spreadsheet string `json:"spreadsheet,omitempty"`
+	Sheet       string `json:"sheet,omitempty"`
+	User        string `json:"user,omitempty"`
+	Role        string `json:"role,omitempty"`
+}
+
+type policyList struct {
+	Policies  []policyRequest `json:"policies"`
+	Groupings []policyRequest `json:"groupings"`
+}
+
+func (p policyRequest) isGrouping() bool {
+	return p.User != "" || p.Role != ""
+}
+
+func (p policyRequest) validate() error {
+	if p.isGrouping() {
+		if p.Subject != "" || p.Object != "" || p.Action != "" || p.Spreadsheet != "" || p.Sheet != "" {
+			return fmt.Errorf("send either a permission (subject, object, action) or a grouping (user, role), not both")
+		}
+		if p.User == "" || p.Role == "" {
+			return fmt.Errorf("user and role fields are required")
+		}
+		return nil
+	}
+
+	if p.Subject == "" || p.Object == "" || p.Action == "" {
+		return fmt.Errorf("subject, object and action fields are required")
+	}
+	return nil
+}
+
+// rule returns the permission as stored by Casbin, filling in wildcards.
+func (p policyRequest) rule() []interface{} {
+	spreadsheet, sheet := p.Spreadsheet, p.Sheet
+	if spreadsheet == "" {
+		spreadsheet = "*"
+	}
+	if sheet == "" {
+		sheet = "*"
+	}
+	return []interface{}{p.Subject, p.Object, p.Action, spreadsheet, sheet}
+}
+
+/*
+POST
+Body: {"subject": "hr_team", "object": "/UpdateDataRow", "action": "PUT", "spreadsheet": "SPREADSHEET_ID", "sheet": "Employees"}
+or:   {"user": "alice", "role": "hr_team"}
+*/
 func AddPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	req, ok := readPolicyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	e := getEnforcer()
+	var added bool
+	var err error
+	if req.isGrouping() {
+		added, err = e.AddGroupingPolicy(req.User, req.Role)
+	} else {
+		added, err = e.AddPolicy(req.rule()...)
+	}
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Cannot add policy: "+err.Error())
+		return
+	}
 
+	if added {
+		if err := e.SavePolicy(); err != nil {
+			apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Cannot save policy: "+err.Error())
+			return
+		}
+	}
+
+	message := "Policy added successfully"
+	if !added {
+		message = "Policy already exists"
+	}
+	writePolicyResponse(w, message, e)
 }
 
+/*
+DELETE
+Body: {"subject": "hr_team", "object": "/UpdateDataRow", "action": "PUT", "spreadsheet": "SPREADSHEET_ID", "sheet": "Employees"}
+or:   {"user": "alice", "role": "hr_team"}
+*/
 func RemovePolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	req, ok := readPolicyRequest(w, r)
+	if !ok {
+		return
+	}
+
+	e := getEnforcer()
+	var removed bool
+	var err error
+	if req.isGrouping() {
+		removed, err = e.RemoveGroupingPolicy(req.User, req.Role)
+	} else {
+		removed, err = e.RemovePolicy(req.rule()...)
+	}
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Cannot remove policy: "+err.Error())
+		return
+	}
+
+	if !removed {
+		apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "Policy not found")
+		return
+	}
+
+	if err := e.SavePolicy(); err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Cannot save policy: "+err.Error())
+		return
+	}
+
+	writePolicyResponse(w, "Policy removed successfully", e)
+}
+
+/*
+GET
+Query params (optional): subject=SUBJECT&object=/Route&spreadsheet=SPREADSHEET_ID
+*/
+func ListPolicies(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	subject := r.URL.Query().Get("subject")
+	object := r.URL.Query().Get("object")
+	spreadsheet := r.URL.Query().Get("spreadsheet")
+
+	list := listPolicies(getEnforcer())
+	filtered := policyList{Policies: []policyRequest{}, Groupings: []policyRequest{}}
+	for _, p := range list.Policies {
+		if (subject == "" || p.Subject == subject) && (object == "" || p.Object == object) && (spreadsheet == "" || p.Spreadsheet == spreadsheet) {
+			filtered.Policies = append(filtered.Policies, p)
+		}
+	}
+	for _, g := range list.Groupings {
+		if subject == "" || g.User == subject || g.Role == subject {
+			filtered.Groupings = append(filtered.Groupings, g)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(filtered)
+}
+
+func readPolicyRequest(w http.ResponseWriter, r *http.Request) (policyRequest, bool) {
+	var req policyRequest
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
+		return req, false
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
+		return req, false
+	}
+
+	if err := req.validate(); err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return req, false
+	}
+
+	return req, true
+}
+
+func listPolicies(e *casbin.SyncedEnforcer) policyList {
+	list := policyList{Policies: []policyRequest{}, Groupings: []policyRequest{}}
+	for _, rule := range e.GetPolicy() {
+		if len(rule) < 5 {
+			continue
+		}
+		list.Policies = append(list.Policies, policyRequest{Subject: rule[0], Object: rule[1], Action: rule[2], Spreadsheet: rule[3], Sheet: rule[4]})
+	}
+	for _, rule := range e.GetGroupingPolicy() {
+		if len(rule) < 2 {
+			continue
+		}
+		list.Groupings = append(list.Groupings, policyRequest{User: rule[0], Role: rule[1]})
+	}
+	return list
+}
+
+func writePolicyResponse(w http.ResponseWriter, message string, e *casbin.SyncedEnforcer) {
+	response := struct {
+		Message string `json:"message"`
+		policyList
+	}{
+		Message:    message,
+		policyList: listPolicies(e),
+	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
 }
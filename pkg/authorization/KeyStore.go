@@ -0,0 +1,105 @@
+package authorization
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeyStore resolves an API key to the principal used as the Casbin subject.
+type KeyStore interface {
+	Lookup(key string) (principal string, ok bool)
+}
+
+// FileKeyStore loads keys from a CSV-like file with one "key, principal" pair
+// per line. A key may be stored as "sha256:<hex digest>" so the file does not
+// have to hold the secret itself. Blank lines and lines starting with # are
+// ignored.
+type FileKeyStore struct {
+	path string
+	mu   sync.RWMutex
+	keys map[string]string
+}
+
+func NewFileKeyStore(path string) (*FileKeyStore, error) {
+	store := &FileKeyStore{path: path}
+	if err := store.Reload(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reload re-reads the key file, keeping the previous keys if it fails.
+func (s *FileKeyStore) Reload() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return fmt.Errorf("unable to open API key file: %v", err)
+	}
+	defer f.Close()
+
+	keys := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(text, ",", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("%s:%d: expected \"key, principal\"", s.path, line)
+		}
+		key := strings.TrimSpace(parts[0])
+		principal := strings.TrimSpace(parts[1])
+		if key == "" || principal == "" {
+			return fmt.Errorf("%s:%d: key and principal must not be empty", s.path, line)
+		}
+
+		digest := strings.TrimPrefix(key, "sha256:")
+		if digest == key {
+			digest = hashKey(key)
+		}
+		keys[strings.ToLower(digest)] = principal
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("unable to read API key file: %v", err)
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *FileKeyStore) Lookup(key string) (string, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	principal, ok := s.keys[hashKey(key)]
+	return principal, ok
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the authenticated principal.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal stored by WithPrincipal, or an
+// empty string for unauthenticated requests.
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
@@ -0,0 +1,189 @@
+package authorization
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/casbin/casbin/v2"
+	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
+)
+
+func newTestEnforcer(t *testing.T) string {
+	dir := t.TempDir()
+	modelPath := filepath.Join(dir, "model.conf")
+	policyPath := filepath.Join(dir, "policy.csv")
+
+	model := `[request_definition]
+r = sub, obj, act, spreadsheet, sheet
+
+[policy_definition]
+p = sub, obj, act, spreadsheet, sheet
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && keyMatch(r.obj, p.obj) && (p.act == "*" || r.act == p.act) && keyMatch(r.spreadsheet, p.spreadsheet) && keyMatch(r.sheet, p.sheet)
+`
+	if err := os.WriteFile(modelPath, []byte(model), 0o600); err != nil {
+		t.Fatalf("Failed to write model: %v", err)
+	}
+	if err := os.WriteFile(policyPath, []byte("p, admin, /GetAll, GET, *, *\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write policy: %v", err)
+	}
+
+	e, err := casbin.NewSyncedEnforcer(modelPath, fileadapter.NewAdapter(policyPath))
+	if err != nil {
+		t.Fatalf("Failed to create enforcer: %v", err)
+	}
+	SetEnforcer(e)
+	return policyPath
+}
+
+func decodePolicyList(t *testing.T, res *httptest.ResponseRecorder) policyList {
+	var list policyList
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return list
+}
+
+func TestAddPolicy(t *testing.T) {
+	policyPath := newTestEnforcer(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/AddPolicy", bytes.NewReader([]byte(`{"subject": "hr_team", "object": "/GetSheets", "action": "GET"}`)))
+	res := httptest.NewRecorder()
+	AddPolicy(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, res.Code)
+	}
+	if list := decodePolicyList(t, res); len(list.Policies) != 2 {
+		t.Errorf("Expected 2 policies but got %v", list.Policies)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/AddPolicy", bytes.NewReader([]byte(`{"user": "alice", "role": "hr_team"}`)))
+	res = httptest.NewRecorder()
+	AddPolicy(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, res.Code)
+	}
+
+	if ok, _ := getEnforcer().Enforce("alice", "/GetSheets", "GET", "any-spreadsheet", "Sheet1"); !ok {
+		t.Error("Expected alice to inherit hr_team permissions")
+	}
+
+	saved, _ := os.ReadFile(policyPath)
+	if !strings.Contains(string(saved), "p, hr_team, /GetSheets, GET, *, *") || !strings.Contains(string(saved), "g, alice, hr_team") {
+		t.Errorf("Expected policy file to be saved, got:\n%s", saved)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/AddPolicy", bytes.NewReader([]byte(`{"subject": "hr_team", "object": "/UpdateDataRow", "action": "PUT", "spreadsheet": "payroll", "sheet": "Employees"}`)))
+	res = httptest.NewRecorder()
+	AddPolicy(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, res.Code)
+	}
+	if ok, _ := getEnforcer().Enforce("alice", "/UpdateDataRow", "PUT", "payroll", "Employees"); !ok {
+		t.Error("Expected alice to update the Employees sheet")
+	}
+	if ok, _ := getEnforcer().Enforce("alice", "/UpdateDataRow", "PUT", "payroll", "Salaries"); ok {
+		t.Error("Expected alice not to update the Salaries sheet")
+	}
+
+	// test error handling
+	for _, body := range []string{``, `{}`, `{"subject": "hr_team"}`, `{"user": "alice"}`, `{"subject": "a", "object": "b", "action": "c", "role": "d"}`} {
+		res = httptest.NewRecorder()
+		AddPolicy(res, httptest.NewRequest(http.MethodPost, "/AddPolicy", bytes.NewReader([]byte(body))))
+		if res.Code != http.StatusBadRequest {
+			t.Errorf("Body %q: expected status code %d but got %d", body, http.StatusBadRequest, res.Code)
+		}
+	}
+
+	res = httptest.NewRecorder()
+	AddPolicy(res, httptest.NewRequest(http.MethodGet, "/AddPolicy", nil))
+	if res.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected status code %d but got %d", http.StatusMethodNotAllowed, res.Code)
+	}
+}
+
+func TestRemovePolicy(t *testing.T) {
+	policyPath := newTestEnforcer(t)
+
+	req := httptest.NewRequest(http.MethodDelete, "/RemovePolicy", bytes.NewReader([]byte(`{"subject": "admin", "object": "/GetAll", "action": "GET"}`)))
+	res := httptest.NewRecorder()
+	RemovePolicy(res, req)
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, res.Code)
+	}
+	if list := decodePolicyList(t, res); len(list.Policies) != 0 {
+		t.Errorf("Expected no policies but got %v", list.Policies)
+	}
+
+	saved, _ := os.ReadFile(policyPath)
+	if strings.Contains(string(saved), "/GetAll") {
+		t.Errorf("Expected policy to be removed from file, got:\n%s", saved)
+	}
+
+	res = httptest.NewRecorder()
+	RemovePolicy(res, httptest.NewRequest(http.MethodDelete, "/RemovePolicy", bytes.NewReader([]byte(`{"user": "nobody", "role": "admin"}`))))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res.Code)
+	}
+}
+
+func TestListPolicies(t *testing.T) {
+	newTestEnforcer(t)
+	getEnforcer().AddPolicy("hr_team", "/GetSheets", "GET", "*", "*")
+	getEnforcer().AddGroupingPolicy("alice", "hr_team")
+
+	res := httptest.NewRecorder()
+	ListPolicies(res, httptest.NewRequest(http.MethodGet, "/ListPolicies?subject=hr_team", nil))
+
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d", http.StatusOK, res.Code)
+	}
+
+	list := decodePolicyList(t, res)
+	if len(list.Policies) != 1 || list.Policies[0].Object != "/GetSheets" {
+		t.Errorf("Expected only the hr_team policy but got %v", list.Policies)
+	}
+	if len(list.Groupings) != 1 || list.Groupings[0].User != "alice" {
+		t.Errorf("Expected the alice grouping but got %v", list.Groupings)
+	}
+}
+
+func TestPolicyChangesDuringEnforce(t *testing.T) {
+	newTestEnforcer(t)
+
+	// run with -race: policy changes must not race with Enforce
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			getEnforcer().Enforce("admin", "/GetAll", "GET", "any", "Sheet1")
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		body := `{"subject": "team` + strings.Repeat("x", i) + `", "object": "/GetSheets", "action": "GET"}`
+		AddPolicy(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/AddPolicy", bytes.NewReader([]byte(body))))
+		RemovePolicy(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/RemovePolicy", bytes.NewReader([]byte(body))))
+	}
+	<-done
+
+	if ok, _ := getEnforcer().Enforce("admin", "/GetAll", "GET", "any", "Sheet1"); !ok {
+		t.Errorf("Expected the admin policy to survive the changes")
+	}
+}
@@ -0,0 +1,271 @@
+// Package cache keeps recently read sheet values in process so repeated reads
+// of the same sheet do not each cost a Sheets API call. Entries are keyed by
+// spreadsheet and sheet name, expire after a TTL and are evicted least
+// recently used first once the entry or cell limit is reached. Write helpers
+// invalidate the sheets they touch.
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultTTL        = 30 * time.Second
+	DefaultMaxEntries = 100
+	DefaultMaxCells   = 1000000
+)
+
+// Stats counts cache activity since the cache was created.
+type Stats struct {
+	Hits          uint64 `json:"hits"`
+	Misses        uint64 `json:"misses"`
+	Evictions     uint64 `json:"evictions"`
+	Invalidations uint64 `json:"invalidations"`
+	Entries       int    `json:"entries"`
+	Cells         int    `json:"cells"`
+}
+
+type key struct {
+	spreadsheetID string
+	sheetName     string
+}
+
+// makeKey folds the sheet name, as sheet titles are unique regardless of case
+// and ranges may spell them either way.
+func makeKey(spreadsheetID string, sheetName string) key {
+	return key{spreadsheetID, strings.ToLower(sheetName)}
+}
+
+type entry struct {
+	key     key
+	values  [][]interface{}
+	cells   int
+	expires time.Time
+}
+
+// Cache is a read-through cache of sheet values. A Cache with a zero TTL is
+// disabled and passes every Fetch through to the loader.
+type Cache struct {
+	mu         sync.Mutex
+	ttl        time.Duration
+	maxEntries int
+	maxCells   int
+	entries    map[key]*list.Element
+	lru        *list.List
+	cells      int
+	generation uint64
+	stats      Stats
+
+	now func() time.Time
+}
+
+func New(ttl time.Duration, maxEntries int, maxCells int) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		maxCells:   maxCells,
+		entries:    map[key]*list.Element{},
+		lru:        list.New(),
+		now:        time.Now,
+	}
+}
+
+// NewFromEnv builds a cache configured by CACHE_TTL (a duration such as 30s,
+// 0 disables the cache), CACHE_MAX_ENTRIES and CACHE_MAX_CELLS.
+func NewFromEnv() (*Cache, error) {
+	ttl := DefaultTTL
+	if s := os.Getenv("CACHE_TTL"); s != "" {
+		var err error
+		if ttl, err = time.ParseDuration(s); err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid CACHE_TTL %q", s)
+		}
+	}
+
+	maxEntries, err := envInt("CACHE_MAX_ENTRIES", DefaultMaxEntries)
+	if err != nil {
+		return nil, err
+	}
+	maxCells, err := envInt("CACHE_MAX_CELLS", DefaultMaxCells)
+	if err != nil {
+		return nil, err
+	}
+
+	return New(ttl, maxEntries, maxCells), nil
+}
+
+func envInt(name string, fallback int) (int, error) {
+	s := os.Getenv(name)
+	if s == "" {
+		return fallback, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, s)
+	}
+	return n, nil
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultCache = New(0, 0, 0)
+)
+
+// SetDefault replaces the cache returned by Default.
+func SetDefault(c *Cache) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultCache = c
+}
+
+// Default returns the cache shared by the api packages. It is disabled until
+// SetDefault is called.
+func Default() *Cache {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultCache
+}
+
+func (c *Cache) enabled() bool {
+	return c.ttl > 0 && c.maxEntries > 0
+}
+
+// Fetch returns the cached values of a sheet or calls load and caches its
+// result. Callers get their own copy and may modify it. Errors are not
+// cached, and a result loaded while the cache was invalidated is returned
+// without being stored, as it may predate the write.
+func (c *Cache) Fetch(spreadsheetID string, sheetName string, load func() ([][]interface{}, error)) ([][]interface{}, error) {
+	if !c.enabled() {
+		return load()
+	}
+
+	k := makeKey(spreadsheetID, sheetName)
+
+	c.mu.Lock()
+	if el, ok := c.entries[k]; ok {
+		e := el.Value.(*entry)
+		if c.now().Before(e.expires) {
+			c.lru.MoveToFront(el)
+			c.stats.Hits++
+			values := copyValues(e.values)
+			c.mu.Unlock()
+			return values, nil
+		}
+		c.remove(el)
+	}
+	c.stats.Misses++
+	generation := c.generation
+	c.mu.Unlock()
+
+	values, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if generation == c.generation {
+		c.store(k, copyValues(values))
+	}
+	return values, nil
+}
+
+func (c *Cache) store(k key, values [][]interface{}) {
+	if el, ok := c.entries[k]; ok {
+		c.remove(el)
+	}
+
+	cells := 0
+	for _, row := range values {
+		cells += len(row)
+	}
+	if c.maxCells > 0 && cells > c.maxCells {
+		return
+	}
+
+	e := &entry{key: k, values: values, cells: cells, expires: c.now().Add(c.ttl)}
+	c.entries[k] = c.lru.PushFront(e)
+	c.cells += cells
+
+	for len(c.entries) > c.maxEntries || (c.maxCells > 0 && c.cells > c.maxCells) {
+		c.remove(c.lru.Back())
+		c.stats.Evictions++
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	c.lru.Remove(el)
+	delete(c.entries, e.key)
+	c.cells -= e.cells
+}
+
+// Invalidate drops the cached values of one sheet.
+func (c *Cache) Invalidate(spreadsheetID string, sheetName string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.stats.Invalidations++
+	if el, ok := c.entries[makeKey(spreadsheetID, sheetName)]; ok {
+		c.remove(el)
+	}
+}
+
+// InvalidateRange drops the sheet named by an A1 range such as
+// 'Sheet 1'!A2:C3. A range without a sheet name refers to the first sheet,
+// which the cache cannot tell apart, so the whole spreadsheet is dropped.
+func (c *Cache) InvalidateRange(spreadsheetID string, a1Range string) {
+	i := strings.LastIndex(a1Range, "!")
+	if i == -1 {
+		c.InvalidateSpreadsheet(spreadsheetID)
+		return
+	}
+
+	sheetName := a1Range[:i]
+	if len(sheetName) >= 2 && strings.HasPrefix(sheetName, "'") && strings.HasSuffix(sheetName, "'") {
+		sheetName = strings.ReplaceAll(sheetName[1:len(sheetName)-1], "''", "'")
+	}
+	c.Invalidate(spreadsheetID, sheetName)
+}
+
+// InvalidateSpreadsheet drops every cached sheet of a spreadsheet, for
+// changes such as renaming or deleting sheets.
+func (c *Cache) InvalidateSpreadsheet(spreadsheetID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.stats.Invalidations++
+	for k, el := range c.entries {
+		if k.spreadsheetID == spreadsheetID {
+			c.remove(el)
+		}
+	}
+}
+
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.entries)
+	stats.Cells = c.cells
+	return stats
+}
+
+func copyValues(values [][]interface{}) [][]interface{} {
+	if values == nil {
+		return nil
+	}
+	copied := make([][]interface{}, len(values))
+	for i, row := range values {
+		copied[i] = append([]interface{}(nil), row...)
+	}
+	return copied
+}
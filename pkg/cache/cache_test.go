@@ -0,0 +1,163 @@
+package cache
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func loader(calls *int, values [][]interface{}) func() ([][]interface{}, error) {
+	return func() ([][]interface{}, error) {
+		*calls++
+		return values, nil
+	}
+}
+
+func TestFetch(t *testing.T) {
+	c := New(time.Minute, 10, 0)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	calls := 0
+	values := [][]interface{}{{"ID", "Name"}, {"1", "test1"}}
+
+	for i := 0; i < 3; i++ {
+		got, err := c.Fetch("s", "Sheet1", loader(&calls, values))
+		if err != nil || !reflect.DeepEqual(got, values) {
+			t.Fatalf("Fetch returned %v, %v", got, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 load but got %d", calls)
+	}
+
+	// callers get their own copy
+	got, _ := c.Fetch("s", "Sheet1", loader(&calls, values))
+	got[1][1] = "changed"
+	got, _ = c.Fetch("s", "SHEET1", loader(&calls, values))
+	if got[1][1] != "test1" {
+		t.Errorf("Cached values were modified through a returned copy")
+	}
+
+	now = now.Add(2 * time.Minute)
+	c.Fetch("s", "Sheet1", loader(&calls, values))
+	if calls != 2 {
+		t.Errorf("Expected expired entry to be reloaded, got %d loads", calls)
+	}
+
+	stats := c.Stats()
+	if stats.Hits != 4 || stats.Misses != 2 || stats.Entries != 1 || stats.Cells != 4 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestFetchError(t *testing.T) {
+	c := New(time.Minute, 10, 0)
+
+	calls := 0
+	load := func() ([][]interface{}, error) {
+		calls++
+		return nil, errors.New("boom")
+	}
+	c.Fetch("s", "Sheet1", load)
+	if _, err := c.Fetch("s", "Sheet1", load); err == nil || calls != 2 {
+		t.Errorf("Expected errors not to be cached, got %v after %d loads", err, calls)
+	}
+}
+
+func TestEviction(t *testing.T) {
+	c := New(time.Minute, 2, 5)
+	calls := 0
+	small := [][]interface{}{{"a"}}
+
+	c.Fetch("s", "A", loader(&calls, small))
+	c.Fetch("s", "B", loader(&calls, small))
+	c.Fetch("s", "A", loader(&calls, small))
+	c.Fetch("s", "C", loader(&calls, small))
+
+	// B was the least recently used entry
+	c.Fetch("s", "A", loader(&calls, small))
+	if calls != 3 {
+		t.Errorf("Expected A to stay cached, got %d loads", calls)
+	}
+	c.Fetch("s", "B", loader(&calls, small))
+	if calls != 4 {
+		t.Errorf("Expected B to be evicted, got %d loads", calls)
+	}
+
+	// entries larger than the cell limit are never stored
+	large := [][]interface{}{{"a", "b", "c"}, {"d", "e", "f"}}
+	c.Fetch("s", "D", loader(&calls, large))
+	c.Fetch("s", "D", loader(&calls, large))
+	if calls != 6 {
+		t.Errorf("Expected oversized entry not to be cached, got %d loads", calls)
+	}
+
+	if stats := c.Stats(); stats.Evictions != 2 || stats.Cells > 5 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestInvalidate(t *testing.T) {
+	c := New(time.Minute, 10, 0)
+	calls := 0
+	values := [][]interface{}{{"a"}}
+
+	fill := func() {
+		for _, sheet := range []string{"Sheet1", "Sheet 2", "It's"} {
+			c.Fetch("s", sheet, loader(&calls, values))
+		}
+		c.Fetch("other", "Sheet1", loader(&calls, values))
+	}
+
+	fill()
+	c.InvalidateRange("s", "sheet1!A2:C2")
+	c.InvalidateRange("s", "'Sheet 2'!A:A")
+	c.InvalidateRange("s", "'It''s'!B3")
+	if stats := c.Stats(); stats.Entries != 1 {
+		t.Errorf("Expected only the other spreadsheet to stay cached, got %+v", stats)
+	}
+
+	fill()
+	c.InvalidateSpreadsheet("s")
+	if stats := c.Stats(); stats.Entries != 1 {
+		t.Errorf("Expected only the other spreadsheet to stay cached, got %+v", stats)
+	}
+
+	// a load that races with a write is not stored
+	c.Fetch("s", "Sheet1", func() ([][]interface{}, error) {
+		c.Invalidate("s", "Sheet1")
+		return values, nil
+	})
+	if stats := c.Stats(); stats.Entries != 1 {
+		t.Errorf("Expected the stale load not to be stored, got %+v", stats)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	c := New(0, 10, 0)
+	calls := 0
+	c.Fetch("s", "Sheet1", loader(&calls, nil))
+	c.Fetch("s", "Sheet1", loader(&calls, nil))
+	if calls != 2 {
+		t.Errorf("Expected a disabled cache to pass through, got %d loads", calls)
+	}
+	if stats := c.Stats(); stats != (Stats{}) {
+		t.Errorf("Expected empty stats but got %+v", stats)
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	t.Setenv("CACHE_TTL", "5s")
+	t.Setenv("CACHE_MAX_ENTRIES", "7")
+	c, err := NewFromEnv()
+	if err != nil || c.ttl != 5*time.Second || c.maxEntries != 7 || c.maxCells != DefaultMaxCells {
+		t.Errorf("Unexpected cache %+v, %v", c, err)
+	}
+
+	t.Setenv("CACHE_TTL", "soon")
+	if _, err := NewFromEnv(); err == nil {
+		t.Errorf("Expected an error for an invalid CACHE_TTL")
+	}
+}
@@ -0,0 +1,143 @@
+// Package etag computes the entity tags returned by the sheet read endpoints
+// and matched against If-Match by the write endpoints. A tag covers the rows a
+// response was read from and names them, e.g. "1-11.3f9a...", so a write can
+// re-read the same rows and check that none of them changed. Changes to other
+// rows of the sheet leave the tag as it was.
+package etag
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// Rows is a span of sheet rows numbered from 1. A Last of 0 runs to the last
+// row of the sheet, so rows added at the end change the tag.
+type Rows struct {
+	First int
+	Last  int
+}
+
+// Compute returns a strong ETag of the rows of sheet values, as returned by
+// the backend, in spans. Adjacent spans are merged, so the header row and the
+// data rows under it make a single span.
+func Compute(values [][]interface{}, spans ...Rows) string {
+	spans = merge(spans)
+
+	var selected [][]interface{}
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		selected = append(selected, slice(values, span))
+		names[i] = span.String()
+	}
+
+	data, _ := json.Marshal(struct {
+		Rows   []string        `json:"r"`
+		Values [][]interface{} `json:"v"`
+	}{names, selected})
+	sum := sha256.Sum256(data)
+	return `"` + strings.Join(names, ",") + "." + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Spans returns the rows named by a tag made by Compute. It reports false for
+// "*", weak tags and tags this package did not make.
+func Spans(tag string) ([]Rows, bool) {
+	if len(tag) < 2 || tag[0] != '"' || tag[len(tag)-1] != '"' {
+		return nil, false
+	}
+	names, _, ok := strings.Cut(tag[1:len(tag)-1], ".")
+	if !ok || names == "" {
+		return nil, false
+	}
+
+	var spans []Rows
+	for _, name := range strings.Split(names, ",") {
+		first, last, ok := strings.Cut(name, "-")
+		if !ok {
+			return nil, false
+		}
+		span := Rows{}
+		var err error
+		if span.First, err = strconv.Atoi(first); err != nil || span.First < 1 {
+			return nil, false
+		}
+		if last != "" {
+			if span.Last, err = strconv.Atoi(last); err != nil || span.Last < span.First {
+				return nil, false
+			}
+		}
+		spans = append(spans, span)
+	}
+	return spans, true
+}
+
+// String returns the span as it appears in a tag, e.g. 2-11, or 12- for a
+// span running to the last row.
+func (r Rows) String() string {
+	if r.Last == 0 {
+		return strconv.Itoa(r.First) + "-"
+	}
+	return strconv.Itoa(r.First) + "-" + strconv.Itoa(r.Last)
+}
+
+// merge joins spans that touch or overlap. Spans must be in order of their
+// first row.
+func merge(spans []Rows) []Rows {
+	var merged []Rows
+	for _, span := range spans {
+		if n := len(merged); n > 0 {
+			prev := &merged[n-1]
+			if prev.Last == 0 {
+				continue
+			}
+			if span.First <= prev.Last+1 {
+				if span.Last == 0 || span.Last > prev.Last {
+					prev.Last = span.Last
+				}
+				continue
+			}
+		}
+		merged = append(merged, span)
+	}
+	return merged
+}
+
+// slice returns the values of the rows in span, stopping at the last row of
+// the sheet.
+func slice(values [][]interface{}, span Rows) []interface{} {
+	rows := []interface{}{}
+	last := span.Last
+	if last == 0 || last > len(values) {
+		last = len(values)
+	}
+	for i := span.First - 1; i < last; i++ {
+		rows = append(rows, values[i])
+	}
+	return rows
+}
+
+// Match reports whether an If-None-Match header lists etag. "*" matches any
+// tag, and weak tags compare equal to the strong tag they name. If-Match needs
+// a strong comparison instead, which Spans gives by refusing weak tags.
+func Match(header string, etag string) bool {
+	for _, candidate := range List(header) {
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// List splits an If-Match or If-None-Match header into its tags.
+func List(header string) []string {
+	var tags []string
+	for _, candidate := range strings.Split(header, ",") {
+		if candidate = strings.TrimSpace(candidate); candidate != "" {
+			tags = append(tags, candidate)
+		}
+	}
+	return tags
+}
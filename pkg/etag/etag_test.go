@@ -0,0 +1,99 @@
+package etag
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func sheet() [][]interface{} {
+	return [][]interface{}{
+		{"Name", "Score"},
+		{"Ada", "90"},
+		{"Grace", "85"},
+		{"Linus", "70"},
+	}
+}
+
+func TestCompute(t *testing.T) {
+	values := sheet()
+	tag := Compute(values, Rows{First: 1, Last: 1}, Rows{First: 2, Last: 3})
+	if !strings.HasPrefix(tag, `"1-3.`) || !strings.HasSuffix(tag, `"`) {
+		t.Fatalf("Expected a quoted tag naming rows 1-3 but got %s", tag)
+	}
+	if again := Compute(sheet(), Rows{First: 1, Last: 3}); again != tag {
+		t.Errorf("Expected the same values to give the same tag %s but got %s", tag, again)
+	}
+
+	// rows outside the span do not change the tag
+	values[3][1] = "75"
+	if got := Compute(values, Rows{First: 1, Last: 3}); got != tag {
+		t.Errorf("Expected an edit outside the rows to keep %s but got %s", tag, got)
+	}
+	values[2][1] = "80"
+	if got := Compute(values, Rows{First: 1, Last: 3}); got == tag {
+		t.Errorf("Expected an edit inside the rows to change %s", tag)
+	}
+
+	// a span running to the last row covers rows added at the end
+	open := Compute(values, Rows{First: 2})
+	values = append(values, []interface{}{"Ken", "60"})
+	if got := Compute(values, Rows{First: 2}); got == open {
+		t.Errorf("Expected an appended row to change %s", open)
+	}
+
+	// the same values on other rows give another tag
+	if Compute(sheet(), Rows{First: 2, Last: 2}) == Compute(sheet(), Rows{First: 3, Last: 3}) {
+		t.Error("Expected different rows to give different tags")
+	}
+}
+
+func TestSpans(t *testing.T) {
+	cases := []struct {
+		spans []Rows
+		name  string
+	}{
+		{[]Rows{{First: 1}}, "1-"},
+		{[]Rows{{First: 1, Last: 1}, {First: 5, Last: 6}}, "1-1,5-6"},
+		{[]Rows{{First: 1, Last: 1}, {First: 2}}, "1-"},
+		{[]Rows{{First: 2}, {First: 7, Last: 9}}, "2-"},
+	}
+	for _, c := range cases {
+		tag := Compute(sheet(), c.spans...)
+		if !strings.HasPrefix(tag, `"`+c.name+".") {
+			t.Errorf("Compute(%v) = %s, expected it to name %s", c.spans, tag, c.name)
+		}
+		spans, ok := Spans(tag)
+		if !ok || Compute(sheet(), spans...) != tag {
+			t.Errorf("Spans(%s) = %v, %v, expected the rows of the tag", tag, spans, ok)
+		}
+	}
+
+	if spans, ok := Spans(`"2-4,9-.abc"`); !ok || !reflect.DeepEqual(spans, []Rows{{First: 2, Last: 4}, {First: 9}}) {
+		t.Errorf("Spans = %v, %v, expected 2-4 and 9-", spans, ok)
+	}
+	for _, tag := range []string{"*", `W/"1-.abc"`, `"abc"`, `".abc"`, `"0-2.abc"`, `"3-2.abc"`, `"a-b.abc"`, `1-.abc`} {
+		if _, ok := Spans(tag); ok {
+			t.Errorf("Spans(%s) expected to fail", tag)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tag := Compute(sheet(), Rows{First: 1})
+	cases := map[string]bool{
+		tag:                    true,
+		"*":                    true,
+		"W/" + tag:             true,
+		`"stale", ` + tag:      true,
+		` "stale" ,W/` + tag:   true,
+		`"stale"`:              false,
+		"":                     false,
+		strings.Trim(tag, `"`): false,
+	}
+	for header, expected := range cases {
+		if got := Match(header, tag); got != expected {
+			t.Errorf("Match(%q) = %v, expected %v", header, got, expected)
+		}
+	}
+}
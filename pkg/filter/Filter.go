@@ -0,0 +1,332 @@
+// Package filter implements the row filter expressions accepted by the read
+// endpoints, for example:
+//
+//	Score >= 9 AND (Name startsWith 'te' NOCASE OR Email is empty)
+//	Joined between 2024-01-01 and 2024-06-30
+//	Department in ('HR', 'IT') AND NOT Email matches '@example\.com$'
+//
+// Column names are bare words or `back quoted` when they contain spaces.
+// Values are bare words or quoted strings; bare numbers compare numerically
+// and dates (2006-01-02, 2006-01-02T15:04:05, 1/2/2006, ...) compare as dates.
+package filter
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"personnel-api/pkg/apierror"
+)
+
+const (
+	OpBetween    = "BETWEEN"
+	OpIn         = "IN"
+	OpEmpty      = "EMPTY"
+	OpStartsWith = "STARTSWITH"
+	OpEndsWith   = "ENDSWITH"
+	OpContains   = "CONTAINS"
+	OpMatches    = "MATCHES"
+)
+
+// Expr is a node of a parsed filter expression.
+type Expr interface {
+	// bind resolves column names against the header row.
+	bind(columns map[string]int) error
+	// Match reports whether a row bound with Compile satisfies the expression.
+	Match(row []interface{}) bool
+	String() string
+}
+
+type And struct {
+	Left, Right Expr
+}
+
+type Or struct {
+	Left, Right Expr
+}
+
+type Not struct {
+	Expr Expr
+}
+
+// Comparison tests the value of one column. Op is one of =, !=, <, <=, >, >=
+// or one of the Op constants.
+type Comparison struct {
+	Column string
+	Op     string
+	Values []Value
+	NoCase bool
+
+	index int
+	re    *regexp.Regexp
+}
+
+// Value is a literal from the expression with its number and date readings.
+type Value struct {
+	Text   string
+	Quoted bool
+
+	number   float64
+	isNumber bool
+	date     time.Time
+	isDate   bool
+}
+
+var numberPattern = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?$`)
+
+var dateLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006/01/02",
+	"1/2/2006",
+	"1/2/2006 15:04:05",
+	"1/2/2006 15:04",
+}
+
+func newValue(text string, quoted bool) Value {
+	v := Value{Text: text, Quoted: quoted}
+	if !quoted && numberPattern.MatchString(text) {
+		v.number, _ = strconv.ParseFloat(text, 64)
+		v.isNumber = true
+		return v
+	}
+	v.date, v.isDate = ParseDate(text)
+	return v
+}
+
+// ParseDate reads text in any of the date layouts the filter language
+// accepts.
+func ParseDate(text string) (time.Time, bool) {
+	text = strings.TrimSpace(text)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Compile parses input and binds it to header, the first row of the sheet.
+// Unknown columns are reported as COLUMN_NOT_FOUND.
+func Compile(input string, header []interface{}) (Expr, error) {
+	expr, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		key := fmt.Sprint(name)
+		if _, ok := columns[key]; !ok && key != "" {
+			columns[key] = i
+		}
+	}
+
+	if err := expr.bind(columns); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+func (e *And) bind(columns map[string]int) error {
+	if err := e.Left.bind(columns); err != nil {
+		return err
+	}
+	return e.Right.bind(columns)
+}
+
+func (e *And) Match(row []interface{}) bool {
+	return e.Left.Match(row) && e.Right.Match(row)
+}
+
+func (e *And) String() string {
+	return "(" + e.Left.String() + " AND " + e.Right.String() + ")"
+}
+
+func (e *Or) bind(columns map[string]int) error {
+	if err := e.Left.bind(columns); err != nil {
+		return err
+	}
+	return e.Right.bind(columns)
+}
+
+func (e *Or) Match(row []interface{}) bool {
+	return e.Left.Match(row) || e.Right.Match(row)
+}
+
+func (e *Or) String() string {
+	return "(" + e.Left.String() + " OR " + e.Right.String() + ")"
+}
+
+func (e *Not) bind(columns map[string]int) error {
+	return e.Expr.bind(columns)
+}
+
+func (e *Not) Match(row []interface{}) bool {
+	return !e.Expr.Match(row)
+}
+
+func (e *Not) String() string {
+	return "NOT " + e.Expr.String()
+}
+
+func (c *Comparison) bind(columns map[string]int) error {
+	index, ok := columns[c.Column]
+	if !ok {
+		return apierror.New(http.StatusBadRequest, apierror.CodeColumnNotFound, "no column found with that name: %v", c.Column)
+	}
+	c.index = index
+	return nil
+}
+
+func (c *Comparison) String() string {
+	var values []string
+	for _, v := range c.Values {
+		values = append(values, strconv.Quote(v.Text))
+	}
+
+	s := "`" + c.Column + "` " + c.Op
+	switch c.Op {
+	case OpBetween:
+		s += " " + values[0] + " AND " + values[1]
+	case OpIn:
+		s += " (" + strings.Join(values, ", ") + ")"
+	case OpEmpty:
+	default:
+		s += " " + values[0]
+	}
+	if c.NoCase {
+		s += " NOCASE"
+	}
+	return s
+}
+
+func (c *Comparison) Match(row []interface{}) bool {
+	cell := ""
+	if c.index < len(row) && row[c.index] != nil {
+		cell = fmt.Sprint(row[c.index])
+	}
+
+	switch c.Op {
+	case OpEmpty:
+		return strings.TrimSpace(cell) == ""
+	case OpMatches:
+		return c.re.MatchString(cell)
+	case OpStartsWith:
+		return strings.HasPrefix(c.fold(cell), c.fold(c.Values[0].Text))
+	case OpEndsWith:
+		return strings.HasSuffix(c.fold(cell), c.fold(c.Values[0].Text))
+	case OpContains:
+		return strings.Contains(c.fold(cell), c.fold(c.Values[0].Text))
+	case OpIn:
+		for _, v := range c.Values {
+			if cmp, ok := c.compare(cell, v); ok && cmp == 0 {
+				return true
+			}
+		}
+		return false
+	case OpBetween:
+		low, okLow := c.compare(cell, c.Values[0])
+		high, okHigh := c.compare(cell, c.Values[1])
+		return okLow && okHigh && low >= 0 && high <= 0
+	}
+
+	cmp, ok := c.compare(cell, c.Values[0])
+	switch c.Op {
+	case "=":
+		return ok && cmp == 0
+	case "!=":
+		return !ok || cmp != 0
+	case "<":
+		return ok && cmp < 0
+	case "<=":
+		return ok && cmp <= 0
+	case ">":
+		return ok && cmp > 0
+	case ">=":
+		return ok && cmp >= 0
+	}
+	return false
+}
+
+func (c *Comparison) fold(s string) string {
+	if c.NoCase {
+		return strings.ToLower(s)
+	}
+	return s
+}
+
+// compare orders cell against v using the type of the literal: numbers and
+// dates only compare with cells of the same type, anything else as text.
+func (c *Comparison) compare(cell string, v Value) (int, bool) {
+	switch {
+	case v.isNumber:
+		n, err := strconv.ParseFloat(strings.TrimSpace(cell), 64)
+		if err != nil {
+			return 0, false
+		}
+		switch {
+		case n < v.number:
+			return -1, true
+		case n > v.number:
+			return 1, true
+		}
+		return 0, true
+	case v.isDate:
+		d, ok := ParseDate(cell)
+		if !ok {
+			return 0, false
+		}
+		return d.Compare(v.date), true
+	}
+	return strings.Compare(c.fold(cell), c.fold(v.Text)), true
+}
+
+// CompareCells orders two cell values for sorting. Numbers sort before dates,
+// dates before text and empty cells last; values of the same kind compare
+// numerically, chronologically or as text.
+func CompareCells(a, b string) int {
+	rankA, numA, dateA := cellKind(a)
+	rankB, numB, dateB := cellKind(b)
+	if rankA != rankB {
+		if rankA < rankB {
+			return -1
+		}
+		return 1
+	}
+
+	switch rankA {
+	case 0:
+		switch {
+		case numA < numB:
+			return -1
+		case numA > numB:
+			return 1
+		}
+		return 0
+	case 1:
+		return dateA.Compare(dateB)
+	}
+	return strings.Compare(a, b)
+}
+
+func cellKind(s string) (int, float64, time.Time) {
+	trimmed := strings.TrimSpace(s)
+	if trimmed == "" {
+		return 3, 0, time.Time{}
+	}
+	if numberPattern.MatchString(trimmed) {
+		n, _ := strconv.ParseFloat(trimmed, 64)
+		return 0, n, time.Time{}
+	}
+	if d, ok := ParseDate(trimmed); ok {
+		return 1, 0, d
+	}
+	return 2, 0, time.Time{}
+}
@@ -0,0 +1,350 @@
+package filter
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"personnel-api/pkg/apierror"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenWord
+	tokenString
+	tokenField
+	tokenOp
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// keyword reports whether t is the bare word kw, ignoring case.
+func (t token) keyword(kw string) bool {
+	return t.kind == tokenWord && strings.EqualFold(t.text, kw)
+}
+
+func syntaxError(pos int, format string, args ...interface{}) error {
+	e := apierror.New(http.StatusBadRequest, apierror.CodeInvalidFilter, format, args...)
+	e.Message = "invalid filter: " + e.Message
+	e.Details = map[string]interface{}{"position": pos}
+	return e
+}
+
+func lex(input string) ([]token, error) {
+	var tokens []token
+	runes := []rune(input)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case r == '\'' || r == '"' || r == '`':
+			start := i
+			var sb strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != r; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				sb.WriteRune(runes[i])
+			}
+			if i >= len(runes) {
+				return nil, syntaxError(start, "unterminated quote at position %d", start)
+			}
+			i++
+			kind := tokenString
+			if r == '`' {
+				kind = tokenField
+			}
+			tokens = append(tokens, token{kind, sb.String(), start})
+		case r == '&' || r == '|':
+			if i+1 >= len(runes) || runes[i+1] != r {
+				return nil, syntaxError(i, "unexpected %q at position %d", r, i)
+			}
+			word := "AND"
+			if r == '|' {
+				word = "OR"
+			}
+			tokens = append(tokens, token{tokenWord, word, i})
+			i += 2
+		case strings.ContainsRune("=!<>", r):
+			start := i
+			op := string(r)
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '<' && runes[i+1] == '>')) {
+				op += string(runes[i+1])
+			}
+			i += len([]rune(op))
+			switch op {
+			case "=", "==", "!=", "<>", "<", "<=", ">", ">=":
+			default:
+				return nil, syntaxError(start, "unknown operator %q at position %d", op, start)
+			}
+			tokens = append(tokens, token{tokenOp, op, start})
+		default:
+			start := i
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && !strings.ContainsRune("(),'\"`=!<>&|", runes[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenWord, string(runes[start:i]), start})
+		}
+	}
+
+	return append(tokens, token{tokenEOF, "", len(runes)}), nil
+}
+
+var keywords = map[string]bool{
+	"AND": true, "OR": true, "NOT": true, "BETWEEN": true, "IN": true, "IS": true, "EMPTY": true,
+	"STARTSWITH": true, "ENDSWITH": true, "CONTAINS": true, "CONTAIN": true, "MATCHES": true, "REGEX": true,
+	"NOCASE": true,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse turns a filter expression into an expression tree. Errors are
+// *apierror.Error values with the INVALID_FILTER code and the position of the
+// offending token.
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 1 {
+		return nil, syntaxError(0, "expression is empty")
+	}
+
+	p := &parser{tokens: tokens}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, syntaxError(t.pos, "unexpected %q at position %d", t.text, t.pos)
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(kw string) bool {
+	if p.peek().keyword(kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) parseOr() (Expr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("OR") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &Or{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (Expr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("AND") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &And{Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if p.accept("NOT") {
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &Not{Expr: expr}, nil
+	}
+
+	if p.peek().kind == tokenLParen {
+		p.next()
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.next(); t.kind != tokenRParen {
+			return nil, syntaxError(t.pos, "expected ) at position %d", t.pos)
+		}
+		return expr, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (Expr, error) {
+	field := p.next()
+	if field.kind != tokenField && (field.kind != tokenWord || keywords[strings.ToUpper(field.text)]) {
+		return nil, syntaxError(field.pos, "expected a column name at position %d", field.pos)
+	}
+
+	c := &Comparison{Column: field.text}
+	negate := false
+
+	t := p.next()
+	switch {
+	case t.kind == tokenOp:
+		c.Op = t.text
+		switch c.Op {
+		case "==":
+			c.Op = "="
+		case "<>":
+			c.Op = "!="
+		}
+	case t.keyword("IS"):
+		negate = p.accept("NOT")
+		if !p.accept("EMPTY") {
+			return nil, syntaxError(p.peek().pos, "expected EMPTY at position %d", p.peek().pos)
+		}
+		c.Op = OpEmpty
+	default:
+		if t.keyword("NOT") {
+			negate = true
+			t = p.next()
+		}
+		switch strings.ToUpper(t.text) {
+		case OpBetween, OpIn, OpStartsWith, OpEndsWith, OpContains, OpMatches:
+			c.Op = strings.ToUpper(t.text)
+		case "CONTAIN":
+			c.Op = OpContains
+		case "REGEX":
+			c.Op = OpMatches
+		}
+		if t.kind != tokenWord || c.Op == "" {
+			return nil, syntaxError(t.pos, "expected an operator after %q at position %d", field.text, t.pos)
+		}
+	}
+
+	var err error
+	switch c.Op {
+	case OpEmpty:
+	case OpBetween:
+		c.Values, err = p.parseValues(2, "AND")
+	case OpIn:
+		if t := p.next(); t.kind != tokenLParen {
+			return nil, syntaxError(t.pos, "expected ( after IN at position %d", t.pos)
+		}
+		c.Values, err = p.parseList()
+	default:
+		c.Values, err = p.parseValues(1, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c.NoCase = p.accept("NOCASE")
+
+	if c.Op == OpMatches {
+		pattern := c.Values[0].Text
+		if c.NoCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, syntaxError(field.pos, "invalid regular expression %q: %v", c.Values[0].Text, err)
+		}
+		c.re = re
+	}
+
+	if negate {
+		return &Not{Expr: c}, nil
+	}
+	return c, nil
+}
+
+// parseValues reads n values separated by the keyword sep.
+func (p *parser) parseValues(n int, sep string) ([]Value, error) {
+	var values []Value
+	for i := 0; i < n; i++ {
+		if i > 0 && !p.accept(sep) {
+			return nil, syntaxError(p.peek().pos, "expected %s at position %d", sep, p.peek().pos)
+		}
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func (p *parser) parseList() ([]Value, error) {
+	var values []Value
+	for {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		t := p.next()
+		if t.kind == tokenRParen {
+			return values, nil
+		}
+		if t.kind != tokenComma {
+			return nil, syntaxError(t.pos, "expected , or ) at position %d", t.pos)
+		}
+	}
+}
+
+func (p *parser) parseValue() (Value, error) {
+	t := p.next()
+	switch {
+	case t.kind == tokenString:
+		return newValue(t.text, true), nil
+	case t.kind == tokenWord && !keywords[strings.ToUpper(t.text)]:
+		return newValue(t.text, false), nil
+	case t.kind == tokenEOF:
+		return Value{}, syntaxError(t.pos, "expected a value at end of expression")
+	default:
+		return Value{}, syntaxError(t.pos, "expected a value at position %d, got %q", t.pos, t.text)
+	}
+}
@@ -0,0 +1,164 @@
+package filter
+
+import (
+	"errors"
+	"testing"
+
+	"personnel-api/pkg/apierror"
+)
+
+var header = []interface{}{"ID", "Name", "Email", "Score", "Joined", "First Name"}
+
+var rows = [][]interface{}{
+	{"1", "Alice", "alice@gmail.com", "9.8", "2024-01-15", "Al"},
+	{"2", "bob", "bob@example.com", "8.5", "3/2/2024", "Bo"},
+	{"3", "Carol", "", "9.9", "2024-07-01"},
+	{"10", "dave", "dave@gmail.com", "n/a", ""},
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		expr string
+		ids  []string
+	}{
+		{"ID = 1", []string{"1"}},
+		{"ID == 2", []string{"2"}},
+		{"ID != 1", []string{"2", "3", "10"}},
+		{"ID <> 1", []string{"2", "3", "10"}},
+		{"ID > 2", []string{"3", "10"}},
+		{"ID >= 2", []string{"2", "3", "10"}},
+		{"ID < 3", []string{"1", "2"}},
+		{"ID <= 3", []string{"1", "2", "3"}},
+		{"ID>2", []string{"3", "10"}},
+		{"ID > '2'", []string{"3"}},
+		{"Score between 9 and 10", []string{"1", "3"}},
+		{"Score not between 9 and 10", []string{"2", "10"}},
+		{"Name in (Alice, 'bob')", []string{"1", "2"}},
+		{"Name in ('alice', 'BOB') NOCASE", []string{"1", "2"}},
+		{"Name not in (Alice)", []string{"2", "3", "10"}},
+		{"Name startsWith 'a'", nil},
+		{"Name startsWith 'a' NOCASE", []string{"1"}},
+		{"Email endsWith gmail.com", []string{"1", "10"}},
+		{"Email contains example", []string{"2"}},
+		{"Email contain .com", []string{"1", "2", "10"}},
+		{"Email matches '^[a-c].*@'", []string{"1", "2"}},
+		{"Name regex '^[A-Z]'", []string{"1", "3"}},
+		{"Name matches '^c' NOCASE", []string{"3"}},
+		{"Email is empty", []string{"3"}},
+		{"Email is not empty", []string{"1", "2", "10"}},
+		{"`First Name` is empty", []string{"3", "10"}},
+		{"`First Name` = Bo", []string{"2"}},
+		{"Name = alice NOCASE", []string{"1"}},
+		{"Joined > 2024-02-01", []string{"2", "3"}},
+		{"Joined between '2024-01-01' and '2024-03-31'", []string{"1", "2"}},
+		{"Joined < 2024-02-01", []string{"1"}},
+		{"Score > 9 AND Email is not empty", []string{"1"}},
+		{"Score > 9 && Email is not empty", []string{"1"}},
+		{"ID = 1 OR ID = 10", []string{"1", "10"}},
+		{"ID = 1 || Name = Carol", []string{"1", "3"}},
+		{"ID = 1 OR ID = 2 AND Score > 9", []string{"1"}},
+		{"(ID = 1 OR ID = 2) AND Score < 9", []string{"2"}},
+		{"NOT (ID = 1 OR ID = 2)", []string{"3", "10"}},
+		{"Score >= 0", []string{"1", "2", "3"}},
+	}
+
+	for _, c := range cases {
+		expr, err := Compile(c.expr, header)
+		if err != nil {
+			t.Errorf("Compile(%q) returned error: %v", c.expr, err)
+			continue
+		}
+
+		var ids []string
+		for _, row := range rows {
+			if expr.Match(row) {
+				ids = append(ids, row[0].(string))
+			}
+		}
+		if len(ids) != len(c.ids) {
+			t.Errorf("%q matched %v, expected %v", c.expr, ids, c.ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != c.ids[i] {
+				t.Errorf("%q matched %v, expected %v", c.expr, ids, c.ids)
+				break
+			}
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	cases := []struct {
+		expr string
+		code string
+	}{
+		{"", apierror.CodeInvalidFilter},
+		{"ID", apierror.CodeInvalidFilter},
+		{"ID =", apierror.CodeInvalidFilter},
+		{"ID = 1 AND", apierror.CodeInvalidFilter},
+		{"ID = 1 OR OR ID = 2", apierror.CodeInvalidFilter},
+		{"(ID = 1", apierror.CodeInvalidFilter},
+		{"ID = 1)", apierror.CodeInvalidFilter},
+		{"ID => 1", apierror.CodeInvalidFilter},
+		{"ID ! 1", apierror.CodeInvalidFilter},
+		{"ID like 1", apierror.CodeInvalidFilter},
+		{"Name = 'open", apierror.CodeInvalidFilter},
+		{"Score between 1", apierror.CodeInvalidFilter},
+		{"Score between 1 or 2", apierror.CodeInvalidFilter},
+		{"Name in Alice", apierror.CodeInvalidFilter},
+		{"Name in (Alice", apierror.CodeInvalidFilter},
+		{"Email is", apierror.CodeInvalidFilter},
+		{"Email matches '('", apierror.CodeInvalidFilter},
+		{"ID = 1 & ID = 2", apierror.CodeInvalidFilter},
+		{"Phone = 1", apierror.CodeColumnNotFound},
+		{"ID = 1 OR `Last Name` is empty", apierror.CodeColumnNotFound},
+	}
+
+	for _, c := range cases {
+		_, err := Compile(c.expr, header)
+		var apiErr *apierror.Error
+		if !errors.As(err, &apiErr) {
+			t.Errorf("Compile(%q) expected an api error but got %v", c.expr, err)
+			continue
+		}
+		if apiErr.Code != c.code || apiErr.Status != 400 {
+			t.Errorf("Compile(%q) returned %d %s, expected 400 %s", c.expr, apiErr.Status, apiErr.Code, c.code)
+		}
+	}
+}
+
+func TestParseString(t *testing.T) {
+	expr, err := Parse("a = 1 or b in (x, 'y z') and not c is empty")
+	if err != nil {
+		t.Fatalf("Parse returned error: %v", err)
+	}
+
+	expected := "(`a` = \"1\" OR (`b` IN (\"x\", \"y z\") AND NOT `c` EMPTY))"
+	if expr.String() != expected {
+		t.Errorf("Expected %s but got %s", expected, expr.String())
+	}
+}
+
+func TestCompareCells(t *testing.T) {
+	cases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"2", "10", -1},
+		{"10", "9.5", 1},
+		{"1.0", "1", 0},
+		{"2024-01-02", "1/3/2024", -1},
+		{"5", "2024-01-01", -1},
+		{"2024-01-01", "abc", -1},
+		{"abc", "abd", -1},
+		{"", "abc", 1},
+		{"", "", 0},
+	}
+
+	for _, c := range cases {
+		if got := CompareCells(c.a, c.b); got != c.expected {
+			t.Errorf("CompareCells(%q, %q) = %d, expected %d", c.a, c.b, got, c.expected)
+		}
+	}
+}
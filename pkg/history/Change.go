@@ -0,0 +1,222 @@
+// Package history keeps a log of the changes made to sheet values through the
+// API so a change can be reverted or a range restored to an earlier state.
+// Each change holds the smallest rectangle covering every cell that changed,
+// with the values of that rectangle before and after the write. The write
+// helpers note the block they change, so cells of the rectangle that a write
+// did not touch are null and left alone when it is reverted.
+package history
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"personnel-api/pkg/a1"
+	"personnel-api/pkg/svc"
+)
+
+// Change is one logged write to a sheet.
+type Change struct {
+	ID            int64           `json:"id"`
+	SpreadsheetID string          `json:"spreadsheetID"`
+	SheetName     string          `json:"sheetName"`
+	Range         string          `json:"range"`
+	Operation     string          `json:"operation"`
+	Principal     string          `json:"principal,omitempty"`
+	Time          time.Time       `json:"time"`
+	RevertOf      int64           `json:"revertOf,omitempty"`
+	Before        [][]interface{} `json:"before"`
+	After         [][]interface{} `json:"after"`
+}
+
+// area is a rectangle of cells with 0-based inclusive bounds.
+type area struct {
+	startRow, startCol int
+	endRow, endCol     int
+}
+
+func (a area) String() string {
+	return a1.ColumnLetter(a.startCol) + strconv.Itoa(a.startRow+1) + ":" + a1.ColumnLetter(a.endCol) + strconv.Itoa(a.endRow+1)
+}
+
+// Snapshot reads the values of a whole sheet straight from the backend, so a
+// stale cache entry never ends up in the log.
+func Snapshot(spreadsheetID string, sheetName string) ([][]interface{}, error) {
+	resp, err := svc.GetBackend().GetValues(spreadsheetID, sheetName)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Values, nil
+}
+
+// Diff returns the change between two snapshots of a sheet, or nil when they
+// hold the same values. The caller fills in the remaining fields.
+func Diff(sheetName string, before [][]interface{}, after [][]interface{}) *Change {
+	changed := area{startRow: -1, startCol: -1, endRow: -1, endCol: -1}
+
+	rows := max(len(before), len(after))
+	for i := 0; i < rows; i++ {
+		cols := max(rowWidth(before, i), rowWidth(after, i))
+		for j := 0; j < cols; j++ {
+			if cell(before, i, j) == cell(after, i, j) {
+				continue
+			}
+			if changed.startRow < 0 {
+				changed.startRow = i
+			}
+			changed.endRow = i
+			if changed.startCol < 0 || j < changed.startCol {
+				changed.startCol = j
+			}
+			if j > changed.endCol {
+				changed.endCol = j
+			}
+		}
+	}
+	if changed.startRow < 0 {
+		return nil
+	}
+
+	return &Change{
+		SheetName: sheetName,
+		Range:     sheetRange(sheetName, changed),
+		Before:    extract(before, changed),
+		After:     extract(after, changed),
+	}
+}
+
+// extract copies the cells of a from values, filling cells past the end of a
+// row with "" so writing the result back clears them.
+func extract(values [][]interface{}, a area) [][]interface{} {
+	out := make([][]interface{}, 0, a.endRow-a.startRow+1)
+	for i := a.startRow; i <= a.endRow; i++ {
+		row := make([]interface{}, 0, a.endCol-a.startCol+1)
+		for j := a.startCol; j <= a.endCol; j++ {
+			row = append(row, cell(values, i, j))
+		}
+		out = append(out, row)
+	}
+	return out
+}
+
+// apply writes block into values at the top left corner of a, growing values
+// as needed, and returns the result. Null cells of block are skipped.
+func apply(values [][]interface{}, a area, block [][]interface{}) [][]interface{} {
+	for len(values) <= a.endRow {
+		values = append(values, []interface{}{})
+	}
+	for i, blockRow := range block {
+		row := values[a.startRow+i]
+		for len(row) < a.startCol+len(blockRow) {
+			row = append(row, "")
+		}
+		for j, v := range blockRow {
+			if v != nil {
+				row[a.startCol+j] = v
+			}
+		}
+		values[a.startRow+i] = row
+	}
+	return values
+}
+
+// sameValues compares two blocks cell by cell by their text. Null cells of b
+// match anything.
+func sameValues(a [][]interface{}, b [][]interface{}) bool {
+	rows := max(len(a), len(b))
+	for i := 0; i < rows; i++ {
+		cols := max(rowWidth(a, i), rowWidth(b, i))
+		for j := 0; j < cols; j++ {
+			if j < rowWidth(b, i) && b[i][j] == nil {
+				continue
+			}
+			if cell(a, i, j) != cell(b, i, j) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// bounds returns the area covering every row and column of the given
+// snapshots, and false if they are all empty.
+func bounds(snapshots ...[][]interface{}) (area, bool) {
+	a := area{endRow: -1, endCol: -1}
+	for _, values := range snapshots {
+		for i := range values {
+			if width := rowWidth(values, i); width > 0 {
+				a.endRow = max(a.endRow, i)
+				a.endCol = max(a.endCol, width-1)
+			}
+		}
+	}
+	return a, a.endRow >= 0
+}
+
+// sheetRange returns the A1 range of a on a sheet. The sheet name is always
+// quoted, so names with spaces, ! or ' can be written back.
+func sheetRange(sheetName string, a area) string {
+	return a1.QuoteSheetName(sheetName) + "!" + a.String()
+}
+
+// clone copies values, so the copy can be changed on its own.
+func clone(values [][]interface{}) [][]interface{} {
+	out := make([][]interface{}, len(values))
+	for i, row := range values {
+		out[i] = append([]interface{}{}, row...)
+	}
+	return out
+}
+
+// parseArea parses a range such as "A2:C10", optionally prefixed with a sheet
+// name, which is returned unquoted as well.
+func parseArea(ref string) (string, area, error) {
+	sheetName, cells := "", ref
+	if idx := strings.LastIndex(ref, "!"); idx >= 0 {
+		sheetName, cells = a1.UnquoteSheetName(ref[:idx]), ref[idx+1:]
+	}
+
+	a, ok := parseCells(cells)
+	if !ok || a.endRow < 0 {
+		return "", area{}, fmt.Errorf("range %q must name two cells such as A2:C10", cells)
+	}
+	return sheetName, a, nil
+}
+
+// parseCells parses cells such as A2:C10, A2:C or B3. The end row is -1 when
+// the range has none.
+func parseCells(cells string) (area, bool) {
+	refs := strings.Split(cells, ":")
+	if len(refs) > 2 {
+		return area{}, false
+	}
+	startCol, startRow, ok := a1.ParseCell(refs[0])
+	if !ok || startCol < 0 || startRow < 0 {
+		return area{}, false
+	}
+	a := area{startRow: startRow, startCol: startCol, endRow: startRow, endCol: startCol}
+	if len(refs) == 2 {
+		if a.endCol, a.endRow, ok = a1.ParseCell(refs[1]); !ok || a.endCol < 0 {
+			return area{}, false
+		}
+	}
+	if a.endCol < a.startCol || (a.endRow >= 0 && a.endRow < a.startRow) {
+		return area{}, false
+	}
+	return a, true
+}
+
+func rowWidth(values [][]interface{}, i int) int {
+	if i >= len(values) {
+		return 0
+	}
+	return len(values[i])
+}
+
+func cell(values [][]interface{}, i int, j int) string {
+	if i >= len(values) || j >= len(values[i]) || values[i][j] == nil {
+		return ""
+	}
+	return fmt.Sprint(values[i][j])
+}
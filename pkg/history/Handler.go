@@ -0,0 +1,307 @@
+package history
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/audit"
+	"personnel-api/pkg/authorization"
+	"personnel-api/pkg/cache"
+	"personnel-api/pkg/svc"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+/*
+GET
+Query params: spreadsheetID=YOUR_SPREAD_SHEET_ID&sheetName=SHEET_NAME&since=2024-01-02T15:04:05Z&limit=100
+Changes are listed newest first. Without sheetName the changes of every sheet
+of the spreadsheet are listed.
+*/
+func ListChanges(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	query := r.URL.Query()
+	spreadsheetID := query.Get("spreadsheetID")
+	if spreadsheetID == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID field is required")
+		return
+	}
+
+	var since time.Time
+	if value := query.Get("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "since must be an RFC 3339 timestamp")
+			return
+		}
+		since = parsed
+	}
+
+	limit := 100
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "limit must be a positive integer")
+			return
+		}
+		limit = parsed
+	}
+
+	changes := Default().List(spreadsheetID, query.Get("sheetName"), since)
+	if len(changes) > limit {
+		changes = changes[:limit]
+	}
+
+	response := struct {
+		Changes []*Change `json:"changes"`
+	}{Changes: changes}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+/*
+POST
+
+	Body: {
+			"id": 42,
+			"force": false
+		  }
+
+The range of the change is written back to its values before the change. If
+the range no longer holds the values the change left, the revert is refused
+with 409 unless force is set. The caller must be allowed to call RevertChange
+on the spreadsheet and sheet of the change; a spreadsheetID or sheetName sent
+with the request must name them too, or the revert is refused with 400.
+*/
+func RevertChange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
+		return
+	}
+
+	var req struct {
+		ID            int64  `json:"id"`
+		Force         bool   `json:"force"`
+		SpreadsheetID string `json:"spreadsheetID"`
+		SheetName     string `json:"sheetName"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
+		return
+	}
+
+	change := Default().Get(req.ID)
+	if change == nil {
+		apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "Change not found")
+		return
+	}
+
+	// the target authorized with the request is the one the caller named, so
+	// the change's own target is checked too
+	query := r.URL.Query()
+	for _, name := range []string{req.SpreadsheetID, query.Get("spreadsheetID")} {
+		if name != "" && name != change.SpreadsheetID {
+			apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "The change was made on another spreadsheet")
+			return
+		}
+	}
+	for _, name := range []string{req.SheetName, query.Get("sheetName")} {
+		if name != "" && !strings.EqualFold(name, change.SheetName) {
+			apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "The change was made on another sheet")
+			return
+		}
+	}
+	allowed, err := authorization.Allowed(r, change.SpreadsheetID, change.SheetName)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, err.Error())
+		return
+	}
+	if !allowed {
+		apierror.Write(w, http.StatusForbidden, apierror.CodePermissionDenied, "Forbidden")
+		return
+	}
+
+	_, changed, err := parseArea(change.Range)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, err.Error())
+		return
+	}
+
+	reverted, err := restore(change.SpreadsheetID, change.SheetName, &Change{Operation: "RevertChange", Principal: authorization.PrincipalFromContext(r.Context()), RevertOf: change.ID}, func(current [][]interface{}) (area, [][]interface{}, error) {
+		if !req.Force && !sameValues(extract(current, changed), change.After) {
+			return area{}, nil, apierror.New(http.StatusConflict, apierror.CodeConflict, "range %s has changed since change %d", change.Range, change.ID)
+		}
+		return changed, change.Before, nil
+	})
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Failed to revert change")
+		return
+	}
+
+	audit.SetRange(r.Context(), change.Range)
+	writeRestoreResponse(w, change.SpreadsheetID, change.SheetName, change.Range, "Revert successfully!", reverted)
+}
+
+/*
+POST
+
+	Body: {
+			"spreadsheetID": "YOUR_SPREAD_SHEET_ID",
+			"sheetName": "SHEET_NAME",
+			"range": "A2:C10",
+			"time": "2024-01-02T15:04:05Z"
+		  }
+
+The range is set to the values it held at the given time, worked out by undoing
+every logged change made since. Without range the whole sheet is restored.
+*/
+func RestoreRange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
+		return
+	}
+
+	var req struct {
+		SpreadsheetID string    `json:"spreadsheetID"`
+		SheetName     string    `json:"sheetName"`
+		Range         string    `json:"range"`
+		Time          time.Time `json:"time"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
+		return
+	}
+
+	if req.SpreadsheetID == "" || req.SheetName == "" || req.Time.IsZero() {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID, sheetName and time fields are required")
+		return
+	}
+
+	var target *area
+	if req.Range != "" {
+		sheetName, a, err := parseArea(req.Range)
+		if err != nil || (sheetName != "" && !strings.EqualFold(sheetName, req.SheetName)) {
+			apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRange, "range must name two cells of the sheet such as A2:C10")
+			return
+		}
+		target = &a
+	}
+
+	var restoredRange string
+	restored, err := restore(req.SpreadsheetID, req.SheetName, &Change{Operation: "RestoreRange", Principal: authorization.PrincipalFromContext(r.Context())}, func(current [][]interface{}) (area, [][]interface{}, error) {
+		past, err := ValuesAt(req.SpreadsheetID, req.SheetName, current, req.Time)
+		if err != nil {
+			return area{}, nil, err
+		}
+		a := target
+		if a == nil {
+			whole, ok := bounds(current, past)
+			if !ok {
+				return area{}, nil, nil
+			}
+			a = &whole
+		}
+		restoredRange = sheetRange(req.SheetName, *a)
+		return *a, extract(past, *a), nil
+	})
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Failed to restore range")
+		return
+	}
+
+	audit.SetRange(r.Context(), restoredRange)
+	writeRestoreResponse(w, req.SpreadsheetID, req.SheetName, restoredRange, "Restore successfully!", restored)
+}
+
+// ValuesAt works out the values a sheet held at the given time from its
+// current values by undoing, newest first, every change logged since.
+func ValuesAt(spreadsheetID string, sheetName string, current [][]interface{}, at time.Time) ([][]interface{}, error) {
+	values := clone(current)
+	for _, change := range Default().List(spreadsheetID, sheetName, at) {
+		_, changed, err := parseArea(change.Range)
+		if err != nil {
+			return nil, err
+		}
+		values = apply(values, changed, change.Before)
+	}
+	return values, nil
+}
+
+// restore writes the block chosen by pick from the current values of the
+// sheet and logs the write with the operation, principal and revertOf of
+// entry. pick returns an empty block when there is nothing to write. The
+// returned change is nil when the write left the sheet as it was.
+func restore(spreadsheetID string, sheetName string, entry *Change, pick func(current [][]interface{}) (area, [][]interface{}, error)) (*Change, error) {
+	store := Default()
+	defer store.Lock(spreadsheetID, sheetName)()
+
+	before, err := Snapshot(spreadsheetID, sheetName)
+	if err != nil {
+		return nil, err
+	}
+
+	a, block, err := pick(before)
+	if err != nil || len(block) == 0 {
+		return nil, err
+	}
+
+	defer cache.Default().Invalidate(spreadsheetID, sheetName)
+	_, err = svc.GetBackend().UpdateValues(spreadsheetID, sheetRange(sheetName, a), &sheets.ValueRange{Values: block})
+	if err != nil {
+		return nil, err
+	}
+
+	change := Diff(sheetName, before, apply(clone(before), a, block))
+	if change == nil {
+		return nil, nil
+	}
+	change.SpreadsheetID = spreadsheetID
+	change.Operation = entry.Operation
+	change.Principal = entry.Principal
+	change.RevertOf = entry.RevertOf
+	if err := store.Add(change); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+func writeRestoreResponse(w http.ResponseWriter, spreadsheetID string, sheetName string, restoredRange string, message string, change *Change) {
+	response := struct {
+		SpreadsheetID string  `json:"spreadsheetID"`
+		SheetName     string  `json:"sheetName"`
+		Range         string  `json:"range"`
+		Message       string  `json:"message"`
+		Change        *Change `json:"change"`
+	}{
+		SpreadsheetID: spreadsheetID,
+		SheetName:     sheetName,
+		Range:         restoredRange,
+		Message:       message,
+		Change:        change,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
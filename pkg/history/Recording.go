@@ -0,0 +1,385 @@
+package history
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"personnel-api/pkg/a1"
+	"personnel-api/pkg/svc"
+)
+
+// Recording collects the writes the helpers note on one sheet while a
+// request holds the sheet's lock, so the request can log them as one change.
+type Recording struct {
+	store         *Store
+	key           string
+	spreadsheetID string
+	sheetName     string
+	parts         []part
+	unlock        func()
+}
+
+// part is the block one write changed, with its values before and after.
+type part struct {
+	area   area
+	before [][]interface{}
+	after  [][]interface{}
+}
+
+func sheetKey(spreadsheetID string, sheetName string) string {
+	return spreadsheetID + "\x00" + strings.ToLower(sheetName)
+}
+
+// Begin locks a sheet like Lock and collects the writes noted on it until End
+// is called.
+func (s *Store) Begin(spreadsheetID string, sheetName string) *Recording {
+	rec := &Recording{
+		store:         s,
+		key:           sheetKey(spreadsheetID, sheetName),
+		spreadsheetID: spreadsheetID,
+		sheetName:     sheetName,
+		unlock:        s.Lock(spreadsheetID, sheetName),
+	}
+
+	s.recordingsMu.Lock()
+	if s.recordings == nil {
+		s.recordings = map[string]*Recording{}
+	}
+	s.recordings[rec.key] = rec
+	s.recordingsMu.Unlock()
+	return rec
+}
+
+// End stops collecting and releases the sheet.
+func (rec *Recording) End() {
+	rec.store.recordingsMu.Lock()
+	delete(rec.store.recordings, rec.key)
+	rec.store.recordingsMu.Unlock()
+	rec.unlock()
+}
+
+// Commit logs the noted writes as one change and returns it, or nil when
+// they changed nothing. Cells of the change's range that no write touched
+// are null in its before and after values.
+func (rec *Recording) Commit(operation string, principal string) (*Change, error) {
+	change := merge(rec.sheetName, rec.parts)
+	if change == nil {
+		return nil, nil
+	}
+	change.SpreadsheetID = rec.spreadsheetID
+	change.Operation = operation
+	change.Principal = principal
+	if err := rec.store.Add(change); err != nil {
+		return nil, err
+	}
+	return change, nil
+}
+
+// recording returns the recording open on a sheet of the default store, or
+// nil.
+func recording(spreadsheetID string, sheetName string) *Recording {
+	s := Default()
+	s.recordingsMu.Lock()
+	defer s.recordingsMu.Unlock()
+	return s.recordings[sheetKey(spreadsheetID, sheetName)]
+}
+
+// Edit is the block of a sheet a write helper is about to change. It holds
+// the values the block has now, read straight from the backend, and the
+// values the helper's writes give it. Edits are only made while the sheet's
+// writes are being recorded; otherwise NewEdit returns nil, and the methods
+// of a nil Edit do nothing, so helpers use them unconditionally.
+type Edit struct {
+	rec    *Recording
+	area   area
+	before [][]interface{}
+	after  [][]interface{}
+}
+
+// NewEdit reads the block covering ranges, given in A1 notation such as
+// Sheet1!A2:C2. A range without an end row, such as Sheet1!A2:C, runs to the
+// last row holding values. If the block cannot be read the write is not
+// recorded.
+func NewEdit(spreadsheetID string, sheetName string, ranges ...string) *Edit {
+	rec := recording(spreadsheetID, sheetName)
+	if rec == nil || len(ranges) == 0 {
+		return nil
+	}
+
+	box := area{startRow: -1}
+	open := false
+	for _, ref := range ranges {
+		a, ok := parseWriteRange(ref)
+		if !ok {
+			log.Printf("history: cannot record a write to %s", ref)
+			return nil
+		}
+		if a.endRow < 0 {
+			open = true
+			a.endRow = a.startRow
+		}
+		box = union(box, a)
+	}
+
+	readRange := sheetRange(sheetName, box)
+	if open {
+		readRange = a1.QuoteSheetName(sheetName) + "!" + a1.ColumnLetter(box.startCol) + strconv.Itoa(box.startRow+1) + ":" + a1.ColumnLetter(box.endCol)
+	}
+	resp, err := svc.GetBackend().GetValues(spreadsheetID, readRange)
+	if err != nil {
+		log.Printf("history: cannot read %s before writing it: %v", readRange, err)
+		return nil
+	}
+	if open {
+		box.endRow = max(box.endRow, box.startRow+len(resp.Values)-1)
+	}
+
+	before := extract(resp.Values, area{endRow: box.endRow - box.startRow, endCol: box.endCol - box.startCol})
+	return &Edit{rec: rec, area: box, before: before, after: clone(before)}
+}
+
+// NewRowsEdit reads the rows of a sheet from firstRow, a sheet row number,
+// down to its last row, for a helper about to insert or delete rows, which
+// moves every row below. The whole sheet is read, as its width is not known.
+func NewRowsEdit(spreadsheetID string, sheetName string, firstRow int) *Edit {
+	rec := recording(spreadsheetID, sheetName)
+	if rec == nil {
+		return nil
+	}
+
+	values, err := Snapshot(spreadsheetID, sheetName)
+	if err != nil {
+		log.Printf("history: cannot read %s before writing it: %v", sheetName, err)
+		return nil
+	}
+
+	a := area{startRow: firstRow - 1, endRow: max(len(values)-1, firstRow-2)}
+	for i := a.startRow; i < len(values); i++ {
+		a.endCol = max(a.endCol, rowWidth(values, i)-1)
+	}
+	before := extract(values, a)
+	return &Edit{rec: rec, area: a, before: before, after: clone(before)}
+}
+
+// Write sets the values of the block at the top left corner of ref. Null
+// values are skipped, as they are by the Sheets API.
+func (e *Edit) Write(ref string, values [][]interface{}) {
+	if e == nil {
+		return
+	}
+	a, ok := parseWriteRange(ref)
+	if !ok {
+		return
+	}
+	for i, row := range values {
+		for j, v := range row {
+			if v != nil {
+				e.set(a.startRow+i, a.startCol+j, v)
+			}
+		}
+	}
+}
+
+// Clear empties the cells of ref inside the block.
+func (e *Edit) Clear(ref string) {
+	if e == nil {
+		return
+	}
+	a, ok := parseWriteRange(ref)
+	if !ok {
+		return
+	}
+	if a.endRow < 0 {
+		a.endRow = e.area.endRow
+	}
+	for i := max(a.startRow, e.area.startRow); i <= min(a.endRow, e.area.endRow); i++ {
+		for j := max(a.startCol, e.area.startCol); j <= min(a.endCol, e.area.endCol); j++ {
+			e.set(i, j, "")
+		}
+	}
+}
+
+// InsertRows inserts count empty rows before the sheet row numbered row,
+// moving the rows below down.
+func (e *Edit) InsertRows(row int, count int) {
+	if e == nil {
+		return
+	}
+	at := row - 1 - e.area.startRow
+	blank := make([][]interface{}, count)
+	for i := range blank {
+		blank[i] = blankRow(e.width())
+	}
+	e.after = append(e.after[:at], append(blank, e.after[at:]...)...)
+	e.area.endRow += count
+	for len(e.before) < len(e.after) {
+		e.before = append(e.before, blankRow(e.width()))
+	}
+}
+
+// DeleteRows removes the sheet rows numbered rows, moving the rows below up
+// and leaving the last rows of the block empty.
+func (e *Edit) DeleteRows(rows []int) {
+	if e == nil {
+		return
+	}
+	deleted := map[int]bool{}
+	for _, row := range rows {
+		deleted[row-1-e.area.startRow] = true
+	}
+	var kept [][]interface{}
+	for i, values := range e.after {
+		if !deleted[i] {
+			kept = append(kept, values)
+		}
+	}
+	for len(kept) < len(e.after) {
+		kept = append(kept, blankRow(e.width()))
+	}
+	e.after = kept
+}
+
+// Done notes the edit on the recording of its sheet. Helpers call it once
+// their writes have succeeded.
+func (e *Edit) Done() {
+	if e == nil || e.area.endRow < e.area.startRow {
+		return
+	}
+	e.rec.parts = append(e.rec.parts, part{area: e.area, before: e.before, after: e.after})
+}
+
+// set writes v to a sheet cell, growing the block if the cell lies outside
+// it. Cells added that way were empty before.
+func (e *Edit) set(row int, col int, v interface{}) {
+	if row < e.area.startRow || col < e.area.startCol {
+		return
+	}
+	for e.area.endRow < row {
+		e.before = append(e.before, blankRow(e.width()))
+		e.after = append(e.after, blankRow(e.width()))
+		e.area.endRow++
+	}
+	for e.area.endCol < col {
+		for i := range e.before {
+			e.before[i] = append(e.before[i], "")
+			e.after[i] = append(e.after[i], "")
+		}
+		e.area.endCol++
+	}
+	e.after[row-e.area.startRow][col-e.area.startCol] = v
+}
+
+func (e *Edit) width() int {
+	return e.area.endCol - e.area.startCol + 1
+}
+
+// Appended notes rows appended to the range Google reports as updated, such
+// as 'Sheet1'!A5:C6. The cells there were empty before.
+func Appended(spreadsheetID string, updatedRange string, rows [][]interface{}) {
+	sheetName, a, err := parseArea(updatedRange)
+	if err != nil {
+		return
+	}
+	rec := recording(spreadsheetID, sheetName)
+	if rec == nil {
+		return
+	}
+	block := area{endRow: a.endRow - a.startRow, endCol: a.endCol - a.startCol}
+	rec.parts = append(rec.parts, part{area: a, before: extract(nil, block), after: extract(rows, block)})
+}
+
+// parseWriteRange parses the range of a write such as Sheet1!A2:C2, Sheet1!B3
+// or Sheet1!A2:C. The end row is -1 when the range has none.
+func parseWriteRange(ref string) (area, bool) {
+	if idx := strings.LastIndex(ref, "!"); idx >= 0 {
+		ref = ref[idx+1:]
+	}
+	return parseCells(ref)
+}
+
+// union returns the area covering a and b. An area with a negative start row
+// is empty.
+func union(a area, b area) area {
+	if a.startRow < 0 {
+		return b
+	}
+	return area{
+		startRow: min(a.startRow, b.startRow),
+		startCol: min(a.startCol, b.startCol),
+		endRow:   max(a.endRow, b.endRow),
+		endCol:   max(a.endCol, b.endCol),
+	}
+}
+
+func blankRow(width int) []interface{} {
+	row := make([]interface{}, width)
+	for i := range row {
+		row[i] = ""
+	}
+	return row
+}
+
+// merge combines the parts noted by one request into one change covering
+// every cell that differs. A cell keeps its value from before the first part
+// touching it and after the last.
+func merge(sheetName string, parts []part) *Change {
+	if len(parts) == 0 {
+		return nil
+	}
+	box := area{startRow: -1}
+	for _, p := range parts {
+		box = union(box, p.area)
+	}
+
+	rows, cols := box.endRow-box.startRow+1, box.endCol-box.startCol+1
+	before, after := make([][]interface{}, rows), make([][]interface{}, rows)
+	touched := make([][]bool, rows)
+	for i := range before {
+		before[i], after[i], touched[i] = make([]interface{}, cols), make([]interface{}, cols), make([]bool, cols)
+	}
+	for _, p := range parts {
+		for i := p.area.startRow; i <= p.area.endRow; i++ {
+			for j := p.area.startCol; j <= p.area.endCol; j++ {
+				bi, bj, pi, pj := i-box.startRow, j-box.startCol, i-p.area.startRow, j-p.area.startCol
+				if !touched[bi][bj] {
+					before[bi][bj] = cell(p.before, pi, pj)
+					touched[bi][bj] = true
+				}
+				after[bi][bj] = cell(p.after, pi, pj)
+			}
+		}
+	}
+
+	changed := area{startRow: -1, startCol: -1, endRow: -1, endCol: -1}
+	for i := range before {
+		for j := range before[i] {
+			if !touched[i][j] || before[i][j] == after[i][j] {
+				continue
+			}
+			if changed.startRow < 0 {
+				changed.startRow = i
+			}
+			changed.endRow = i
+			if changed.startCol < 0 || j < changed.startCol {
+				changed.startCol = j
+			}
+			changed.endCol = max(changed.endCol, j)
+		}
+	}
+	if changed.startRow < 0 {
+		return nil
+	}
+
+	change := &Change{SheetName: sheetName}
+	for i := changed.startRow; i <= changed.endRow; i++ {
+		change.Before = append(change.Before, before[i][changed.startCol:changed.endCol+1])
+		change.After = append(change.After, after[i][changed.startCol:changed.endCol+1])
+	}
+	changed.startRow += box.startRow
+	changed.endRow += box.startRow
+	changed.startCol += box.startCol
+	changed.endCol += box.startCol
+	change.Range = sheetRange(sheetName, changed)
+	return change
+}
@@ -0,0 +1,170 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"personnel-api/pkg/keylock"
+)
+
+// Store holds the change log and appends every change as one JSON line to
+// its file, if it has one.
+type Store struct {
+	path    string
+	mu      sync.RWMutex
+	changes []*Change
+	nextID  int64
+
+	locks        keylock.Locks
+	recordingsMu sync.Mutex
+	recordings   map[string]*Recording
+}
+
+// NewStore loads the changes saved at path. A missing file is an empty log
+// and an empty path keeps the changes in memory only.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, nextID: 1}
+	if path == "" {
+		return s, nil
+	}
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read history file: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var change Change
+		if err := json.Unmarshal(scanner.Bytes(), &change); err != nil {
+			return nil, fmt.Errorf("%s:%d: unable to parse change: %v", path, line, err)
+		}
+		s.changes = append(s.changes, &change)
+		if change.ID >= s.nextID {
+			s.nextID = change.ID + 1
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("unable to read history file: %v", err)
+	}
+	return s, nil
+}
+
+// NewStoreFromEnv loads the file named by HISTORY_FILE, history.jsonl by
+// default.
+func NewStoreFromEnv() (*Store, error) {
+	path := os.Getenv("HISTORY_FILE")
+	if path == "" {
+		path = "history.jsonl"
+	}
+	return NewStore(path)
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultStore = &Store{nextID: 1}
+)
+
+// SetDefault replaces the store returned by Default.
+func SetDefault(s *Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = s
+}
+
+// Default returns the store used by the middleware and handlers. It keeps
+// changes in memory until SetDefault is called.
+func Default() *Store {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultStore
+}
+
+// Add assigns the next ID and a timestamp to change and saves it.
+func (s *Store) Add(change *Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	change.ID = s.nextID
+	if change.Time.IsZero() {
+		change.Time = time.Now().UTC()
+	}
+
+	if s.path != "" {
+		data, err := json.Marshal(change)
+		if err != nil {
+			return err
+		}
+		file, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return fmt.Errorf("unable to save change: %v", err)
+		}
+		if _, err := file.Write(append(data, '\n')); err != nil {
+			file.Close()
+			return fmt.Errorf("unable to save change: %v", err)
+		}
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("unable to save change: %v", err)
+		}
+	}
+
+	s.changes = append(s.changes, change)
+	s.nextID++
+	return nil
+}
+
+// Get returns the change with the given ID or nil.
+func (s *Store) Get(id int64) *Change {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, change := range s.changes {
+		if change.ID == id {
+			return change
+		}
+	}
+	return nil
+}
+
+// List returns the changes of a sheet made after since, newest first. An
+// empty sheetName lists every sheet of the spreadsheet.
+func (s *Store) List(spreadsheetID string, sheetName string, since time.Time) []*Change {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []*Change{}
+	for i := len(s.changes) - 1; i >= 0; i-- {
+		change := s.changes[i]
+		if change.SpreadsheetID != spreadsheetID || !change.Time.After(since) {
+			continue
+		}
+		if sheetName != "" && !strings.EqualFold(change.SheetName, sheetName) {
+			continue
+		}
+		list = append(list, change)
+	}
+	return list
+}
+
+// Lock serialises writes to one sheet through this store, so the values a
+// write reads before changing them are not mixed with those of a concurrent
+// write. It returns the function that releases the lock.
+func (s *Store) Lock(spreadsheetID string, sheetName string) func() {
+	return s.locks.Lock(sheetKey(spreadsheetID, sheetName))
+}
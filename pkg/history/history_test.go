@@ -0,0 +1,347 @@
+package history
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"personnel-api/pkg/authorization"
+	"personnel-api/pkg/svc"
+	"personnel-api/pkg/svc/svctest"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"google.golang.org/api/sheets/v4"
+)
+
+func TestDiff(t *testing.T) {
+	before := [][]interface{}{
+		{"ID", "Name", "Email"},
+		{"1", "test1", "test1@gmail.com"},
+		{"2", "test2", "test2@gmail.com"},
+		{"3", "test3", "test3@gmail.com"},
+	}
+
+	if change := Diff("Sheet1", before, before); change != nil {
+		t.Errorf("Expected no change but got %+v", change)
+	}
+
+	updated := [][]interface{}{
+		{"ID", "Name", "Email"},
+		{"1", "renamed", "test1@gmail.com"},
+		{"2", "test2", "new@gmail.com"},
+		{"3", "test3", "test3@gmail.com"},
+	}
+	change := Diff("Sheet1", before, updated)
+	if change.Range != "'Sheet1'!B2:C3" {
+		t.Errorf("Expected range 'Sheet1'!B2:C3 but got %s", change.Range)
+	}
+	if !reflect.DeepEqual(change.Before, [][]interface{}{{"test1", "test1@gmail.com"}, {"test2", "test2@gmail.com"}}) {
+		t.Errorf("Unexpected before values %v", change.Before)
+	}
+
+	// deleting a row shifts the rows below it up and leaves the last row empty
+	deleted := [][]interface{}{before[0], before[1], before[3]}
+	change = Diff("Sheet1", before, deleted)
+	if change.Range != "'Sheet1'!A3:C4" {
+		t.Errorf("Expected range 'Sheet1'!A3:C4 but got %s", change.Range)
+	}
+	if !reflect.DeepEqual(change.After, [][]interface{}{{"3", "test3", "test3@gmail.com"}, {"", "", ""}}) {
+		t.Errorf("Unexpected after values %v", change.After)
+	}
+
+	// sheet names are quoted so they can be parsed and written back
+	change = Diff("Q1 'final'!", before, updated)
+	if change.Range != "'Q1 ''final''!'!B2:C3" {
+		t.Errorf("Expected a quoted range but got %s", change.Range)
+	}
+	if sheetName, a, err := parseArea(change.Range); err != nil || sheetName != "Q1 'final'!" || a.String() != "B2:C3" {
+		t.Errorf("Expected the range to parse back but got %q, %v, %v", sheetName, a, err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.jsonl")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore returned error: %v", err)
+	}
+
+	add := func(sheetName string, principal string, after string) *Change {
+		change := &Change{SpreadsheetID: "ss", SheetName: sheetName, Range: "'" + sheetName + "'!A1:A1", Operation: "UpdateDataCell", Principal: principal, Before: [][]interface{}{{"a"}}, After: [][]interface{}{{after}}}
+		if err := store.Add(change); err != nil {
+			t.Fatalf("Add returned error: %v", err)
+		}
+		return change
+	}
+	add("Sheet1", "alice", "b")
+	add("Sheet2", "bob", "c")
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore returned error: %v", err)
+	}
+	changes := reloaded.List("ss", "", time.Time{})
+	if len(changes) != 2 || changes[0].ID != 2 || changes[0].Principal != "bob" {
+		t.Fatalf("Expected the saved changes newest first but got %+v", changes)
+	}
+	if len(reloaded.List("ss", "sheet1", time.Time{})) != 1 || len(reloaded.List("other", "", time.Time{})) != 0 {
+		t.Errorf("Expected List to filter by spreadsheet and sheet")
+	}
+
+	change := &Change{SpreadsheetID: "ss", SheetName: "Sheet1", Range: "'Sheet1'!A1:A1", Before: [][]interface{}{{"b"}}, After: [][]interface{}{{"d"}}}
+	reloaded.Add(change)
+	if change.ID != 3 {
+		t.Errorf("Expected IDs to continue after a reload but got %d", change.ID)
+	}
+}
+
+func TestRevertAndRestore(t *testing.T) {
+	setEnforcer(t, []interface{}{"alice", "/*", "*", "*", "*"})
+	svc.SetBackend(svctest.NewBackend())
+	previous := Default()
+	store, _ := NewStore("")
+	SetDefault(store)
+	defer SetDefault(previous)
+
+	write := func(cell string, value string) *Change {
+		rec := store.Begin(svctest.SpreadsheetID, "Sheet1")
+		defer rec.End()
+		edit := NewEdit(svctest.SpreadsheetID, "Sheet1", "Sheet1!"+cell)
+		svc.GetBackend().UpdateValues(svctest.SpreadsheetID, "Sheet1!"+cell, &sheets.ValueRange{Values: [][]interface{}{{value}}})
+		edit.Write("Sheet1!"+cell, [][]interface{}{{value}})
+		edit.Done()
+		change, err := rec.Commit("UpdateDataCell", "alice")
+		if err != nil {
+			t.Fatalf("Commit returned error: %v", err)
+		}
+		return change
+	}
+	cellValue := func(cell string) interface{} {
+		values, _ := svc.GetBackend().GetValues(svctest.SpreadsheetID, "Sheet1!"+cell)
+		if len(values.Values) == 0 || len(values.Values[0]) == 0 {
+			return ""
+		}
+		return values.Values[0][0]
+	}
+	post := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		handler(res, newRequest(http.MethodPost, "/RestoreRange", "alice", body))
+		return res
+	}
+
+	start := time.Now().UTC()
+	first := write("B2", "first")
+	write("B2", "second")
+	write("C3", "third")
+
+	// B2 no longer holds what the first change wrote
+	res := post(RevertChange, `{"id": 1}`)
+	if res.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d but got %d", http.StatusConflict, res.Code)
+	}
+
+	res = post(RevertChange, `{"id": 3}`)
+	if res.Code != http.StatusOK || cellValue("C3") != "test2@gmail.com" {
+		t.Fatalf("Expected C3 to be reverted but got %d %s", res.Code, res.Body.String())
+	}
+	var reverted struct {
+		Change *Change `json:"change"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &reverted)
+	if reverted.Change == nil || reverted.Change.RevertOf != 3 || reverted.Change.Operation != "RevertChange" {
+		t.Errorf("Expected the revert to be logged but got %+v", reverted.Change)
+	}
+
+	res = post(RevertChange, `{"id": 1, "force": true}`)
+	if res.Code != http.StatusOK || cellValue("B2") != first.Before[0][0] {
+		t.Errorf("Expected a forced revert to restore B2 but got %d %v", res.Code, cellValue("B2"))
+	}
+
+	write("A5", "")
+	res = post(RestoreRange, `{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1", "time": "`+start.Format(time.RFC3339Nano)+`"}`)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	values, _ := Snapshot(svctest.SpreadsheetID, "Sheet1")
+	if !reflect.DeepEqual(values, svctestValues()) {
+		t.Errorf("Expected the sheet as it was at the start but got %v", values)
+	}
+
+	res = post(RevertChange, `{"id": 99}`)
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res.Code)
+	}
+}
+
+func TestRecording(t *testing.T) {
+	setEnforcer(t, []interface{}{"alice", "/*", "*", "*", "*"})
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+	previous := Default()
+	store, _ := NewStore("")
+	SetDefault(store)
+	defer SetDefault(previous)
+
+	// edits made while the sheet is not being recorded are ignored
+	if edit := NewEdit(svctest.SpreadsheetID, "Sheet1", "Sheet1!B2"); edit != nil {
+		t.Errorf("Expected no edit outside a recording but got %+v", edit)
+	}
+
+	record := func(operation string, write func()) *Change {
+		rec := store.Begin(svctest.SpreadsheetID, "Sheet1")
+		defer rec.End()
+		write()
+		change, err := rec.Commit(operation, "alice")
+		if err != nil {
+			t.Fatalf("Commit returned error: %v", err)
+		}
+		return change
+	}
+	update := func(a1 string, values [][]interface{}) {
+		edit := NewEdit(svctest.SpreadsheetID, "Sheet1", a1)
+		backend.UpdateValues(svctest.SpreadsheetID, a1, &sheets.ValueRange{Values: values})
+		edit.Write(a1, values)
+		edit.Done()
+	}
+
+	// two writes of one request make one change, and the cells between them
+	// that neither touched are null
+	change := record("UpdateDataRecords", func() {
+		update("Sheet1!B2", [][]interface{}{{"renamed"}})
+		update("Sheet1!C4", [][]interface{}{{"new@gmail.com"}})
+	})
+	if change.Range != "'Sheet1'!B2:C4" {
+		t.Errorf("Expected range 'Sheet1'!B2:C4 but got %s", change.Range)
+	}
+	if !reflect.DeepEqual(change.Before, [][]interface{}{{"test1", nil}, {nil, nil}, {nil, "test3@gmail.com"}}) {
+		t.Errorf("Unexpected before values %v", change.Before)
+	}
+	if !reflect.DeepEqual(change.After, [][]interface{}{{"renamed", nil}, {nil, nil}, {nil, "new@gmail.com"}}) {
+		t.Errorf("Unexpected after values %v", change.After)
+	}
+
+	// writing a value a cell already holds is not a change
+	if change := record("UpdateDataCell", func() { update("Sheet1!A2", [][]interface{}{{"1"}}) }); change != nil {
+		t.Errorf("Expected no change but got %+v", change)
+	}
+
+	// appended cells were empty before, and need no read
+	change = record("CreateData", func() {
+		rows := [][]interface{}{{"5", "test5", "test5@gmail.com"}}
+		resp, _ := backend.AppendValues(svctest.SpreadsheetID, "Sheet1", &sheets.ValueRange{Values: rows})
+		Appended(svctest.SpreadsheetID, resp.Updates.UpdatedRange, rows)
+	})
+	if change.Range != "'Sheet1'!A6:C6" || !reflect.DeepEqual(change.Before, [][]interface{}{{"", "", ""}}) {
+		t.Errorf("Expected the appended row to be logged but got %+v", change)
+	}
+
+	// deleting a row moves the rows below it up
+	change = record("DeleteRows", func() {
+		edit := NewRowsEdit(svctest.SpreadsheetID, "Sheet1", 5)
+		backend.BatchUpdate(svctest.SpreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{{
+			DeleteDimension: &sheets.DeleteDimensionRequest{Range: &sheets.DimensionRange{SheetId: 0, Dimension: "ROWS", StartIndex: 4, EndIndex: 5}},
+		}}})
+		edit.DeleteRows([]int{5})
+		edit.Done()
+	})
+	if change.Range != "'Sheet1'!A5:C6" || !reflect.DeepEqual(change.After, [][]interface{}{{"5", "test5", "test5@gmail.com"}, {"", "", ""}}) {
+		t.Errorf("Expected the moved rows to be logged but got %+v", change)
+	}
+
+	// reverting the first change leaves the cells it did not touch alone
+	update("Sheet1!C3", [][]interface{}{{"kept"}})
+	res := httptest.NewRecorder()
+	RevertChange(res, newRequest(http.MethodPost, "/RevertChange", "alice", `{"id": 1}`))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	values, _ := Snapshot(svctest.SpreadsheetID, "Sheet1")
+	if values[1][1] != "test1" || values[2][2] != "kept" || values[3][2] != "test3@gmail.com" {
+		t.Errorf("Expected only B2 and C4 to be reverted but got %v", values)
+	}
+}
+
+func TestRevertChangeAuthorization(t *testing.T) {
+	svc.SetBackend(svctest.NewBackend())
+	previous := Default()
+	store, _ := NewStore("")
+	SetDefault(store)
+	defer SetDefault(previous)
+	setEnforcer(t, []interface{}{"bob", "/RevertChange", "POST", svctest.SpreadsheetID, "Sheet1"})
+
+	for _, sheetName := range []string{"Sheet1", "Sheet2"} {
+		store.Add(&Change{SpreadsheetID: svctest.SpreadsheetID, SheetName: sheetName, Range: "'" + sheetName + "'!A1:A1", Before: [][]interface{}{{"old"}}, After: [][]interface{}{{nil}}})
+	}
+
+	cases := []struct {
+		body   string
+		status int
+	}{
+		// bob may revert on Sheet1 only, whatever target he names
+		{`{"id": 2}`, http.StatusForbidden},
+		{`{"id": 2, "spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1"}`, http.StatusBadRequest},
+		{`{"id": 1, "sheetName": "Sheet2"}`, http.StatusBadRequest},
+		{`{"id": 1, "spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "sheet1"}`, http.StatusOK},
+	}
+	for _, c := range cases {
+		res := httptest.NewRecorder()
+		RevertChange(res, newRequest(http.MethodPost, "/RevertChange", "bob", c.body))
+		if res.Code != c.status {
+			t.Errorf("Body %s: expected status code %d but got %d: %s", c.body, c.status, res.Code, res.Body.String())
+		}
+	}
+
+	values, _ := Snapshot(svctest.SpreadsheetID, "Sheet2")
+	if len(values) > 0 && len(values[0]) > 0 && values[0][0] == "old" {
+		t.Errorf("Expected the change on Sheet2 not to be reverted")
+	}
+}
+
+// setEnforcer installs an enforcer holding the given policies for the test.
+func setEnforcer(t *testing.T, policies ...[]interface{}) {
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act, spreadsheet, sheet
+
+[policy_definition]
+p = sub, obj, act, spreadsheet, sheet
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && keyMatch(r.obj, p.obj) && (p.act == "*" || r.act == p.act) && keyMatch(r.spreadsheet, p.spreadsheet) && keyMatch(r.sheet, p.sheet)
+`)
+	if err != nil {
+		t.Fatalf("Failed to load model: %v", err)
+	}
+	enforcer, err := casbin.NewSyncedEnforcer(m)
+	if err != nil {
+		t.Fatalf("Failed to create enforcer: %v", err)
+	}
+	for _, policy := range policies {
+		enforcer.AddPolicy(policy...)
+	}
+	authorization.SetEnforcer(enforcer)
+	t.Cleanup(func() { authorization.SetEnforcer(nil) })
+}
+
+// newRequest returns a request made by principal, as Authorize passes it on.
+func newRequest(method string, target string, principal string, body string) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader([]byte(body)))
+	return req.WithContext(authorization.WithPrincipal(req.Context(), principal))
+}
+
+func svctestValues() [][]interface{} {
+	values, _ := svctest.NewBackend().GetValues(svctest.SpreadsheetID, "Sheet1")
+	return values.Values
+}
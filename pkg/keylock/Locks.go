@@ -0,0 +1,52 @@
+// Package keylock provides mutexes named by a key, such as one per sheet, that
+// exist only while they are held or waited for.
+package keylock
+
+import "sync"
+
+// Locks holds one mutex per key in use. The zero value is ready to use.
+type Locks struct {
+	mu    sync.Mutex
+	locks map[string]*entry
+}
+
+type entry struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Lock locks key and returns the function that unlocks it. The mutex of a
+// key is removed once nobody holds or waits for it, so the set of locks does
+// not grow with every key ever used.
+func (l *Locks) Lock(key string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*entry{}
+	}
+	e, ok := l.locks[key]
+	if !ok {
+		e = &entry{}
+		l.locks[key] = e
+	}
+	e.refs++
+	l.mu.Unlock()
+
+	e.mu.Lock()
+	return func() {
+		e.mu.Unlock()
+
+		l.mu.Lock()
+		e.refs--
+		if e.refs == 0 {
+			delete(l.locks, key)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// Len returns the number of keys locked or waited for.
+func (l *Locks) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.locks)
+}
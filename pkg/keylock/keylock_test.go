@@ -0,0 +1,45 @@
+package keylock
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLocks(t *testing.T) {
+	var locks Locks
+
+	unlock := locks.Lock("a")
+	locked, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		unlock := locks.Lock("a")
+		close(locked)
+		unlock()
+		close(done)
+	}()
+
+	// other keys are not blocked
+	locks.Lock("b")()
+
+	select {
+	case <-locked:
+		t.Fatal("Expected the second Lock of a key to wait")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unlock()
+	<-done
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			locks.Lock("c")()
+		}()
+	}
+	wg.Wait()
+
+	if n := locks.Len(); n != 0 {
+		t.Errorf("Expected every lock to be removed once released but %d remain", n)
+	}
+}
@@ -0,0 +1,137 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"personnel-api/pkg/audit"
+	"personnel-api/pkg/authorization"
+	"personnel-api/pkg/router"
+	"personnel-api/pkg/svc"
+	"personnel-api/pkg/svc/svctest"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+func TestAudit(t *testing.T) {
+	svc.SetBackend(svctest.NewBackend())
+	previous := audit.Default()
+	log := audit.NewLog(filepath.Join(t.TempDir(), "audit.jsonl"), 0, 1)
+	audit.SetDefault(log)
+	defer audit.SetDefault(previous)
+
+	keyFile := filepath.Join(t.TempDir(), "api_keys.csv")
+	os.WriteFile(keyFile, []byte("alice-secret, alice\n"), 0o600)
+	keys, err := authorization.NewFileKeyStore(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+
+	handler := Audit(keys)(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-API-Key") == "" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		audit.SetRange(r.Context(), "Sheet1!B3:B3")
+	})
+
+	body := `{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1"}`
+	req := httptest.NewRequest(http.MethodPut, "/UpdateDataCell", strings.NewReader(body))
+	req.Header.Set("X-API-Key", "alice-secret")
+	handler(httptest.NewRecorder(), req)
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodDelete, "/DeleteSpreadsheet", strings.NewReader(body)))
+
+	events, err := log.Query(audit.Filter{})
+	if err != nil || len(events) != 2 {
+		t.Fatalf("Expected 2 events but got %v, %v", events, err)
+	}
+
+	refused, written := events[0], events[1]
+	if refused.Route != "/DeleteSpreadsheet" {
+		refused, written = written, refused
+	}
+	if written.Principal != "alice" || written.SheetName != "Sheet1" || written.Range != "Sheet1!B3:B3" || written.Status != http.StatusOK {
+		t.Errorf("Unexpected event %+v", written)
+	}
+	if refused.Principal != "" || refused.SpreadsheetID != svctest.SpreadsheetID || refused.Status != http.StatusUnauthorized {
+		t.Errorf("Unexpected event %+v", refused)
+	}
+}
+
+// countingBackend counts spreadsheet lookups.
+type countingBackend struct {
+	*svc.MemoryBackend
+	lookups int
+}
+
+func (c *countingBackend) GetSpreadsheet(spreadsheetID string) (*sheets.Spreadsheet, error) {
+	c.lookups++
+	return c.MemoryBackend.GetSpreadsheet(spreadsheetID)
+}
+
+func TestAuditAuthorize(t *testing.T) {
+	backend := &countingBackend{MemoryBackend: svctest.NewBackend()}
+	svc.SetBackend(backend)
+	previous := audit.Default()
+	log := audit.NewLog(filepath.Join(t.TempDir(), "audit.jsonl"), 0, 1)
+	audit.SetDefault(log)
+	defer audit.SetDefault(previous)
+
+	keyFile := filepath.Join(t.TempDir(), "api_keys.csv")
+	os.WriteFile(keyFile, []byte("bob-secret, bob\n"), 0o600)
+	keys, err := authorization.NewFileKeyStore(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load keys: %v", err)
+	}
+
+	authorize := newTestAuthorize(t)
+	handler := Audit(keys)(authorize(func(w http.ResponseWriter, r *http.Request) {}))
+
+	body := `{"spreadsheetID": "` + svctest.SpreadsheetID + `", "sheetID": 123456}`
+	req := httptest.NewRequest(http.MethodDelete, "/DeleteSheet", strings.NewReader(body))
+	handler(httptest.NewRecorder(), req)
+	if backend.lookups != 0 {
+		t.Errorf("Expected no backend lookup for an unauthenticated call but got %d", backend.lookups)
+	}
+
+	req = httptest.NewRequest(http.MethodDelete, "/DeleteSheet", strings.NewReader(body))
+	req.Header.Set("X-API-Key", "bob-secret")
+	handler(httptest.NewRecorder(), req)
+
+	large := `{"spreadsheetID": "` + svctest.SpreadsheetID + `", "sheetName": "` + string(bytes.Repeat([]byte("x"), MaxBodyBytes)) + `"}`
+	req = httptest.NewRequest(http.MethodPut, "/UpdateDataRow", strings.NewReader(large))
+	req.Header.Set("X-API-Key", "bob-secret")
+	res := httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code %d for a large body but got %d", http.StatusRequestEntityTooLarge, res.Code)
+	}
+
+	// calls matching no route of the router are audited as well
+	v1 := router.New()
+	v1.HandleFunc(http.MethodGet, "/v1/spreadsheets", authorize(func(w http.ResponseWriter, r *http.Request) {}))
+	Audit(keys)(v1.ServeHTTP)(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v1/nope", nil))
+
+	events, err := log.Query(audit.Filter{})
+	if err != nil || len(events) != 4 {
+		t.Fatalf("Expected 4 events but got %v, %v", events, err)
+	}
+	statuses := map[int]*audit.Event{}
+	for _, event := range events {
+		statuses[event.Status] = event
+	}
+	if event := statuses[http.StatusUnauthorized]; event.SpreadsheetID != svctest.SpreadsheetID || event.SheetName != "" {
+		t.Errorf("Expected the unauthenticated call to be logged with its spreadsheet only but got %+v", event)
+	}
+	if event := statuses[http.StatusOK]; event.Principal != "bob" || event.SheetName != "Extra" {
+		t.Errorf("Expected the authorized call to be logged with the resolved sheet but got %+v", event)
+	}
+	if event := statuses[http.StatusNotFound]; event.Route != "/v1/nope" {
+		t.Errorf("Expected the unmatched call to be logged but got %+v", event)
+	}
+}
@@ -1,30 +1,84 @@
 package middleware
 
 import (
+	"errors"
 	"net/http"
+	"strings"
+
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/audit"
+	"personnel-api/pkg/authorization"
 
 	"github.com/casbin/casbin/v2"
 )
 
-func Authorize(enforcer *casbin.Enforcer) func(http.HandlerFunc) http.HandlerFunc {
+// Authorize resolves the caller's API key to a principal and asks Casbin
+// whether that principal may call the route on the targeted spreadsheet and
+// sheet. Missing or unknown keys get 401, known principals without a matching
+// policy get 403. Requests whose query string and body name different
+// targets get 400, and a sheetID that names no sheet gets 403. The resolved
+// target is noted on the audit event.
+func Authorize(enforcer *casbin.SyncedEnforcer, keys authorization.KeyStore) func(http.HandlerFunc) http.HandlerFunc {
 	return func(next http.HandlerFunc) http.HandlerFunc {
 		return func(w http.ResponseWriter, r *http.Request) {
 			path := r.URL.Path
 			action := r.Method
-			admin := "admin_key"
 
-			authorized, err := enforcer.Enforce(admin, path, action)
+			key := apiKey(r)
+			if key == "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="personnel-api"`)
+				apierror.Write(w, http.StatusUnauthorized, apierror.CodeUnauthenticated, "Missing API key")
+				return
+			}
+
+			principal, ok := keys.Lookup(key)
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="personnel-api", error="invalid_token"`)
+				apierror.Write(w, http.StatusUnauthorized, apierror.CodeUnauthenticated, "Invalid API key")
+				return
+			}
+
+			spreadsheetID, sheetName, err := requestTarget(r)
+			if errors.Is(err, errBodyTooLarge) {
+				apierror.Write(w, http.StatusRequestEntityTooLarge, apierror.CodeInvalidRequest, "Request body is too large")
+				return
+			}
+			if errors.Is(err, errTargetMismatch) {
+				apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "The query string and the body must name the same spreadsheetID, sheetName and sheetID")
+				return
+			}
 			if err != nil {
-				http.Error(w, err.Error(), http.StatusInternalServerError)
+				apierror.Write(w, http.StatusForbidden, apierror.CodePermissionDenied, "Forbidden")
+				return
+			}
+
+			audit.SetTarget(r.Context(), spreadsheetID, sheetName)
+
+			authorized, err := enforcer.Enforce(principal, path, action, spreadsheetID, sheetName)
+			if err != nil {
+				apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, err.Error())
 				return
 			}
 
 			if !authorized {
-				http.Error(w, "Unauthorized", http.StatusForbidden)
+				apierror.Write(w, http.StatusForbidden, apierror.CodePermissionDenied, "Forbidden")
 				return
 			}
 
-			next(w, r)
+			next(w, r.WithContext(authorization.WithPrincipal(r.Context(), principal)))
 		}
 	}
 }
+
+// apiKey reads the key from the X-API-Key header or a bearer token.
+func apiKey(r *http.Request) string {
+	if key := strings.TrimSpace(r.Header.Get("X-API-Key")); key != "" {
+		return key
+	}
+
+	auth := strings.TrimSpace(r.Header.Get("Authorization"))
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
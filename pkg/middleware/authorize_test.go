@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"personnel-api/pkg/authorization"
+	"personnel-api/pkg/svc"
+	"personnel-api/pkg/svc/svctest"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+)
+
+const testModel = `
+[request_definition]
+r = sub, obj, act, spreadsheet, sheet
+
+[policy_definition]
+p = sub, obj, act, spreadsheet, sheet
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && keyMatch(r.obj, p.obj) && (p.act == "*" || r.act == p.act) && keyMatch(r.spreadsheet, p.spreadsheet) && keyMatch(r.sheet, p.sheet)
+`
+
+func newTestAuthorize(t *testing.T) func(http.HandlerFunc) http.HandlerFunc {
+	m, err := model.NewModelFromString(testModel)
+	if err != nil {
+		t.Fatalf("Failed to load model: %v", err)
+	}
+	enforcer, err := casbin.NewSyncedEnforcer(m)
+	if err != nil {
+		t.Fatalf("Failed to create enforcer: %v", err)
+	}
+	enforcer.AddPolicy("admin", "/GetAll", "GET", "*", "*")
+	enforcer.AddGroupingPolicy("alice", "admin")
+	enforcer.AddPolicy("hr_team", "/UpdateDataRow", "PUT", svctest.SpreadsheetID, "Sheet1")
+	enforcer.AddPolicy("hr_team", "/GetSheetData", "GET", svctest.SpreadsheetID, "*")
+	enforcer.AddPolicy("hr_team", "/DeleteSheet", "DELETE", svctest.SpreadsheetID, "Extra")
+	enforcer.AddGroupingPolicy("bob", "hr_team")
+
+	keyFile := filepath.Join(t.TempDir(), "api_keys.csv")
+	content := "# test keys\nadmin-secret, admin\nsha256:" + hashKeyForTest("alice-secret") + ", alice\nbob-secret, bob\n"
+	if err := os.WriteFile(keyFile, []byte(content), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+	keys, err := authorization.NewFileKeyStore(keyFile)
+	if err != nil {
+		t.Fatalf("Failed to load key store: %v", err)
+	}
+
+	return Authorize(enforcer, keys)
+}
+
+func hashKeyForTest(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestAuthorize(t *testing.T) {
+	authorize := newTestAuthorize(t)
+
+	var principal string
+	handler := authorize(func(w http.ResponseWriter, r *http.Request) {
+		principal = authorization.PrincipalFromContext(r.Context())
+	})
+
+	cases := []struct {
+		name      string
+		method    string
+		header    string
+		value     string
+		status    int
+		principal string
+	}{
+		{"missing key", http.MethodGet, "", "", http.StatusUnauthorized, ""},
+		{"unknown key", http.MethodGet, "X-API-Key", "nope", http.StatusUnauthorized, ""},
+		{"api key header", http.MethodGet, "X-API-Key", "admin-secret", http.StatusOK, "admin"},
+		{"bearer token", http.MethodGet, "Authorization", "Bearer admin-secret", http.StatusOK, "admin"},
+		{"hashed key with role", http.MethodGet, "X-API-Key", "alice-secret", http.StatusOK, "alice"},
+		{"known key without policy", http.MethodGet, "X-API-Key", "bob-secret", http.StatusForbidden, ""},
+		{"wrong method", http.MethodPost, "X-API-Key", "admin-secret", http.StatusForbidden, ""},
+	}
+
+	for _, c := range cases {
+		principal = ""
+		req := httptest.NewRequest(c.method, "/GetAll", nil)
+		if c.header != "" {
+			req.Header.Set(c.header, c.value)
+		}
+		res := httptest.NewRecorder()
+
+		handler(res, req)
+
+		if res.Code != c.status {
+			t.Errorf("%s: expected status code %d but got %d", c.name, c.status, res.Code)
+		}
+		if principal != c.principal {
+			t.Errorf("%s: expected principal %q but got %q", c.name, c.principal, principal)
+		}
+		if c.status == http.StatusUnauthorized && !strings.Contains(res.Body.String(), `"code":"UNAUTHENTICATED"`) {
+			t.Errorf("%s: expected a JSON error body but got %s", c.name, res.Body.String())
+		}
+		if c.status == http.StatusForbidden && !strings.Contains(res.Body.String(), `"code":"PERMISSION_DENIED"`) {
+			t.Errorf("%s: expected a JSON error body but got %s", c.name, res.Body.String())
+		}
+	}
+}
+
+func TestAuthorizeScoped(t *testing.T) {
+	svc.SetBackend(svctest.NewBackend())
+	authorize := newTestAuthorize(t)
+
+	var body string
+	handler := authorize(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		body = string(data)
+	})
+
+	cases := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		status int
+	}{
+		{"allowed sheet in body", http.MethodPut, "/UpdateDataRow", `{"spreadsheetID": "` + svctest.SpreadsheetID + `", "sheetName": "Sheet1", "row": 2}`, http.StatusOK},
+		{"other sheet in body", http.MethodPut, "/UpdateDataRow", `{"spreadsheetID": "` + svctest.SpreadsheetID + `", "sheetName": "Sheet2", "row": 2}`, http.StatusForbidden},
+		{"other spreadsheet in body", http.MethodPut, "/UpdateDataRow", `{"spreadsheetID": "` + svctest.SecondSpreadsheetID + `", "sheetName": "Sheet1", "row": 2}`, http.StatusForbidden},
+		{"any sheet in query", http.MethodGet, "/GetSheetData?spreadsheetID=" + svctest.SpreadsheetID + "&sheetName=Sheet2", "", http.StatusOK},
+		{"other spreadsheet in query", http.MethodGet, "/GetSheetData?spreadsheetID=" + svctest.SecondSpreadsheetID + "&sheetName=Sheet1", "", http.StatusForbidden},
+		{"sheet id resolved to name", http.MethodDelete, "/DeleteSheet", `{"spreadsheetID": "` + svctest.SpreadsheetID + `", "sheetID": 123456}`, http.StatusOK},
+		{"other sheet id", http.MethodDelete, "/DeleteSheet", `{"spreadsheetID": "` + svctest.SpreadsheetID + `", "sheetID": 1}`, http.StatusForbidden},
+		{"allowed sheet name with other sheet id", http.MethodDelete, "/DeleteSheet", `{"spreadsheetID": "` + svctest.SpreadsheetID + `", "sheetName": "Extra", "sheetID": 1}`, http.StatusBadRequest},
+		{"unknown sheet id", http.MethodDelete, "/DeleteSheet", `{"spreadsheetID": "` + svctest.SpreadsheetID + `", "sheetID": 42}`, http.StatusForbidden},
+		{"allowed sheet in query, other in body", http.MethodPut, "/UpdateDataRow?spreadsheetID=" + svctest.SpreadsheetID + "&sheetName=Sheet1", `{"spreadsheetID": "` + svctest.SpreadsheetID + `", "sheetName": "Sheet2", "row": 2}`, http.StatusBadRequest},
+		{"allowed spreadsheet in query, other in body", http.MethodPut, "/UpdateDataRow?spreadsheetID=" + svctest.SpreadsheetID + "&sheetName=Sheet1", `{"spreadsheetID": "` + svctest.SecondSpreadsheetID + `", "sheetName": "Sheet1", "row": 2}`, http.StatusBadRequest},
+		{"same target in query and body", http.MethodPut, "/UpdateDataRow?spreadsheetID=" + svctest.SpreadsheetID + "&sheetName=Sheet1", `{"spreadsheetID": "` + svctest.SpreadsheetID + `", "sheetName": "Sheet1", "row": 2}`, http.StatusOK},
+	}
+
+	for _, c := range cases {
+		body = ""
+		req := httptest.NewRequest(c.method, c.url, strings.NewReader(c.body))
+		req.Header.Set("X-API-Key", "bob-secret")
+		res := httptest.NewRecorder()
+
+		handler(res, req)
+
+		if res.Code != c.status {
+			t.Errorf("%s: expected status code %d but got %d", c.name, c.status, res.Code)
+		}
+		if c.status == http.StatusOK && body != c.body {
+			t.Errorf("%s: expected handler to receive the original body but got %q", c.name, body)
+		}
+	}
+}
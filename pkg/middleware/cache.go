@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"personnel-api/pkg/cache"
+)
+
+// BypassCache lets a client skip the sheet cache with Cache-Control: no-cache
+// or X-Cache-Bypass: true. The sheet the request targets, or the whole
+// spreadsheet when no sheet is named, is dropped from the cache before the
+// handler runs, so the read goes to the backend and refreshes the cache.
+func BypassCache(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if wantsBypass(r) {
+			spreadsheetID, sheetName, _ := requestTarget(r)
+			switch {
+			case spreadsheetID == "":
+			case sheetName == "":
+				cache.Default().InvalidateSpreadsheet(spreadsheetID)
+			default:
+				cache.Default().Invalidate(spreadsheetID, sheetName)
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+func wantsBypass(r *http.Request) bool {
+	if strings.EqualFold(r.Header.Get("X-Cache-Bypass"), "true") {
+		return true
+	}
+	for _, directive := range strings.Split(r.Header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+		if directive == "no-cache" || directive == "no-store" {
+			return true
+		}
+	}
+	return false
+}
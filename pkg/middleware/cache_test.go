@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"personnel-api/pkg/cache"
+)
+
+func TestBypassCache(t *testing.T) {
+	c := cache.New(time.Minute, 10, 0)
+	cache.SetDefault(c)
+	defer cache.SetDefault(cache.New(0, 0, 0))
+
+	loads := 0
+	handler := BypassCache(func(w http.ResponseWriter, r *http.Request) {
+		c.Fetch("sheet-id", "Sheet1", func() ([][]interface{}, error) {
+			loads++
+			return [][]interface{}{{"ID"}}, nil
+		})
+	})
+
+	cases := []struct {
+		name   string
+		url    string
+		header string
+		value  string
+		loads  int
+	}{
+		{"first read", "/GetSheetData?spreadsheetID=sheet-id&sheetName=Sheet1", "", "", 1},
+		{"cached", "/GetSheetData?spreadsheetID=sheet-id&sheetName=Sheet1", "", "", 1},
+		{"no-cache", "/GetSheetData?spreadsheetID=sheet-id&sheetName=Sheet1", "Cache-Control", "max-age=0, no-cache", 2},
+		{"bypass header", "/GetSheetData?spreadsheetID=sheet-id&sheetName=Sheet1", "X-Cache-Bypass", "true", 3},
+		{"spreadsheet", "/GetAll?spreadsheetID=sheet-id", "Cache-Control", "no-store", 4},
+		{"other spreadsheet", "/GetAll?spreadsheetID=other", "Cache-Control", "no-cache", 4},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodGet, tc.url, nil)
+		if tc.header != "" {
+			req.Header.Set(tc.header, tc.value)
+		}
+		handler(httptest.NewRecorder(), req)
+		if loads != tc.loads {
+			t.Errorf("%s: expected %d loads but got %d", tc.name, tc.loads, loads)
+		}
+	}
+}
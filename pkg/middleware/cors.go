@@ -12,7 +12,10 @@ func EnableCORS(next http.HandlerFunc) http.HandlerFunc {
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 
 		// Allow specific headers
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-API-Key, Cache-Control, X-Cache-Bypass, If-Match, If-None-Match")
+
+		// Let browsers read the ETag and paging headers
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, X-Total-Count, X-Next-Page-Token, Content-Disposition")
 
 		// Allow credentials
 		w.Header().Set("Access-Control-Allow-Credentials", "true")
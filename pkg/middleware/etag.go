@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"net/http"
+
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/cache"
+	"personnel-api/pkg/etag"
+	"personnel-api/pkg/svc"
+)
+
+// IfMatch makes writes sent with an If-Match header conditional. The target
+// sheet is re-read from the backend, bypassing the cache, and each tag listed
+// is recomputed over the rows it names, i.e. the rows of the read it came
+// from. The request is refused with 412 if none still matches; the response
+// then carries the current ETag of the rows named by the first tag, or of the
+// whole sheet for a tag this API did not issue. Edits to rows outside a tag do
+// not fail the write. Requests without If-Match pass through unchanged. Tags
+// are compared strongly, so a weak W/ tag never matches.
+//
+// IfMatch must run inside History, which holds the per-sheet lock for the
+// rest of the request, so no other write through this API can land between
+// the check and the write it guards.
+func IfMatch(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("If-Match")
+		if header == "" {
+			next(w, r)
+			return
+		}
+
+		spreadsheetID, sheetName, _ := requestTarget(r)
+		if spreadsheetID == "" || sheetName == "" {
+			apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "If-Match needs a request naming a spreadsheetID and sheetName")
+			return
+		}
+
+		cache.Default().Invalidate(spreadsheetID, sheetName)
+		current, err := svc.GetBackend().GetValues(spreadsheetID, sheetName)
+		if err != nil {
+			apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read sheet for If-Match")
+			return
+		}
+
+		var latest string
+		for _, candidate := range etag.List(header) {
+			if candidate == "*" {
+				next(w, r)
+				return
+			}
+			spans, ok := etag.Spans(candidate)
+			if !ok {
+				continue
+			}
+			tag := etag.Compute(current.Values, spans...)
+			if tag == candidate {
+				next(w, r)
+				return
+			}
+			if latest == "" {
+				latest = tag
+			}
+		}
+
+		if latest == "" {
+			latest = etag.Compute(current.Values, etag.Rows{First: 1})
+		}
+		w.Header().Set("ETag", latest)
+		apierror.Write(w, http.StatusPreconditionFailed, apierror.CodePreconditionFailed, "Rows have changed since they were read")
+	}
+}
@@ -0,0 +1,156 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"personnel-api/pkg/etag"
+	"personnel-api/pkg/history"
+	"personnel-api/pkg/svc"
+	"personnel-api/pkg/svc/svctest"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+func TestIfMatch(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1")
+	current := etag.Compute(values.Values, etag.Rows{First: 1})
+
+	calls := 0
+	handler := IfMatch(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+	})
+
+	body := `{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1"}`
+	cases := []struct {
+		name    string
+		body    string
+		ifMatch string
+		status  int
+		calls   int
+	}{
+		{"no header", body, "", http.StatusOK, 1},
+		{"current", body, current, http.StatusOK, 2},
+		{"listed", body, `"stale", ` + current, http.StatusOK, 3},
+		{"any", body, "*", http.StatusOK, 4},
+		{"stale", body, `"stale"`, http.StatusPreconditionFailed, 4},
+		{"stale rows", body, `"1-.0123"`, http.StatusPreconditionFailed, 4},
+		{"weak", body, "W/" + current, http.StatusPreconditionFailed, 4},
+		{"no sheet", `{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w"}`, current, http.StatusBadRequest, 4},
+	}
+
+	for _, tc := range cases {
+		req := httptest.NewRequest(http.MethodPut, "/UpdateDataRow", strings.NewReader(tc.body))
+		if tc.ifMatch != "" {
+			req.Header.Set("If-Match", tc.ifMatch)
+		}
+		res := httptest.NewRecorder()
+		handler(res, req)
+
+		if res.Code != tc.status || calls != tc.calls {
+			t.Errorf("%s: expected status %d and %d calls but got %d and %d", tc.name, tc.status, tc.calls, res.Code, calls)
+		}
+		if tc.status == http.StatusPreconditionFailed && res.Header().Get("ETag") != current {
+			t.Errorf("%s: expected the current ETag %s but got %q", tc.name, current, res.Header().Get("ETag"))
+		}
+	}
+
+	// a write by someone else makes the tag stale
+	backend.UpdateValues(svctest.SpreadsheetID, "Sheet1!B2", &sheets.ValueRange{Values: [][]interface{}{{"changed"}}})
+	req := httptest.NewRequest(http.MethodPut, "/UpdateDataRow", strings.NewReader(body))
+	req.Header.Set("If-Match", current)
+	res := httptest.NewRecorder()
+	handler(res, req)
+	if res.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status %d after a concurrent write but got %d", http.StatusPreconditionFailed, res.Code)
+	}
+}
+
+func TestIfMatchRows(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+
+	// the tag of a read of the header and the first two data rows
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1")
+	current := etag.Compute(values.Values, etag.Rows{First: 1, Last: 3})
+
+	handler := IfMatch(func(w http.ResponseWriter, r *http.Request) {})
+	send := func() *httptest.ResponseRecorder {
+		body := `{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1"}`
+		req := httptest.NewRequest(http.MethodPut, "/UpdateDataRow", strings.NewReader(body))
+		req.Header.Set("If-Match", current)
+		res := httptest.NewRecorder()
+		handler(res, req)
+		return res
+	}
+
+	// edits to other rows leave the tag current
+	backend.UpdateValues(svctest.SpreadsheetID, "Sheet1!B5", &sheets.ValueRange{Values: [][]interface{}{{"changed"}}})
+	backend.AppendValues(svctest.SpreadsheetID, "Sheet1", &sheets.ValueRange{Values: [][]interface{}{{"5", "test5", "test5@gmail.com"}}})
+	if res := send(); res.Code != http.StatusOK {
+		t.Errorf("Expected status %d after edits outside the rows but got %d", http.StatusOK, res.Code)
+	}
+
+	backend.UpdateValues(svctest.SpreadsheetID, "Sheet1!B3", &sheets.ValueRange{Values: [][]interface{}{{"changed"}}})
+	res := send()
+	values, _ = backend.GetValues(svctest.SpreadsheetID, "Sheet1")
+	latest := etag.Compute(values.Values, etag.Rows{First: 1, Last: 3})
+	if res.Code != http.StatusPreconditionFailed || res.Header().Get("ETag") != latest {
+		t.Errorf("Expected status %d with ETag %s after an edit inside the rows but got %d and %q", http.StatusPreconditionFailed, latest, res.Code, res.Header().Get("ETag"))
+	}
+}
+
+func TestIfMatchConcurrentWriters(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+	previous := history.Default()
+	store, _ := history.NewStore("")
+	history.SetDefault(store)
+	defer history.SetDefault(previous)
+
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet1")
+	current := etag.Compute(values.Values, etag.Rows{First: 1})
+
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	handler := History(IfMatch(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		body, _ := io.ReadAll(r.Body)
+		backend.UpdateValues(svctest.SpreadsheetID, "Sheet1!B2", &sheets.ValueRange{Values: [][]interface{}{{string(body)}}})
+	}))
+
+	body := `{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1"}`
+	statuses := make(chan int, 2)
+	send := func() {
+		req := httptest.NewRequest(http.MethodPut, "/UpdateDataRow", strings.NewReader(body))
+		req.Header.Set("If-Match", current)
+		res := httptest.NewRecorder()
+		handler(res, req)
+		statuses <- res.Code
+	}
+
+	go send()
+	<-entered
+	// the second writer holds the same tag but must wait for the first
+	go send()
+	select {
+	case <-entered:
+		t.Fatal("Expected the second write to wait for the first")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+
+	got := map[int]int{<-statuses: 1}
+	got[<-statuses]++
+	if got[http.StatusOK] != 1 || got[http.StatusPreconditionFailed] != 1 {
+		t.Errorf("Expected one write to succeed and one to get %d but got %v", http.StatusPreconditionFailed, got)
+	}
+}
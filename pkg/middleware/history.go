@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"personnel-api/pkg/audit"
+	"personnel-api/pkg/authorization"
+	"personnel-api/pkg/history"
+)
+
+// History logs the values a write changes. While the handler runs, the
+// write helpers note the block of the target sheet each of their writes
+// changes, with its values before and after; the noted writes are then saved
+// to the change log as one change with the caller's principal, and its range
+// is noted on the audit event. Writes a request made before failing are
+// logged too, so they can be reverted. Requests that do not name a sheet are
+// not logged.
+//
+// Writes to the same sheet run one at a time, so handlers wrapped by
+// History, such as IfMatch, see no other write land while they run.
+func History(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		spreadsheetID, sheetName, _ := requestTarget(r)
+		if spreadsheetID == "" || sheetName == "" {
+			next(w, r)
+			return
+		}
+
+		recording := history.Default().Begin(spreadsheetID, sheetName)
+		defer recording.End()
+
+		next(w, r)
+
+		operation := strings.TrimPrefix(r.URL.Path, "/")
+		principal := authorization.PrincipalFromContext(r.Context())
+		change, err := recording.Commit(operation, principal)
+		if err != nil {
+			log.Printf("history: unable to record %s on %s/%s: %v", operation, spreadsheetID, sheetName, err)
+		}
+		if change != nil {
+			audit.SetRange(r.Context(), change.Range)
+		}
+	}
+}
+
+// statusRecorder remembers the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/authorization"
+	"personnel-api/pkg/history"
+	"personnel-api/pkg/svc"
+	"personnel-api/pkg/svc/svctest"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+func TestHistory(t *testing.T) {
+	svc.SetBackend(svctest.NewBackend())
+	previous := history.Default()
+	store, _ := history.NewStore("")
+	history.SetDefault(store)
+	defer history.SetDefault(previous)
+
+	write := func(a1 string, value string) {
+		edit := history.NewEdit(svctest.SpreadsheetID, "Sheet1", a1)
+		svc.GetBackend().UpdateValues(svctest.SpreadsheetID, a1, &sheets.ValueRange{Values: [][]interface{}{{value}}})
+		edit.Write(a1, [][]interface{}{{value}})
+		edit.Done()
+	}
+	handler := History(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			write("Sheet1!A1", "half done")
+			apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "failed")
+			return
+		}
+		if r.URL.Query().Get("read") != "" {
+			return
+		}
+		write("Sheet1!B3", "changed")
+	})
+
+	body := `{"spreadsheetID": "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w", "sheetName": "Sheet1"}`
+	for _, target := range []string{"/UpdateDataCell", "/UpdateDataCell?read=1", "/UpdateDataCell?fail=1"} {
+		req := httptest.NewRequest(http.MethodPut, target, strings.NewReader(body))
+		req = req.WithContext(authorization.WithPrincipal(req.Context(), "alice"))
+		handler(httptest.NewRecorder(), req)
+	}
+
+	// a request that wrote nothing is not logged, and writes made before a
+	// failure are
+	changes := store.List(svctest.SpreadsheetID, "Sheet1", time.Time{})
+	if len(changes) != 2 {
+		t.Fatalf("Expected two changes but got %+v", changes)
+	}
+	if changes[0].Range != "'Sheet1'!A1:A1" || changes[0].After[0][0] != "half done" {
+		t.Errorf("Expected the write before the failure to be logged but got %+v", changes[0])
+	}
+	change := changes[1]
+	if change.Range != "'Sheet1'!B3:B3" || change.Operation != "UpdateDataCell" || change.Principal != "alice" {
+		t.Errorf("Unexpected change %+v", change)
+	}
+	if change.Before[0][0] != "test2" || change.After[0][0] != "changed" {
+		t.Errorf("Unexpected values %v -> %v", change.Before, change.After)
+	}
+
+	// only the range written is read, not the sheet
+	backend := &readingBackend{MemoryBackend: svctest.NewBackend()}
+	svc.SetBackend(backend)
+	req := httptest.NewRequest(http.MethodPut, "/UpdateDataCell", strings.NewReader(body))
+	handler(httptest.NewRecorder(), req)
+	if len(backend.reads) != 1 || backend.reads[0] != "'Sheet1'!B3:B3" {
+		t.Errorf("Expected one read of the written range but got %v", backend.reads)
+	}
+}
+
+// readingBackend records the ranges read.
+type readingBackend struct {
+	*svc.MemoryBackend
+	reads []string
+}
+
+func (b *readingBackend) GetValues(spreadsheetID string, readRange string) (*sheets.ValueRange, error) {
+	b.reads = append(b.reads, readRange)
+	return b.MemoryBackend.GetValues(spreadsheetID, readRange)
+}
@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"personnel-api/pkg/svc"
+)
+
+// MaxBodyBytes caps the JSON bodies read to find a request's target. Uploads
+// are left to their handler, which caps them itself.
+const MaxBodyBytes = 10 << 20
+
+var (
+	errTargetMismatch = errors.New("the query string and the body name different targets")
+	errUnknownSheet   = errors.New("sheetID does not name a sheet of the spreadsheet")
+	errBodyTooLarge   = errors.New("request body is too large")
+)
+
+// requestTarget returns the spreadsheet and sheet a request operates on, as
+// read by readTarget. A numeric sheetID is always resolved to the sheet
+// title, since that is what the sheet handlers act on, and errUnknownSheet
+// is returned when it names no sheet of the spreadsheet.
+func requestTarget(r *http.Request) (string, string, error) {
+	spreadsheetID, sheetName, sheetID, err := readTarget(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	if sheetID != nil && spreadsheetID != "" {
+		title := sheetTitle(spreadsheetID, *sheetID)
+		if title == "" {
+			return "", "", errUnknownSheet
+		}
+		if sheetName != "" && sheetName != title {
+			return "", "", errTargetMismatch
+		}
+		sheetName = title
+	}
+
+	return spreadsheetID, sheetName, nil
+}
+
+// readTarget reads the spreadsheetID, sheetName and sheetID a request names
+// in its query string and JSON body, without asking the backend. The body is
+// restored so the handler can read it again. Uploads are not read, so they
+// can be streamed by the handler and must name their target in the query
+// string.
+//
+// Handlers read their target from one place or the other, so a request whose
+// query string and body name different targets gets errTargetMismatch. A
+// body cut short by http.MaxBytesReader gets errBodyTooLarge.
+func readTarget(r *http.Request) (string, string, *int64, error) {
+	query := r.URL.Query()
+	spreadsheetID := query.Get("spreadsheetID")
+	sheetName := query.Get("sheetName")
+
+	if r.Body == nil || r.Body == http.NoBody || isUpload(r) {
+		return spreadsheetID, sheetName, nil, nil
+	}
+
+	body, err := io.ReadAll(r.Body)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		// the body is left cut short, so later reads fail the same way
+		return "", "", nil, errBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var fields struct {
+		SpreadsheetID string `json:"spreadsheetID"`
+		SheetName     string `json:"sheetName"`
+		SheetID       *int64 `json:"sheetID"`
+	}
+	if err != nil || json.Unmarshal(body, &fields) != nil {
+		return spreadsheetID, sheetName, nil, nil
+	}
+	if spreadsheetID, err = sameTarget(spreadsheetID, fields.SpreadsheetID); err != nil {
+		return "", "", nil, err
+	}
+	if sheetName, err = sameTarget(sheetName, fields.SheetName); err != nil {
+		return "", "", nil, err
+	}
+	return spreadsheetID, sheetName, fields.SheetID, nil
+}
+
+// sameTarget returns whichever of the query and body values is set, or
+// errTargetMismatch when both are set and differ.
+func sameTarget(query string, body string) (string, error) {
+	if query != "" && body != "" && query != body {
+		return "", errTargetMismatch
+	}
+	if query != "" {
+		return query, nil
+	}
+	return body, nil
+}
+
+// isUpload reports whether the body is a file upload rather than JSON.
+func isUpload(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return strings.HasPrefix(mediaType, "multipart/") || mediaType == "text/csv" || mediaType == "text/tab-separated-values"
+}
+
+func sheetTitle(spreadsheetID string, sheetID int64) string {
+	spreadsheet, err := svc.GetBackend().GetSpreadsheet(spreadsheetID)
+	if err != nil {
+		return ""
+	}
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties != nil && sheet.Properties.SheetId == sheetID {
+			return sheet.Properties.Title
+		}
+	}
+	return ""
+}
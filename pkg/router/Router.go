@@ -0,0 +1,101 @@
+// Package router matches versioned REST paths such as
+// /v1/spreadsheets/{spreadsheetID}/sheets/{sheetName}/rows.
+package router
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"personnel-api/pkg/apierror"
+)
+
+type route struct {
+	method   string
+	segments []string
+	handler  http.HandlerFunc
+}
+
+// Router dispatches on method and path. Path parameters are copied into the
+// query string under their names, so handlers and middleware read them with
+// r.URL.Query() exactly like the query parameters of the older routes.
+type Router struct {
+	routes []route
+}
+
+func New() *Router {
+	return &Router{}
+}
+
+// HandleFunc registers handler for method and pattern. Segments written as
+// {name} match any single path segment.
+func (rt *Router) HandleFunc(method string, pattern string, handler http.HandlerFunc) {
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		segments: splitPath(pattern),
+		handler:  handler,
+	})
+}
+
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.EscapedPath())
+
+	var allowed []string
+	for _, route := range rt.routes {
+		params, ok := route.match(segments)
+		if !ok {
+			continue
+		}
+		if route.method != r.Method {
+			allowed = append(allowed, route.method)
+			continue
+		}
+
+		query := r.URL.Query()
+		for name, value := range params {
+			query.Set(name, value)
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.RawQuery = query.Encode()
+
+		route.handler(w, r2)
+		return
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "No route for "+r.URL.Path)
+}
+
+func (route route) match(segments []string) (map[string]string, bool) {
+	if len(segments) != len(route.segments) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for i, segment := range route.segments {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			value, err := url.PathUnescape(segments[i])
+			if err != nil || value == "" {
+				return nil, false
+			}
+			params[segment[1:len(segment)-1]] = value
+			continue
+		}
+		if segment != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, "/")
+}
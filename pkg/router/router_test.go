@@ -0,0 +1,48 @@
+package router
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	rt := New()
+
+	var got string
+	rt.HandleFunc(http.MethodGet, "/v1/spreadsheets", func(w http.ResponseWriter, r *http.Request) {
+		got = "list"
+	})
+	rt.HandleFunc(http.MethodGet, "/v1/spreadsheets/{spreadsheetID}/sheets/{sheetName}/rows", func(w http.ResponseWriter, r *http.Request) {
+		got = r.URL.Query().Get("spreadsheetID") + "|" + r.URL.Query().Get("sheetName") + "|" + r.URL.Query().Get("column")
+	})
+
+	cases := []struct {
+		name   string
+		method string
+		url    string
+		status int
+		got    string
+	}{
+		{"static route", http.MethodGet, "/v1/spreadsheets", http.StatusOK, "list"},
+		{"trailing slash", http.MethodGet, "/v1/spreadsheets/", http.StatusOK, "list"},
+		{"path params", http.MethodGet, "/v1/spreadsheets/abc/sheets/Sheet1/rows?column=Email", http.StatusOK, "abc|Sheet1|Email"},
+		{"escaped sheet name", http.MethodGet, "/v1/spreadsheets/abc/sheets/Q1%2F2024%20Sales/rows", http.StatusOK, "abc|Q1/2024 Sales|"},
+		{"path param wins over query", http.MethodGet, "/v1/spreadsheets/abc/sheets/Sheet1/rows?spreadsheetID=other", http.StatusOK, "abc|Sheet1|"},
+		{"wrong method", http.MethodPost, "/v1/spreadsheets", http.StatusMethodNotAllowed, ""},
+		{"unknown path", http.MethodGet, "/v1/spreadsheets/abc/unknown", http.StatusNotFound, ""},
+	}
+
+	for _, c := range cases {
+		got = ""
+		res := httptest.NewRecorder()
+		rt.ServeHTTP(res, httptest.NewRequest(c.method, c.url, nil))
+
+		if res.Code != c.status {
+			t.Errorf("%s: expected status code %d but got %d", c.name, c.status, res.Code)
+		}
+		if got != c.got {
+			t.Errorf("%s: expected %q but got %q", c.name, c.got, got)
+		}
+	}
+}
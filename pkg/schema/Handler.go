@@ -0,0 +1,146 @@
+package schema
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"personnel-api/pkg/apierror"
+)
+
+/*
+PUT
+
+	Body: {
+			"spreadsheetID": "YOUR_SPREAD_SHEET_ID",
+			"sheetName": "SHEET_NAME",
+			"columns": [
+				{"name": "ID", "type": "int", "required": true, "unique": true},
+				{"name": "Email", "type": "email"},
+				{"name": "Status", "type": "enum", "values": ["active", "left"]},
+				{"name": "Code", "pattern": "^[A-Z]{3}$"},
+				{"name": "Start", "type": "date", "format": "2006-01-02"}
+			]
+		  }
+*/
+func SetSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
+		return
+	}
+
+	var schema Schema
+	err = json.Unmarshal(body, &schema)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
+		return
+	}
+
+	if err := schema.Compile(); err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, err.Error())
+		return
+	}
+
+	if err := Default().Set(&schema); err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Cannot save schema: "+err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schema)
+}
+
+/*
+GET
+Query params: spreadsheetID=YOUR_SPREAD_SHEET_ID&sheetName=SHEET_NAME
+Without sheetName every schema of the spreadsheet is listed, and without
+spreadsheetID every schema.
+*/
+func GetSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	spreadsheetID := r.URL.Query().Get("spreadsheetID")
+	sheetName := r.URL.Query().Get("sheetName")
+
+	var response interface{}
+	if sheetName != "" {
+		schema := Default().Lookup(spreadsheetID, sheetName)
+		if schema == nil {
+			apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "No schema registered for that sheet")
+			return
+		}
+		response = schema
+	} else {
+		response = struct {
+			Schemas []*Schema `json:"schemas"`
+		}{Schemas: Default().List(spreadsheetID)}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+/*
+DELETE
+Body: {"spreadsheetID": "YOUR_SPREAD_SHEET_ID", "sheetName": "SHEET_NAME"}
+*/
+func DeleteSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
+		return
+	}
+
+	var req struct {
+		SpreadsheetID string `json:"spreadsheetID"`
+		SheetName     string `json:"sheetName"`
+	}
+
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
+		return
+	}
+
+	if req.SpreadsheetID == "" || req.SheetName == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "spreadsheetID and sheetName fields are required")
+		return
+	}
+
+	removed, err := Default().Delete(req.SpreadsheetID, req.SheetName)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Cannot save schemas: "+err.Error())
+		return
+	}
+	if !removed {
+		apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "No schema registered for that sheet")
+		return
+	}
+
+	response := struct {
+		SpreadsheetID string `json:"spreadsheetID"`
+		SheetName     string `json:"sheetName"`
+		Message       string `json:"message"`
+	}{
+		SpreadsheetID: req.SpreadsheetID,
+		SheetName:     req.SheetName,
+		Message:       "Schema deleted successfully",
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
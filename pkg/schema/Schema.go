@@ -0,0 +1,232 @@
+// Package schema describes the columns of a sheet (type, required, unique,
+// pattern, allowed values) so the write endpoints can reject bad rows with
+// field level errors before anything is sent to Google.
+package schema
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/filter"
+)
+
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeDate   = "date"
+	TypeEmail  = "email"
+	TypeEnum   = "enum"
+)
+
+// Column constrains the values of one column, matched to the sheet by its
+// header. Empty values only fail Required; the other rules apply to
+// non-empty values.
+type Column struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type,omitempty"`
+	Required bool     `json:"required,omitempty"`
+	Unique   bool     `json:"unique,omitempty"`
+	Pattern  string   `json:"pattern,omitempty"`
+	Values   []string `json:"values,omitempty"`
+	Format   string   `json:"format,omitempty"`
+
+	pattern *regexp.Regexp
+}
+
+// Schema is registered per sheet. Columns of the sheet that are not listed
+// accept any value, but rows may never have more cells than the header.
+type Schema struct {
+	SpreadsheetID string   `json:"spreadsheetID"`
+	SheetName     string   `json:"sheetName"`
+	Columns       []Column `json:"columns"`
+}
+
+// FieldError reports a value that breaks a column rule. Index is the
+// position of the row in the request.
+type FieldError struct {
+	Index   int         `json:"index"`
+	Column  string      `json:"column"`
+	Value   interface{} `json:"value,omitempty"`
+	Message string      `json:"message"`
+}
+
+// Compile checks the schema is well formed and prepares its patterns.
+func (s *Schema) Compile() error {
+	if s.SpreadsheetID == "" || s.SheetName == "" {
+		return fmt.Errorf("spreadsheetID and sheetName fields are required")
+	}
+	if len(s.Columns) == 0 {
+		return fmt.Errorf("columns field is required")
+	}
+
+	names := map[string]bool{}
+	for i := range s.Columns {
+		c := &s.Columns[i]
+		if c.Name == "" {
+			return fmt.Errorf("column %d has no name", i+1)
+		}
+		if names[c.Name] {
+			return fmt.Errorf("column %q is listed twice", c.Name)
+		}
+		names[c.Name] = true
+
+		switch c.Type {
+		case "":
+			c.Type = TypeString
+		case TypeString, TypeInt, TypeFloat, TypeBool, TypeDate, TypeEmail:
+		case TypeEnum:
+			if len(c.Values) == 0 {
+				return fmt.Errorf("column %q is an enum without values", c.Name)
+			}
+		default:
+			return fmt.Errorf("column %q has unknown type %q", c.Name, c.Type)
+		}
+		if c.Format != "" && c.Type != TypeDate {
+			return fmt.Errorf("column %q has a format but is not a date", c.Name)
+		}
+
+		if c.Pattern != "" {
+			pattern, err := regexp.Compile(c.Pattern)
+			if err != nil {
+				return fmt.Errorf("column %q has an invalid pattern: %v", c.Name, err)
+			}
+			c.pattern = pattern
+		}
+	}
+	return nil
+}
+
+// Validate checks rows about to be written to a sheet whose header and data
+// rows are given. replaces holds, for each row, the index in existing of the
+// row it overwrites, or -1 for a new row, so a row does not clash with its own
+// old values in unique columns. Failures are returned as a 422 listing every
+// field error.
+func (s *Schema) Validate(header []interface{}, existing [][]interface{}, rows [][]interface{}, replaces []int) error {
+	var fields []FieldError
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[fmt.Sprint(name)] = i
+	}
+
+	for i, row := range rows {
+		if len(row) > len(header) {
+			fields = append(fields, FieldError{Index: i, Message: fmt.Sprintf("row has %d values but the sheet has %d columns", len(row), len(header))})
+		}
+	}
+
+	for _, c := range s.Columns {
+		idx, ok := columns[c.Name]
+		if !ok {
+			if c.Required {
+				fields = append(fields, FieldError{Column: c.Name, Message: "column is required but missing from the sheet"})
+			}
+			continue
+		}
+
+		taken := map[string]bool{}
+		if c.Unique {
+			skip := map[int]bool{}
+			for _, r := range replaces {
+				skip[r] = true
+			}
+			for j, row := range existing {
+				if !skip[j] {
+					if v := cellText(row, idx); v != "" {
+						taken[v] = true
+					}
+				}
+			}
+		}
+
+		for i, row := range rows {
+			v := cellText(row, idx)
+			if v == "" {
+				if c.Required {
+					fields = append(fields, FieldError{Index: i, Column: c.Name, Message: "value is required"})
+				}
+				continue
+			}
+
+			if message := c.check(v); message != "" {
+				fields = append(fields, FieldError{Index: i, Column: c.Name, Value: row[idx], Message: message})
+				continue
+			}
+
+			if c.Unique {
+				if taken[v] {
+					fields = append(fields, FieldError{Index: i, Column: c.Name, Value: row[idx], Message: "value must be unique"})
+				}
+				taken[v] = true
+			}
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	e := apierror.New(http.StatusUnprocessableEntity, apierror.CodeValidationFailed, "%d values failed validation", len(fields))
+	e.Details = map[string]interface{}{"fields": fields}
+	return e
+}
+
+// check returns why v is not a valid value of the column, or "".
+func (c *Column) check(v string) string {
+	switch c.Type {
+	case TypeInt:
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+			return "value must be an integer"
+		}
+	case TypeFloat:
+		if _, err := strconv.ParseFloat(v, 64); err != nil {
+			return "value must be a number"
+		}
+	case TypeBool:
+		if _, err := strconv.ParseBool(strings.ToLower(v)); err != nil {
+			return "value must be true or false"
+		}
+	case TypeDate:
+		if c.Format != "" {
+			if _, err := time.Parse(c.Format, v); err != nil {
+				return "value must be a date formatted as " + c.Format
+			}
+		} else if _, ok := filter.ParseDate(v); !ok {
+			return "value must be a date"
+		}
+	case TypeEmail:
+		if addr, err := mail.ParseAddress(v); err != nil || addr.Address != v {
+			return "value must be an email address"
+		}
+	case TypeEnum:
+		found := false
+		for _, allowed := range c.Values {
+			if v == allowed {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return "value must be one of " + strings.Join(c.Values, ", ")
+		}
+	}
+
+	if c.pattern != nil && !c.pattern.MatchString(v) {
+		return "value must match " + c.Pattern
+	}
+	return ""
+}
+
+func cellText(row []interface{}, idx int) string {
+	if idx >= len(row) || row[idx] == nil {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(row[idx]))
+}
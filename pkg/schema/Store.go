@@ -0,0 +1,197 @@
+package schema
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type key struct {
+	spreadsheetID string
+	sheetName     string
+}
+
+// makeKey folds the sheet name, as sheet titles are unique regardless of case.
+func makeKey(spreadsheetID string, sheetName string) key {
+	return key{spreadsheetID, strings.ToLower(sheetName)}
+}
+
+// Store holds the registered schemas and saves them as a JSON list to its
+// file, if it has one, on every change.
+type Store struct {
+	path    string
+	mu      sync.RWMutex
+	schemas map[key]*Schema
+}
+
+// NewStore loads the schemas saved at path. A missing file is an empty store
+// and an empty path keeps the schemas in memory only.
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, schemas: map[key]*Schema{}}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read schema file: %v", err)
+	}
+
+	var list []*Schema
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("unable to parse schema file: %v", err)
+	}
+	for _, schema := range list {
+		if err := schema.Compile(); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		s.schemas[makeKey(schema.SpreadsheetID, schema.SheetName)] = schema
+	}
+	return s, nil
+}
+
+// NewStoreFromEnv loads the file named by SCHEMA_FILE, schemas.json by default.
+func NewStoreFromEnv() (*Store, error) {
+	path := os.Getenv("SCHEMA_FILE")
+	if path == "" {
+		path = "schemas.json"
+	}
+	return NewStore(path)
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultStore = &Store{schemas: map[key]*Schema{}}
+)
+
+// SetDefault replaces the store returned by Default.
+func SetDefault(s *Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = s
+}
+
+// Default returns the store used by the api packages. It is empty until
+// SetDefault is called.
+func Default() *Store {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultStore
+}
+
+// Lookup returns the schema of a sheet or nil if it has none.
+func (s *Store) Lookup(spreadsheetID string, sheetName string) *Schema {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.schemas[makeKey(spreadsheetID, sheetName)]
+}
+
+// List returns the schemas of a spreadsheet, or of every spreadsheet when
+// spreadsheetID is empty.
+func (s *Store) List(spreadsheetID string) []*Schema {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []*Schema{}
+	for k, schema := range s.schemas {
+		if spreadsheetID == "" || k.spreadsheetID == spreadsheetID {
+			list = append(list, schema)
+		}
+	}
+	sortSchemas(list)
+	return list
+}
+
+// Set registers schema, replacing the previous schema of the sheet.
+func (s *Store) Set(schema *Schema) error {
+	if err := schema.Compile(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := makeKey(schema.SpreadsheetID, schema.SheetName)
+	previous, had := s.schemas[k]
+	s.schemas[k] = schema
+	if err := s.save(); err != nil {
+		if had {
+			s.schemas[k] = previous
+		} else {
+			delete(s.schemas, k)
+		}
+		return err
+	}
+	return nil
+}
+
+// Delete removes the schema of a sheet and reports whether it had one.
+func (s *Store) Delete(spreadsheetID string, sheetName string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := makeKey(spreadsheetID, sheetName)
+	previous, ok := s.schemas[k]
+	if !ok {
+		return false, nil
+	}
+	delete(s.schemas, k)
+	if err := s.save(); err != nil {
+		s.schemas[k] = previous
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes the schemas to a temporary file and renames it over the store
+// file, so a failed write never leaves a truncated file behind.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	list := make([]*Schema, 0, len(s.schemas))
+	for _, schema := range s.schemas {
+		list = append(list, schema)
+	}
+	sortSchemas(list)
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".schemas-*")
+	if err != nil {
+		return fmt.Errorf("unable to save schemas: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to save schemas: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to save schemas: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("unable to save schemas: %v", err)
+	}
+	return nil
+}
+
+func sortSchemas(list []*Schema) {
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].SpreadsheetID != list[j].SpreadsheetID {
+			return list[i].SpreadsheetID < list[j].SpreadsheetID
+		}
+		return list[i].SheetName < list[j].SheetName
+	})
+}
@@ -0,0 +1,169 @@
+package schema
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"personnel-api/pkg/apierror"
+)
+
+func testSchema() *Schema {
+	return &Schema{
+		SpreadsheetID: "ss",
+		SheetName:     "Sheet1",
+		Columns: []Column{
+			{Name: "ID", Type: TypeInt, Required: true, Unique: true},
+			{Name: "Email", Type: TypeEmail},
+			{Name: "Status", Type: TypeEnum, Values: []string{"active", "left"}},
+			{Name: "Code", Pattern: "^[A-Z]{3}$"},
+			{Name: "Start", Type: TypeDate},
+		},
+	}
+}
+
+func fieldErrors(t *testing.T, err error) []FieldError {
+	t.Helper()
+	var apiErr *apierror.Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnprocessableEntity || apiErr.Code != apierror.CodeValidationFailed {
+		t.Fatalf("Expected a 422 validation error but got %v", err)
+	}
+	return apiErr.Details.(map[string]interface{})["fields"].([]FieldError)
+}
+
+func TestValidate(t *testing.T) {
+	s := testSchema()
+	if err := s.Compile(); err != nil {
+		t.Fatalf("Compile returned error: %v", err)
+	}
+
+	header := []interface{}{"ID", "Email", "Status", "Code", "Start"}
+	existing := [][]interface{}{
+		{"1", "a@example.com", "active", "ABC", "2024-01-02"},
+		{"2", "b@example.com", "left"},
+	}
+
+	valid := [][]interface{}{
+		{"3", "c@example.com", "active", "XYZ", "1/2/2024"},
+		{"2", "", "", "", ""},
+	}
+	if err := s.Validate(header, existing, valid, []int{-1, 1}); err != nil {
+		t.Errorf("Expected valid rows but got %v", err)
+	}
+
+	invalid := [][]interface{}{
+		{"x", "not an email", "gone", "abc", "someday"},
+		{"", "c@example.com"},
+		{"1"},
+		{"4", "", "", "", "", "extra"},
+	}
+	got := fieldErrors(t, s.Validate(header, existing, invalid, []int{-1, -1, -1, -1}))
+
+	type field struct {
+		index  int
+		column string
+	}
+	var fields []field
+	for _, f := range got {
+		fields = append(fields, field{f.Index, f.Column})
+	}
+	expected := []field{{3, ""}, {0, "ID"}, {1, "ID"}, {2, "ID"}, {0, "Email"}, {0, "Status"}, {0, "Code"}, {0, "Start"}}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("Expected field errors %v but got %+v", expected, got)
+	}
+}
+
+func TestCompile(t *testing.T) {
+	for _, s := range []*Schema{
+		{SheetName: "Sheet1", Columns: []Column{{Name: "ID"}}},
+		{SpreadsheetID: "ss", SheetName: "Sheet1"},
+		{SpreadsheetID: "ss", SheetName: "Sheet1", Columns: []Column{{Name: "ID"}, {Name: "ID"}}},
+		{SpreadsheetID: "ss", SheetName: "Sheet1", Columns: []Column{{Name: "ID", Type: "uuid"}}},
+		{SpreadsheetID: "ss", SheetName: "Sheet1", Columns: []Column{{Name: "ID", Type: TypeEnum}}},
+		{SpreadsheetID: "ss", SheetName: "Sheet1", Columns: []Column{{Name: "ID", Pattern: "("}}},
+		{SpreadsheetID: "ss", SheetName: "Sheet1", Columns: []Column{{Name: "ID", Format: "2006"}}},
+	} {
+		if err := s.Compile(); err == nil {
+			t.Errorf("Expected an error for %+v", s)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schemas.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore returned error: %v", err)
+	}
+
+	if err := store.Set(testSchema()); err != nil {
+		t.Fatalf("Set returned error: %v", err)
+	}
+
+	reloaded, err := NewStore(path)
+	if err != nil {
+		t.Fatalf("NewStore returned error: %v", err)
+	}
+	s := reloaded.Lookup("ss", "SHEET1")
+	if s == nil || len(s.Columns) != 5 || s.Columns[3].pattern == nil {
+		t.Fatalf("Expected the saved schema to be loaded but got %+v", s)
+	}
+	if len(reloaded.List("ss")) != 1 || len(reloaded.List("other")) != 0 {
+		t.Errorf("Unexpected list %v", reloaded.List(""))
+	}
+
+	if removed, err := reloaded.Delete("ss", "Sheet1"); !removed || err != nil {
+		t.Errorf("Delete returned %v, %v", removed, err)
+	}
+	if removed, _ := reloaded.Delete("ss", "Sheet1"); removed {
+		t.Errorf("Expected a second delete to find nothing")
+	}
+	if again, _ := NewStore(path); again.Lookup("ss", "Sheet1") != nil {
+		t.Errorf("Expected the deletion to be saved")
+	}
+}
+
+func TestSchemaHandlers(t *testing.T) {
+	previous := Default()
+	store, _ := NewStore("")
+	SetDefault(store)
+	defer SetDefault(previous)
+
+	res := httptest.NewRecorder()
+	SetSchema(res, httptest.NewRequest(http.MethodPut, "/SetSchema", bytes.NewReader([]byte(`{
+		"spreadsheetID": "ss",
+		"sheetName": "Sheet1",
+		"columns": [{"name": "ID", "type": "int", "required": true}]
+	}`))))
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	SetSchema(res, httptest.NewRequest(http.MethodPut, "/SetSchema", bytes.NewReader([]byte(`{"spreadsheetID": "ss", "sheetName": "Sheet1", "columns": [{"name": "ID", "type": "uuid"}]}`))))
+	if res.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code %d but got %d", http.StatusBadRequest, res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	GetSchema(res, httptest.NewRequest(http.MethodGet, "/GetSchema?spreadsheetID=ss&sheetName=Sheet1", nil))
+	if res.Code != http.StatusOK || !bytes.Contains(res.Body.Bytes(), []byte(`"type":"int"`)) {
+		t.Errorf("Unexpected response %d %s", res.Code, res.Body.String())
+	}
+
+	res = httptest.NewRecorder()
+	DeleteSchema(res, httptest.NewRequest(http.MethodDelete, "/DeleteSchema", bytes.NewReader([]byte(`{"spreadsheetID": "ss", "sheetName": "Sheet1"}`))))
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d", http.StatusOK, res.Code)
+	}
+
+	res = httptest.NewRecorder()
+	GetSchema(res, httptest.NewRequest(http.MethodGet, "/GetSchema?spreadsheetID=ss&sheetName=Sheet1", nil))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res.Code)
+	}
+}
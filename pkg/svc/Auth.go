@@ -0,0 +1,136 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// AuthOAuth uses an OAuth client plus a previously issued token, read
+	// from GOOGLE_OAUTH_TOKEN or the token file. It never prompts.
+	AuthOAuth = "oauth"
+	// AuthInteractive behaves like AuthOAuth but asks for an authorization
+	// code on stdin when no token exists yet. Only useful on a workstation.
+	AuthInteractive = "interactive"
+	// AuthServiceAccount authenticates with a service-account JSON key.
+	AuthServiceAccount = "service_account"
+)
+
+var googleScopes = []string{
+	"https://www.googleapis.com/auth/spreadsheets",
+	"https://www.googleapis.com/auth/drive.file",
+}
+
+// AuthConfig selects how GoogleBackend authenticates.
+type AuthConfig struct {
+	Mode string
+	// CredentialsFile is the OAuth client secret, or the service-account
+	// key in AuthServiceAccount mode. CredentialsJSON takes precedence.
+	CredentialsFile string
+	CredentialsJSON string
+	// TokenFile is where the OAuth token is read from and refreshed tokens
+	// are written back to. TokenJSON takes precedence and is never written.
+	TokenFile string
+	TokenJSON string
+}
+
+// AuthConfigFromEnv reads GOOGLE_AUTH_MODE, GOOGLE_CREDENTIALS_FILE,
+// GOOGLE_CREDENTIALS_JSON, GOOGLE_TOKEN_FILE and GOOGLE_OAUTH_TOKEN. The file
+// paths may point at mounted secrets and default to credentials.json and
+// token.json in the working directory.
+func AuthConfigFromEnv() AuthConfig {
+	cfg := AuthConfig{
+		Mode:            os.Getenv("GOOGLE_AUTH_MODE"),
+		CredentialsFile: os.Getenv("GOOGLE_CREDENTIALS_FILE"),
+		CredentialsJSON: os.Getenv("GOOGLE_CREDENTIALS_JSON"),
+		TokenFile:       os.Getenv("GOOGLE_TOKEN_FILE"),
+		TokenJSON:       os.Getenv("GOOGLE_OAUTH_TOKEN"),
+	}
+	if cfg.Mode == "" {
+		cfg.Mode = AuthOAuth
+	}
+	if cfg.CredentialsFile == "" {
+		cfg.CredentialsFile = prefix + "credentials.json"
+	}
+	if cfg.TokenFile == "" {
+		cfg.TokenFile = prefix + "token.json"
+	}
+	return cfg
+}
+
+// Client builds the authenticated HTTP client shared by the Sheets and Drive
+// services.
+func (cfg AuthConfig) Client(ctx context.Context) (*http.Client, error) {
+	credentials, err := cfg.credentials()
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Mode {
+	case AuthServiceAccount:
+		creds, err := google.CredentialsFromJSON(ctx, credentials, googleScopes...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse service account key: %v", err)
+		}
+		return oauth2.NewClient(ctx, creds.TokenSource), nil
+	case AuthOAuth, AuthInteractive:
+		config, err := google.ConfigFromJSON(credentials, googleScopes...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
+		}
+		return cfg.oauthClient(ctx, config)
+	default:
+		return nil, fmt.Errorf("unknown GOOGLE_AUTH_MODE %q, expected %q, %q or %q", cfg.Mode, AuthOAuth, AuthServiceAccount, AuthInteractive)
+	}
+}
+
+func (cfg AuthConfig) credentials() ([]byte, error) {
+	if cfg.CredentialsJSON != "" {
+		return []byte(cfg.CredentialsJSON), nil
+	}
+
+	b, err := os.ReadFile(cfg.CredentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read client secret file: %v", err)
+	}
+	return b, nil
+}
+
+func (cfg AuthConfig) oauthClient(ctx context.Context, config *oauth2.Config) (*http.Client, error) {
+	if cfg.TokenJSON != "" {
+		tok := &oauth2.Token{}
+		if err := json.Unmarshal([]byte(cfg.TokenJSON), tok); err != nil {
+			return nil, fmt.Errorf("unable to parse GOOGLE_OAUTH_TOKEN: %v", err)
+		}
+		return config.Client(ctx, tok), nil
+	}
+
+	tok, err := tokenFromFile(cfg.TokenFile)
+	if errors.Is(err, os.ErrNotExist) && cfg.Mode == AuthInteractive {
+		tok, err = getTokenFromWeb(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		if err := saveToken(cfg.TokenFile, tok); err != nil {
+			return nil, fmt.Errorf("unable to cache oauth token: %v", err)
+		}
+	} else if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no OAuth token at %s; set GOOGLE_OAUTH_TOKEN, mount a token file, or run once with GOOGLE_AUTH_MODE=%s to create one", cfg.TokenFile, AuthInteractive)
+	} else if err != nil {
+		return nil, fmt.Errorf("unable to read token file: %v", err)
+	}
+
+	source := &persistingTokenSource{
+		source:  config.TokenSource(ctx, tok),
+		path:    cfg.TokenFile,
+		current: tok,
+	}
+	return oauth2.NewClient(ctx, source), nil
+}
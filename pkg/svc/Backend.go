@@ -0,0 +1,87 @@
+package svc
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/sheets/v4"
+)
+
+// Backend is the set of spreadsheet operations used by the api packages.
+// GoogleBackend talks to the real Sheets and Drive APIs, MemoryBackend keeps
+// everything in process so handlers can be exercised without network access.
+type Backend interface {
+	CreateSpreadsheet(spreadsheet *sheets.Spreadsheet) (*sheets.Spreadsheet, error)
+	GetSpreadsheet(spreadsheetID string) (*sheets.Spreadsheet, error)
+	BatchUpdate(spreadsheetID string, req *sheets.BatchUpdateSpreadsheetRequest) (*sheets.BatchUpdateSpreadsheetResponse, error)
+
+	GetValues(spreadsheetID string, readRange string) (*sheets.ValueRange, error)
+	AppendValues(spreadsheetID string, appendRange string, values *sheets.ValueRange) (*sheets.AppendValuesResponse, error)
+	UpdateValues(spreadsheetID string, updateRange string, values *sheets.ValueRange) (*sheets.UpdateValuesResponse, error)
+	ClearValues(spreadsheetID string, clearRange string) (*sheets.ClearValuesResponse, error)
+	BatchUpdateValues(spreadsheetID string, req *sheets.BatchUpdateValuesRequest) (*sheets.BatchUpdateValuesResponse, error)
+	BatchClearValues(spreadsheetID string, req *sheets.BatchClearValuesRequest) (*sheets.BatchClearValuesResponse, error)
+
+	ListSpreadsheets() ([]*drive.File, error)
+	SetTrashed(fileID string, trashed bool) error
+	DeleteFile(fileID string) error
+}
+
+const (
+	BackendGoogle = "google"
+	BackendMemory = "memory"
+)
+
+var (
+	backendMu sync.RWMutex
+	backend   Backend
+)
+
+// SetBackend replaces the backend returned by GetBackend.
+func SetBackend(b Backend) {
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	backend = b
+}
+
+// GetBackend returns the configured backend, falling back to Google when
+// nothing has been set.
+func GetBackend() Backend {
+	backendMu.RLock()
+	b := backend
+	backendMu.RUnlock()
+	if b != nil {
+		return b
+	}
+
+	backendMu.Lock()
+	defer backendMu.Unlock()
+	if backend == nil {
+		backend = &GoogleBackend{}
+	}
+	return backend
+}
+
+// NewBackendFromEnv builds the backend named by the SHEETS_BACKEND
+// environment variable ("google" or "memory"), defaulting to Google. It is
+// meant to be called once at startup and the result shared by all handlers.
+func NewBackendFromEnv() (Backend, error) {
+	switch os.Getenv("SHEETS_BACKEND") {
+	case BackendMemory:
+		return NewMemoryBackend(), nil
+	case BackendGoogle, "":
+		cfg, err := ExecutorConfigFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		backend, err := NewGoogleBackend(AuthConfigFromEnv(), NewExecutor(cfg))
+		if err != nil {
+			return nil, err
+		}
+		return backend, nil
+	default:
+		return nil, fmt.Errorf("unknown SHEETS_BACKEND %q", os.Getenv("SHEETS_BACKEND"))
+	}
+}
@@ -0,0 +1,312 @@
+package svc
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Quota classes of the Sheets API. Reads and writes have separate per-minute
+// quotas; Drive calls are not throttled client side.
+const (
+	quotaRead = iota
+	quotaWrite
+	quotaDrive
+)
+
+// idempotentOps are the calls outside the Sheets read quota that leave the
+// same result when run twice. Like reads, they are retried after a 5xx or a
+// network error; other writes are not, since Google may have applied them
+// before failing.
+var idempotentOps = map[string]bool{
+	"values.update":      true,
+	"values.clear":       true,
+	"values.batchUpdate": true,
+	"values.batchClear":  true,
+	"files.list":         true,
+	"files.update":       true,
+}
+
+// ExecutorConfig controls retries and client-side throttling of Google API
+// calls. A zero per-minute quota disables the token bucket for that class.
+type ExecutorConfig struct {
+	MaxRetries     int
+	BaseDelay      time.Duration
+	MaxDelay       time.Duration
+	ReadPerMinute  int
+	WritePerMinute int
+}
+
+// ExecutorConfigFromEnv reads GOOGLE_MAX_RETRIES, GOOGLE_RETRY_BASE_DELAY,
+// GOOGLE_RETRY_MAX_DELAY, SHEETS_READ_QUOTA and SHEETS_WRITE_QUOTA. The
+// quotas default to the Sheets per-minute limits of a project.
+func ExecutorConfigFromEnv() (ExecutorConfig, error) {
+	cfg := ExecutorConfig{
+		MaxRetries:     5,
+		BaseDelay:      500 * time.Millisecond,
+		MaxDelay:       32 * time.Second,
+		ReadPerMinute:  300,
+		WritePerMinute: 300,
+	}
+
+	for name, target := range map[string]*int{
+		"GOOGLE_MAX_RETRIES": &cfg.MaxRetries,
+		"SHEETS_READ_QUOTA":  &cfg.ReadPerMinute,
+		"SHEETS_WRITE_QUOTA": &cfg.WritePerMinute,
+	} {
+		if s := os.Getenv(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return cfg, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*target = n
+		}
+	}
+
+	for name, target := range map[string]*time.Duration{
+		"GOOGLE_RETRY_BASE_DELAY": &cfg.BaseDelay,
+		"GOOGLE_RETRY_MAX_DELAY":  &cfg.MaxDelay,
+	} {
+		if s := os.Getenv(name); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				return cfg, fmt.Errorf("invalid %s %q", name, s)
+			}
+			*target = d
+		}
+	}
+
+	return cfg, nil
+}
+
+// OperationStats counts the calls of one API operation.
+type OperationStats struct {
+	Calls     uint64  `json:"calls"`
+	Retries   uint64  `json:"retries"`
+	Failures  uint64  `json:"failures"`
+	Throttled uint64  `json:"throttled"`
+	Waited    float64 `json:"waitedSeconds"`
+}
+
+// StatsReporter is implemented by backends that count their API calls.
+type StatsReporter interface {
+	Stats() map[string]OperationStats
+}
+
+// Executor runs Google API calls. Calls wait for a token of their quota
+// class, and retriable errors (429 and rate limit 403s, and 5xx for reads and
+// idempotent writes) are retried with jittered exponential backoff or after
+// the delay asked for by Retry-After.
+type Executor struct {
+	cfg     ExecutorConfig
+	buckets map[int]*tokenBucket
+
+	mu    sync.Mutex
+	stats map[string]*OperationStats
+	rand  *rand.Rand
+
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+func NewExecutor(cfg ExecutorConfig) *Executor {
+	e := &Executor{
+		cfg:     cfg,
+		buckets: map[int]*tokenBucket{},
+		stats:   map[string]*OperationStats{},
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		now:     time.Now,
+		sleep:   time.Sleep,
+	}
+	if cfg.ReadPerMinute > 0 {
+		e.buckets[quotaRead] = newTokenBucket(cfg.ReadPerMinute, e.now())
+	}
+	if cfg.WritePerMinute > 0 {
+		e.buckets[quotaWrite] = newTokenBucket(cfg.WritePerMinute, e.now())
+	}
+	return e
+}
+
+// execute runs call until it succeeds, fails with an error that is not worth
+// retrying or runs out of retries.
+func execute[T any](e *Executor, op string, quota int, call func() (T, error)) (T, error) {
+	for attempt := 0; ; attempt++ {
+		if bucket := e.buckets[quota]; bucket != nil {
+			if wait := bucket.reserve(e.now()); wait > 0 {
+				e.record(op, func(s *OperationStats) {
+					s.Throttled++
+					s.Waited += wait.Seconds()
+				})
+				e.sleep(wait)
+			}
+		}
+
+		result, err := call()
+		e.record(op, func(s *OperationStats) { s.Calls++ })
+		if err == nil {
+			return result, nil
+		}
+
+		delay, retriable := e.retryDelay(err, op, quota, attempt)
+		if !retriable || attempt >= e.cfg.MaxRetries {
+			e.record(op, func(s *OperationStats) { s.Failures++ })
+			return result, err
+		}
+
+		log.Printf("google api: %s failed (attempt %d of %d), retrying in %v: %v", op, attempt+1, e.cfg.MaxRetries+1, delay, err)
+		e.record(op, func(s *OperationStats) { s.Retries++ })
+		e.sleep(delay)
+	}
+}
+
+// retryDelay reports whether err is worth retrying and how long to wait.
+// 429s and rate limit 403s are refused before anything is done, so every call
+// is retried on them. 5xx and network errors are only retried for reads and
+// idempotent writes, as the write may have been applied.
+func (e *Executor) retryDelay(err error, op string, quota int, attempt int) (time.Duration, bool) {
+	repeatable := quota == quotaRead || idempotentOps[op]
+
+	var googleErr *googleapi.Error
+	if errors.As(err, &googleErr) {
+		if !retriableStatus(googleErr) || (googleErr.Code >= 500 && !repeatable) {
+			return 0, false
+		}
+		if wait, ok := retryAfter(googleErr.Header, e.now()); ok {
+			if wait > e.cfg.MaxDelay {
+				return 0, false
+			}
+			return wait, true
+		}
+		return e.backoff(attempt), true
+	}
+
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) && repeatable {
+		return e.backoff(attempt), true
+	}
+	return 0, false
+}
+
+// backoff returns a random delay between zero and BaseDelay * 2^attempt,
+// capped at MaxDelay, so concurrent clients do not retry in lockstep.
+func (e *Executor) backoff(attempt int) time.Duration {
+	ceiling := e.cfg.MaxDelay
+	if attempt < 30 {
+		if d := e.cfg.BaseDelay << uint(attempt); d > 0 && d < ceiling {
+			ceiling = d
+		}
+	}
+	if ceiling <= 0 {
+		return 0
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return time.Duration(e.rand.Int63n(int64(ceiling) + 1))
+}
+
+func retriableStatus(err *googleapi.Error) bool {
+	switch {
+	case err.Code == http.StatusTooManyRequests, err.Code >= 500:
+		return true
+	case err.Code == http.StatusForbidden:
+		for _, item := range err.Errors {
+			switch item.Reason {
+			case "rateLimitExceeded", "userRateLimitExceeded":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retryAfter reads a Retry-After header given either in seconds or as an
+// HTTP date.
+func retryAfter(header http.Header, now time.Time) (time.Duration, bool) {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if wait := t.Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func (e *Executor) record(op string, update func(*OperationStats)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	s, ok := e.stats[op]
+	if !ok {
+		s = &OperationStats{}
+		e.stats[op] = s
+	}
+	update(s)
+}
+
+// Stats returns the counters of every operation run so far.
+func (e *Executor) Stats() map[string]OperationStats {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	stats := map[string]OperationStats{}
+	for op, s := range e.stats {
+		stats[op] = *s
+	}
+	return stats
+}
+
+// tokenBucket holds up to one minute of quota and refills continuously.
+// Callers reserve a token and wait for the returned duration, so the bucket
+// may go negative while requests queue for their turn.
+type tokenBucket struct {
+	mu       sync.Mutex
+	rate     float64
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func newTokenBucket(perMinute int, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:     float64(perMinute) / 60,
+		capacity: float64(perMinute),
+		tokens:   float64(perMinute),
+		last:     now,
+	}
+}
+
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.capacity {
+			b.tokens = b.capacity
+		}
+		b.last = now
+	}
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
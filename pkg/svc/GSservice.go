@@ -5,11 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"net/http"
 	"os"
+	"sync"
 
 	"golang.org/x/oauth2"
-	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v3"
 	"google.golang.org/api/option"
 	"google.golang.org/api/sheets/v4"
@@ -25,31 +24,21 @@ func init() {
 	}
 }
 
-func getClient(config *oauth2.Config) *http.Client {
-	tokFile := prefix + "token.json"
-	tok, err := tokenFromFile(tokFile)
-	if err != nil {
-		tok = getTokenFromWeb(config)
-		saveToken(tokFile, tok)
-	}
-	return config.Client(context.Background(), tok)
-}
-
-func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
+func getTokenFromWeb(ctx context.Context, config *oauth2.Config) (*oauth2.Token, error) {
 	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline)
 	fmt.Printf("Go to the following link in your browser then type the "+
 		"authorization code: \n%v\n", authURL)
 
 	var authCode string
 	if _, err := fmt.Scan(&authCode); err != nil {
-		log.Fatalf("Unable to read authorization code: %v", err)
+		return nil, fmt.Errorf("unable to read authorization code: %v", err)
 	}
 
-	tok, err := config.Exchange(context.TODO(), authCode)
+	tok, err := config.Exchange(ctx, authCode)
 	if err != nil {
-		log.Fatalf("Unable to retrieve token from web: %v", err)
+		return nil, fmt.Errorf("unable to retrieve token from web: %v", err)
 	}
-	return tok
+	return tok, nil
 }
 
 func tokenFromFile(file string) (*oauth2.Token, error) {
@@ -63,60 +52,242 @@ func tokenFromFile(file string) (*oauth2.Token, error) {
 	return tok, err
 }
 
-func saveToken(path string, token *oauth2.Token) {
+func saveToken(path string, token *oauth2.Token) error {
 	fmt.Printf("Saving credential file to: %s\n", path)
 	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o600)
 	if err != nil {
-		log.Fatalf("Unable to cache oauth token: %v", err)
+		return err
 	}
 	defer f.Close()
-	json.NewEncoder(f).Encode(token)
+	return json.NewEncoder(f).Encode(token)
+}
+
+// persistingTokenSource writes the token back to disk whenever the wrapped
+// source hands out a different one, so a refreshed access token (or a rotated
+// refresh token) survives a restart.
+type persistingTokenSource struct {
+	mu      sync.Mutex
+	source  oauth2.TokenSource
+	path    string
+	current *oauth2.Token
 }
 
-func SetupGoogleSheetsService() (*sheets.Service, error) {
+func (p *persistingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := p.source.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.current == nil || tok.AccessToken != p.current.AccessToken || tok.RefreshToken != p.current.RefreshToken {
+		if err := saveToken(p.path, tok); err != nil {
+			log.Printf("Unable to persist refreshed oauth token: %v", err)
+		}
+		p.current = tok
+	}
+	return tok, nil
+}
+
+// newGoogleServices authenticates once and builds the Sheets and Drive
+// services on a single HTTP client.
+func newGoogleServices(auth AuthConfig) (*sheets.Service, *drive.Service, error) {
 	ctx := context.Background()
 
-	crePath := prefix + "credentials.json"
-	b, err := os.ReadFile(crePath)
+	client, err := auth.Client(ctx)
 	if err != nil {
-		log.Fatalf("Unable to read client secret file: %v", err)
+		return nil, nil, err
 	}
-	config, err := google.ConfigFromJSON(b,
-		"https://www.googleapis.com/auth/spreadsheets",
-		"https://www.googleapis.com/auth/drive.file")
+
+	sheetsService, err := sheets.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		log.Fatalf("Unable to parse client secret file to config: %v", err)
+		return nil, nil, fmt.Errorf("unable to retrieve Sheets client: %v", err)
 	}
-	client := getClient(config)
 
-	svc, err := sheets.NewService(ctx, option.WithHTTPClient(client))
+	driveService, err := drive.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		log.Fatalf("Unable to retrieve Sheets client: %v", err)
+		return nil, nil, fmt.Errorf("unable to retrieve Drive client: %v", err)
 	}
 
-	return svc, nil
+	return sheetsService, driveService, nil
 }
 
-func SetupGoogleDriveService() (*drive.Service, error) {
-	ctx := context.Background()
+// GoogleBackend implements Backend on top of the Google Sheets and Drive APIs.
+// The services are created once and shared by every request; the zero value
+// sets them up on first use with AuthConfigFromEnv.
+type GoogleBackend struct {
+	auth   *AuthConfig
+	exec   *Executor
+	once   sync.Once
+	err    error
+	sheets *sheets.Service
+	drive  *drive.Service
+}
 
-	crePath := prefix + "credentials.json"
-	b, err := os.ReadFile(crePath)
-	if err != nil {
-		return nil, fmt.Errorf("unable to read client secret file: %v", err)
+// NewGoogleBackend authenticates immediately so configuration problems are
+// reported at startup rather than on the first request. Every API call goes
+// through exec, which retries and throttles it.
+func NewGoogleBackend(auth AuthConfig, exec *Executor) (*GoogleBackend, error) {
+	g := &GoogleBackend{auth: &auth, exec: exec}
+	if err := g.init(); err != nil {
+		return nil, err
 	}
-	config, err := google.ConfigFromJSON(b,
-		"https://www.googleapis.com/auth/spreadsheets",
-		"https://www.googleapis.com/auth/drive.file")
-	if err != nil {
-		return nil, fmt.Errorf("unable to parse client secret file to config: %v", err)
+	return g, nil
+}
+
+func (g *GoogleBackend) init() error {
+	g.once.Do(func() {
+		auth := AuthConfigFromEnv()
+		if g.auth != nil {
+			auth = *g.auth
+		}
+		if g.exec == nil {
+			cfg, err := ExecutorConfigFromEnv()
+			if err != nil {
+				g.err = err
+				return
+			}
+			g.exec = NewExecutor(cfg)
+		}
+		g.sheets, g.drive, g.err = newGoogleServices(auth)
+	})
+	return g.err
+}
+
+// Stats returns the call, retry and throttling counters of every operation.
+func (g *GoogleBackend) Stats() map[string]OperationStats {
+	if g.exec == nil {
+		return map[string]OperationStats{}
 	}
-	client := getClient(config)
+	return g.exec.Stats()
+}
 
-	driveService, err := drive.NewService(ctx, option.WithHTTPClient(client))
+func (g *GoogleBackend) CreateSpreadsheet(spreadsheet *sheets.Spreadsheet) (*sheets.Spreadsheet, error) {
+	if err := g.init(); err != nil {
+		return nil, err
+	}
+	return execute(g.exec, "spreadsheets.create", quotaWrite, func() (*sheets.Spreadsheet, error) {
+		return g.sheets.Spreadsheets.Create(spreadsheet).Do()
+	})
+}
+
+func (g *GoogleBackend) GetSpreadsheet(spreadsheetID string) (*sheets.Spreadsheet, error) {
+	if err := g.init(); err != nil {
+		return nil, err
+	}
+	return execute(g.exec, "spreadsheets.get", quotaRead, func() (*sheets.Spreadsheet, error) {
+		return g.sheets.Spreadsheets.Get(spreadsheetID).Do()
+	})
+}
+
+func (g *GoogleBackend) BatchUpdate(spreadsheetID string, req *sheets.BatchUpdateSpreadsheetRequest) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	if err := g.init(); err != nil {
+		return nil, err
+	}
+	return execute(g.exec, "spreadsheets.batchUpdate", quotaWrite, func() (*sheets.BatchUpdateSpreadsheetResponse, error) {
+		return g.sheets.Spreadsheets.BatchUpdate(spreadsheetID, req).Do()
+	})
+}
+
+func (g *GoogleBackend) GetValues(spreadsheetID string, readRange string) (*sheets.ValueRange, error) {
+	if err := g.init(); err != nil {
+		return nil, err
+	}
+	return execute(g.exec, "values.get", quotaRead, func() (*sheets.ValueRange, error) {
+		return g.sheets.Spreadsheets.Values.Get(spreadsheetID, readRange).Do()
+	})
+}
+
+func (g *GoogleBackend) AppendValues(spreadsheetID string, appendRange string, values *sheets.ValueRange) (*sheets.AppendValuesResponse, error) {
+	if err := g.init(); err != nil {
+		return nil, err
+	}
+	return execute(g.exec, "values.append", quotaWrite, func() (*sheets.AppendValuesResponse, error) {
+		return g.sheets.Spreadsheets.Values.Append(spreadsheetID, appendRange, values).ValueInputOption("USER_ENTERED").Do()
+	})
+}
+
+func (g *GoogleBackend) UpdateValues(spreadsheetID string, updateRange string, values *sheets.ValueRange) (*sheets.UpdateValuesResponse, error) {
+	if err := g.init(); err != nil {
+		return nil, err
+	}
+	return execute(g.exec, "values.update", quotaWrite, func() (*sheets.UpdateValuesResponse, error) {
+		return g.sheets.Spreadsheets.Values.Update(spreadsheetID, updateRange, values).ValueInputOption("USER_ENTERED").Do()
+	})
+}
+
+func (g *GoogleBackend) ClearValues(spreadsheetID string, clearRange string) (*sheets.ClearValuesResponse, error) {
+	if err := g.init(); err != nil {
+		return nil, err
+	}
+	return execute(g.exec, "values.clear", quotaWrite, func() (*sheets.ClearValuesResponse, error) {
+		return g.sheets.Spreadsheets.Values.Clear(spreadsheetID, clearRange, &sheets.ClearValuesRequest{}).Do()
+	})
+}
+
+func (g *GoogleBackend) BatchUpdateValues(spreadsheetID string, req *sheets.BatchUpdateValuesRequest) (*sheets.BatchUpdateValuesResponse, error) {
+	if err := g.init(); err != nil {
+		return nil, err
+	}
+	if req.ValueInputOption == "" {
+		req.ValueInputOption = "USER_ENTERED"
+	}
+	return execute(g.exec, "values.batchUpdate", quotaWrite, func() (*sheets.BatchUpdateValuesResponse, error) {
+		return g.sheets.Spreadsheets.Values.BatchUpdate(spreadsheetID, req).Do()
+	})
+}
+
+func (g *GoogleBackend) BatchClearValues(spreadsheetID string, req *sheets.BatchClearValuesRequest) (*sheets.BatchClearValuesResponse, error) {
+	if err := g.init(); err != nil {
+		return nil, err
+	}
+	return execute(g.exec, "values.batchClear", quotaWrite, func() (*sheets.BatchClearValuesResponse, error) {
+		return g.sheets.Spreadsheets.Values.BatchClear(spreadsheetID, req).Do()
+	})
+}
+
+func (g *GoogleBackend) ListSpreadsheets() ([]*drive.File, error) {
+	if err := g.init(); err != nil {
+		return nil, err
+	}
+
+	// Query to find all Google Sheets files outside the trash
+	query := "mimeType='application/vnd.google-apps.spreadsheet' and trashed=false"
+
+	results, err := execute(g.exec, "files.list", quotaDrive, func() (*drive.FileList, error) {
+		return g.drive.Files.List().
+			Q(query).
+			Fields("files(id, name, createdTime, modifiedTime)").
+			OrderBy("modifiedTime desc").
+			Do()
+	})
 	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve Drive client: %v", err)
+		return nil, err
 	}
 
-	return driveService, nil
+	return results.Files, nil
+}
+
+// SetTrashed moves a file to or out of the Drive trash. Trashed files are
+// removed by Drive after 30 days unless DeleteFile removes them first.
+func (g *GoogleBackend) SetTrashed(fileID string, trashed bool) error {
+	if err := g.init(); err != nil {
+		return err
+	}
+	file := &drive.File{Trashed: trashed, ForceSendFields: []string{"Trashed"}}
+	_, err := execute(g.exec, "files.update", quotaDrive, func() (*drive.File, error) {
+		return g.drive.Files.Update(fileID, file).Do()
+	})
+	return err
+}
+
+func (g *GoogleBackend) DeleteFile(fileID string) error {
+	if err := g.init(); err != nil {
+		return err
+	}
+	_, err := execute(g.exec, "files.delete", quotaDrive, func() (struct{}, error) {
+		return struct{}{}, g.drive.Files.Delete(fileID).Do()
+	})
+	return err
 }
@@ -0,0 +1,850 @@
+package svc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"personnel-api/pkg/a1"
+
+	"google.golang.org/api/drive/v3"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+// MemoryBackend is an in-process spreadsheet store implementing Backend.
+// Values are kept as strings, the same way the Sheets API returns formatted
+// values, and errors are reported as *googleapi.Error so callers see the same
+// failure shapes as with the real service.
+type MemoryBackend struct {
+	mu           sync.Mutex
+	spreadsheets map[string]*memorySpreadsheet
+}
+
+// MemorySheet describes a sheet passed to MemoryBackend.Seed. Values are
+// written starting at A1.
+type MemorySheet struct {
+	SheetID int64
+	Title   string
+	Values  [][]interface{}
+}
+
+type memorySpreadsheet struct {
+	id           string
+	title        string
+	sheets       []*memorySheet
+	createdTime  time.Time
+	modifiedTime time.Time
+	trashed      bool
+}
+
+type memorySheet struct {
+	id     int64
+	title  string
+	values [][]string
+}
+
+// gridRange is a parsed A1 range. Row and column bounds are zero based with
+// exclusive ends; an end of -1 means the range is unbounded in that direction.
+type gridRange struct {
+	sheet    string
+	startRow int
+	endRow   int
+	startCol int
+	endCol   int
+}
+
+var plainSheetName = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{spreadsheets: make(map[string]*memorySpreadsheet)}
+}
+
+// Seed stores a spreadsheet under the given ID, replacing any existing one.
+func (m *MemoryBackend) Seed(spreadsheetID string, title string, sheetList ...MemorySheet) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	ss := &memorySpreadsheet{id: spreadsheetID, title: title, createdTime: now, modifiedTime: now}
+	for _, s := range sheetList {
+		sheet := &memorySheet{id: s.SheetID, title: s.Title}
+		for i, row := range s.Values {
+			for j, v := range row {
+				sheet.set(i, j, toCell(v))
+			}
+		}
+		ss.sheets = append(ss.sheets, sheet)
+	}
+	m.spreadsheets[spreadsheetID] = ss
+}
+
+func (m *MemoryBackend) CreateSpreadsheet(spreadsheet *sheets.Spreadsheet) (*sheets.Spreadsheet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	title := "Untitled spreadsheet"
+	if spreadsheet.Properties != nil && spreadsheet.Properties.Title != "" {
+		title = spreadsheet.Properties.Title
+	}
+
+	now := time.Now()
+	ss := &memorySpreadsheet{id: newMemoryID(), title: title, createdTime: now, modifiedTime: now}
+	for _, s := range spreadsheet.Sheets {
+		if s.Properties == nil {
+			continue
+		}
+		if _, err := ss.addSheet(s.Properties); err != nil {
+			return nil, err
+		}
+	}
+	if len(ss.sheets) == 0 {
+		ss.sheets = append(ss.sheets, &memorySheet{id: 0, title: "Sheet1"})
+	}
+
+	m.spreadsheets[ss.id] = ss
+	return ss.toAPI(), nil
+}
+
+func (m *MemoryBackend) GetSpreadsheet(spreadsheetID string) (*sheets.Spreadsheet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ss, err := m.lookup(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+	return ss.toAPI(), nil
+}
+
+// BatchUpdate applies the requests to a copy of the spreadsheet and only keeps
+// the result when every request succeeds, matching the all-or-nothing
+// behaviour of the Sheets API.
+func (m *MemoryBackend) BatchUpdate(spreadsheetID string, req *sheets.BatchUpdateSpreadsheetRequest) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ss, err := m.lookup(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+
+	working := ss.clone()
+	response := &sheets.BatchUpdateSpreadsheetResponse{SpreadsheetId: spreadsheetID}
+
+	for _, r := range req.Requests {
+		reply := &sheets.Response{}
+
+		switch {
+		case r.AddSheet != nil:
+			props := r.AddSheet.Properties
+			if props == nil {
+				props = &sheets.SheetProperties{}
+			}
+			sheet, err := working.addSheet(props)
+			if err != nil {
+				return nil, err
+			}
+			reply.AddSheet = &sheets.AddSheetResponse{Properties: working.sheetProperties(sheet)}
+		case r.DeleteSheet != nil:
+			if err := working.deleteSheet(r.DeleteSheet.SheetId); err != nil {
+				return nil, err
+			}
+		case r.UpdateSheetProperties != nil:
+			if err := working.updateSheetProperties(r.UpdateSheetProperties); err != nil {
+				return nil, err
+			}
+		case r.InsertDimension != nil:
+			if err := working.insertDimension(r.InsertDimension.Range); err != nil {
+				return nil, err
+			}
+		case r.DeleteDimension != nil:
+			if err := working.deleteDimension(r.DeleteDimension.Range); err != nil {
+				return nil, err
+			}
+		case r.UpdateSpreadsheetProperties != nil:
+			props := r.UpdateSpreadsheetProperties.Properties
+			if props != nil && strings.Contains(r.UpdateSpreadsheetProperties.Fields, "title") {
+				working.title = props.Title
+			}
+		default:
+			return nil, memoryError(http.StatusBadRequest, "Unsupported request in batchUpdate")
+		}
+
+		response.Replies = append(response.Replies, reply)
+	}
+
+	working.modifiedTime = time.Now()
+	m.spreadsheets[spreadsheetID] = working
+	return response, nil
+}
+
+func (m *MemoryBackend) GetValues(spreadsheetID string, readRange string) (*sheets.ValueRange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sheet, gr, err := m.resolve(spreadsheetID, readRange)
+	if err != nil {
+		return nil, err
+	}
+
+	var values [][]interface{}
+	endRow := len(sheet.values)
+	if gr.endRow >= 0 && gr.endRow < endRow {
+		endRow = gr.endRow
+	}
+	for i := gr.startRow; i < endRow; i++ {
+		row := sheet.values[i]
+		endCol := len(row)
+		if gr.endCol >= 0 && gr.endCol < endCol {
+			endCol = gr.endCol
+		}
+
+		var out []interface{}
+		for j := gr.startCol; j < endCol; j++ {
+			out = append(out, row[j])
+		}
+		for len(out) > 0 && out[len(out)-1] == "" {
+			out = out[:len(out)-1]
+		}
+		if out == nil {
+			out = []interface{}{}
+		}
+		values = append(values, out)
+	}
+	for len(values) > 0 && len(values[len(values)-1]) == 0 {
+		values = values[:len(values)-1]
+	}
+
+	return &sheets.ValueRange{
+		Range:          gr.String(),
+		MajorDimension: "ROWS",
+		Values:         values,
+	}, nil
+}
+
+// AppendValues writes the rows after the last non-empty row found in the
+// columns covered by appendRange.
+func (m *MemoryBackend) AppendValues(spreadsheetID string, appendRange string, values *sheets.ValueRange) (*sheets.AppendValuesResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ss, err := m.lookup(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+	sheet, gr, err := ss.resolve(appendRange)
+	if err != nil {
+		return nil, err
+	}
+
+	startRow := gr.startRow
+	for i := len(sheet.values) - 1; i >= gr.startRow; i-- {
+		if !sheet.rowEmpty(i, gr.startCol, gr.endCol) {
+			startRow = i + 1
+			break
+		}
+	}
+
+	updates := sheet.write(startRow, gr.startCol, values.Values)
+	ss.modifiedTime = time.Now()
+
+	return &sheets.AppendValuesResponse{
+		SpreadsheetId: spreadsheetID,
+		TableRange:    gr.String(),
+		Updates:       updates,
+	}, nil
+}
+
+func (m *MemoryBackend) UpdateValues(spreadsheetID string, updateRange string, values *sheets.ValueRange) (*sheets.UpdateValuesResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ss, err := m.lookup(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+	sheet, gr, err := ss.resolve(updateRange)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := gr.checkWrite(updateRange, values.Values); err != nil {
+		return nil, err
+	}
+
+	updates := sheet.write(gr.startRow, gr.startCol, values.Values)
+	updates.SpreadsheetId = spreadsheetID
+	ss.modifiedTime = time.Now()
+	return updates, nil
+}
+
+// BatchUpdateValues checks every range before writing any of them, so a
+// failing request leaves the spreadsheet unchanged as with the Sheets API.
+func (m *MemoryBackend) BatchUpdateValues(spreadsheetID string, req *sheets.BatchUpdateValuesRequest) (*sheets.BatchUpdateValuesResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ss, err := m.lookup(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+
+	sheetList := make([]*memorySheet, len(req.Data))
+	ranges := make([]gridRange, len(req.Data))
+	for i, data := range req.Data {
+		sheetList[i], ranges[i], err = ss.resolve(data.Range)
+		if err != nil {
+			return nil, err
+		}
+		if err := ranges[i].checkWrite(data.Range, data.Values); err != nil {
+			return nil, err
+		}
+	}
+
+	response := &sheets.BatchUpdateValuesResponse{SpreadsheetId: spreadsheetID}
+	updatedSheets := map[*memorySheet]bool{}
+	for i, data := range req.Data {
+		updates := sheetList[i].write(ranges[i].startRow, ranges[i].startCol, data.Values)
+		updates.SpreadsheetId = spreadsheetID
+		response.Responses = append(response.Responses, updates)
+		response.TotalUpdatedRows += updates.UpdatedRows
+		response.TotalUpdatedColumns += updates.UpdatedColumns
+		response.TotalUpdatedCells += updates.UpdatedCells
+		updatedSheets[sheetList[i]] = true
+	}
+	response.TotalUpdatedSheets = int64(len(updatedSheets))
+	ss.modifiedTime = time.Now()
+
+	return response, nil
+}
+
+func (m *MemoryBackend) ClearValues(spreadsheetID string, clearRange string) (*sheets.ClearValuesResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ss, err := m.lookup(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+	sheet, gr, err := ss.resolve(clearRange)
+	if err != nil {
+		return nil, err
+	}
+
+	sheet.clear(gr)
+	ss.modifiedTime = time.Now()
+
+	return &sheets.ClearValuesResponse{
+		SpreadsheetId: spreadsheetID,
+		ClearedRange:  gr.String(),
+	}, nil
+}
+
+// BatchClearValues resolves every range before clearing any of them.
+func (m *MemoryBackend) BatchClearValues(spreadsheetID string, req *sheets.BatchClearValuesRequest) (*sheets.BatchClearValuesResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ss, err := m.lookup(spreadsheetID)
+	if err != nil {
+		return nil, err
+	}
+
+	sheetList := make([]*memorySheet, len(req.Ranges))
+	ranges := make([]gridRange, len(req.Ranges))
+	for i, clearRange := range req.Ranges {
+		sheetList[i], ranges[i], err = ss.resolve(clearRange)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	response := &sheets.BatchClearValuesResponse{SpreadsheetId: spreadsheetID}
+	for i, gr := range ranges {
+		sheetList[i].clear(gr)
+		response.ClearedRanges = append(response.ClearedRanges, gr.String())
+	}
+	ss.modifiedTime = time.Now()
+
+	return response, nil
+}
+
+func (m *MemoryBackend) ListSpreadsheets() ([]*drive.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	files := make([]*drive.File, 0, len(m.spreadsheets))
+	for _, ss := range m.spreadsheets {
+		if !ss.trashed {
+			files = append(files, ss.toFile())
+		}
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModifiedTime > files[j].ModifiedTime
+	})
+	return files, nil
+}
+
+// SetTrashed hides a spreadsheet from ListSpreadsheets while it is trashed.
+// Like Drive, a trashed spreadsheet can still be read and written by ID.
+func (m *MemoryBackend) SetTrashed(fileID string, trashed bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ss, err := m.lookup(fileID)
+	if err != nil {
+		return memoryError(http.StatusNotFound, "File not found: %s.", fileID)
+	}
+	ss.trashed = trashed
+	ss.modifiedTime = time.Now()
+	return nil
+}
+
+func (m *MemoryBackend) DeleteFile(fileID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, err := m.lookup(fileID); err != nil {
+		return memoryError(http.StatusNotFound, "File not found: %s.", fileID)
+	}
+	delete(m.spreadsheets, fileID)
+	return nil
+}
+
+func (m *MemoryBackend) lookup(spreadsheetID string) (*memorySpreadsheet, error) {
+	ss, ok := m.spreadsheets[spreadsheetID]
+	if !ok {
+		return nil, memoryError(http.StatusNotFound, "Requested entity was not found.")
+	}
+	return ss, nil
+}
+
+func (m *MemoryBackend) resolve(spreadsheetID string, ref string) (*memorySheet, gridRange, error) {
+	ss, err := m.lookup(spreadsheetID)
+	if err != nil {
+		return nil, gridRange{}, err
+	}
+	return ss.resolve(ref)
+}
+
+// resolve finds the sheet referenced by an A1 range. A range without a sheet
+// prefix is first tried as a sheet title and then as a range on the first
+// sheet, which is how the Sheets API disambiguates it.
+func (ss *memorySpreadsheet) resolve(ref string) (*memorySheet, gridRange, error) {
+	requested := ref
+	if !strings.Contains(ref, "!") {
+		if sheet := ss.sheetByTitle(a1.UnquoteSheetName(ref)); sheet != nil {
+			return sheet, gridRange{sheet: sheet.title, endRow: -1, endCol: -1}, nil
+		}
+		if len(ss.sheets) > 0 {
+			ref = quoteSheetName(ss.sheets[0].title) + "!" + ref
+		}
+	}
+
+	gr, err := parseA1(ref)
+	if err != nil {
+		return nil, gridRange{}, memoryError(http.StatusBadRequest, "Unable to parse range: %s", requested)
+	}
+
+	sheet := ss.sheetByTitle(gr.sheet)
+	if sheet == nil {
+		return nil, gridRange{}, memoryError(http.StatusBadRequest, "Unable to parse range: %s", ref)
+	}
+	return sheet, gr, nil
+}
+
+func (ss *memorySpreadsheet) sheetByTitle(title string) *memorySheet {
+	for _, s := range ss.sheets {
+		if s.title == title {
+			return s
+		}
+	}
+	return nil
+}
+
+func (ss *memorySpreadsheet) sheetByID(sheetID int64) *memorySheet {
+	for _, s := range ss.sheets {
+		if s.id == sheetID {
+			return s
+		}
+	}
+	return nil
+}
+
+func (ss *memorySpreadsheet) addSheet(props *sheets.SheetProperties) (*memorySheet, error) {
+	title := props.Title
+	if title == "" {
+		title = fmt.Sprintf("Sheet%d", len(ss.sheets)+1)
+	}
+	if ss.sheetByTitle(title) != nil {
+		return nil, memoryError(http.StatusBadRequest, "Invalid requests[0].addSheet: A sheet with the name \"%s\" already exists. Please enter another name.", title)
+	}
+
+	id := props.SheetId
+	if id != 0 && ss.sheetByID(id) != nil {
+		return nil, memoryError(http.StatusBadRequest, "Invalid requests[0].addSheet: Sheet with id %d already exists.", id)
+	}
+	if id == 0 && ss.sheetByID(0) != nil {
+		for _, s := range ss.sheets {
+			if s.id >= id {
+				id = s.id + 1
+			}
+		}
+	}
+
+	sheet := &memorySheet{id: id, title: title}
+	ss.sheets = append(ss.sheets, sheet)
+	return sheet, nil
+}
+
+func (ss *memorySpreadsheet) deleteSheet(sheetID int64) error {
+	for i, s := range ss.sheets {
+		if s.id == sheetID {
+			if len(ss.sheets) == 1 {
+				return memoryError(http.StatusBadRequest, "Invalid requests[0].deleteSheet: You can't remove all the sheets in a document.")
+			}
+			ss.sheets = append(ss.sheets[:i], ss.sheets[i+1:]...)
+			return nil
+		}
+	}
+	return memoryError(http.StatusBadRequest, "Invalid requests[0].deleteSheet: No sheet with id: %d", sheetID)
+}
+
+// deleteDimension removes rows or columns and shifts the following ones up
+// or left.
+func (ss *memorySpreadsheet) deleteDimension(dr *sheets.DimensionRange) error {
+	if dr == nil || dr.StartIndex < 0 || dr.EndIndex <= dr.StartIndex {
+		return memoryError(http.StatusBadRequest, "Invalid requests[0].deleteDimension: invalid range")
+	}
+	sheet := ss.sheetByID(dr.SheetId)
+	if sheet == nil {
+		return memoryError(http.StatusBadRequest, "Invalid requests[0].deleteDimension: No grid with id: %d", dr.SheetId)
+	}
+
+	start, end := int(dr.StartIndex), int(dr.EndIndex)
+	switch dr.Dimension {
+	case "ROWS":
+		if start < len(sheet.values) {
+			if end > len(sheet.values) {
+				end = len(sheet.values)
+			}
+			sheet.values = append(sheet.values[:start], sheet.values[end:]...)
+		}
+	case "COLUMNS":
+		for i, row := range sheet.values {
+			if start < len(row) {
+				rowEnd := end
+				if rowEnd > len(row) {
+					rowEnd = len(row)
+				}
+				sheet.values[i] = append(row[:start], row[rowEnd:]...)
+			}
+		}
+	default:
+		return memoryError(http.StatusBadRequest, "Invalid requests[0].deleteDimension: dimension must be ROWS or COLUMNS")
+	}
+	return nil
+}
+
+// insertDimension inserts empty rows or columns and shifts the following
+// ones down or right. Formatting is not modelled, so InheritFromBefore has no
+// effect.
+func (ss *memorySpreadsheet) insertDimension(dr *sheets.DimensionRange) error {
+	if dr == nil || dr.StartIndex < 0 || dr.EndIndex <= dr.StartIndex {
+		return memoryError(http.StatusBadRequest, "Invalid requests[0].insertDimension: invalid range")
+	}
+	sheet := ss.sheetByID(dr.SheetId)
+	if sheet == nil {
+		return memoryError(http.StatusBadRequest, "Invalid requests[0].insertDimension: No grid with id: %d", dr.SheetId)
+	}
+
+	start, count := int(dr.StartIndex), int(dr.EndIndex-dr.StartIndex)
+	switch dr.Dimension {
+	case "ROWS":
+		if start < len(sheet.values) {
+			inserted := make([][]string, count)
+			sheet.values = append(sheet.values[:start], append(inserted, sheet.values[start:]...)...)
+		}
+	case "COLUMNS":
+		for i, row := range sheet.values {
+			if start < len(row) {
+				inserted := make([]string, count)
+				sheet.values[i] = append(row[:start], append(inserted, row[start:]...)...)
+			}
+		}
+	default:
+		return memoryError(http.StatusBadRequest, "Invalid requests[0].insertDimension: dimension must be ROWS or COLUMNS")
+	}
+	return nil
+}
+
+func (ss *memorySpreadsheet) updateSheetProperties(req *sheets.UpdateSheetPropertiesRequest) error {
+	if req.Properties == nil {
+		return memoryError(http.StatusBadRequest, "Invalid requests[0].updateSheetProperties: properties are required")
+	}
+
+	sheet := ss.sheetByID(req.Properties.SheetId)
+	if sheet == nil {
+		return memoryError(http.StatusBadRequest, "Invalid requests[0].updateSheetProperties: No grid with id: %d", req.Properties.SheetId)
+	}
+
+	if strings.Contains(req.Fields, "title") {
+		if other := ss.sheetByTitle(req.Properties.Title); other != nil && other != sheet {
+			return memoryError(http.StatusBadRequest, "Invalid requests[0].updateSheetProperties: A sheet with the name \"%s\" already exists. Please enter another name.", req.Properties.Title)
+		}
+		sheet.title = req.Properties.Title
+	}
+	return nil
+}
+
+func (ss *memorySpreadsheet) sheetProperties(sheet *memorySheet) *sheets.SheetProperties {
+	index := 0
+	for i, s := range ss.sheets {
+		if s == sheet {
+			index = i
+		}
+	}
+
+	rows, cols := sheet.size()
+	return &sheets.SheetProperties{
+		SheetId:   sheet.id,
+		Title:     sheet.title,
+		Index:     int64(index),
+		SheetType: "GRID",
+		GridProperties: &sheets.GridProperties{
+			RowCount:    int64(rows),
+			ColumnCount: int64(cols),
+		},
+	}
+}
+
+func (ss *memorySpreadsheet) toAPI() *sheets.Spreadsheet {
+	spreadsheet := &sheets.Spreadsheet{
+		SpreadsheetId: ss.id,
+		Properties:    &sheets.SpreadsheetProperties{Title: ss.title},
+	}
+	for _, s := range ss.sheets {
+		spreadsheet.Sheets = append(spreadsheet.Sheets, &sheets.Sheet{Properties: ss.sheetProperties(s)})
+	}
+	return spreadsheet
+}
+
+func (ss *memorySpreadsheet) toFile() *drive.File {
+	return &drive.File{
+		Id:           ss.id,
+		Name:         ss.title,
+		MimeType:     "application/vnd.google-apps.spreadsheet",
+		CreatedTime:  ss.createdTime.UTC().Format(time.RFC3339Nano),
+		ModifiedTime: ss.modifiedTime.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+func (ss *memorySpreadsheet) clone() *memorySpreadsheet {
+	c := *ss
+	c.sheets = make([]*memorySheet, len(ss.sheets))
+	for i, s := range ss.sheets {
+		values := make([][]string, len(s.values))
+		for j, row := range s.values {
+			values[j] = append([]string(nil), row...)
+		}
+		c.sheets[i] = &memorySheet{id: s.id, title: s.title, values: values}
+	}
+	return &c
+}
+
+func (s *memorySheet) set(row int, col int, value string) {
+	for len(s.values) <= row {
+		s.values = append(s.values, nil)
+	}
+	for len(s.values[row]) <= col {
+		s.values[row] = append(s.values[row], "")
+	}
+	s.values[row][col] = value
+}
+
+func (s *memorySheet) write(startRow int, startCol int, values [][]interface{}) *sheets.UpdateValuesResponse {
+	columns := 0
+	cells := 0
+	for i, row := range values {
+		for j, v := range row {
+			// null values leave the cell as it is, as with the Sheets API
+			if v == nil {
+				continue
+			}
+			s.set(startRow+i, startCol+j, toCell(v))
+			cells++
+		}
+		if len(row) > columns {
+			columns = len(row)
+		}
+	}
+
+	gr := gridRange{sheet: s.title, startRow: startRow, endRow: startRow + len(values), startCol: startCol, endCol: startCol + columns}
+	return &sheets.UpdateValuesResponse{
+		UpdatedRange:   gr.String(),
+		UpdatedRows:    int64(len(values)),
+		UpdatedColumns: int64(columns),
+		UpdatedCells:   int64(cells),
+	}
+}
+
+func (s *memorySheet) clear(gr gridRange) {
+	for i := gr.startRow; i < len(s.values) && (gr.endRow < 0 || i < gr.endRow); i++ {
+		row := s.values[i]
+		for j := gr.startCol; j < len(row) && (gr.endCol < 0 || j < gr.endCol); j++ {
+			row[j] = ""
+		}
+	}
+}
+
+func (s *memorySheet) rowEmpty(row int, startCol int, endCol int) bool {
+	if row >= len(s.values) {
+		return true
+	}
+	for j := startCol; j < len(s.values[row]) && (endCol < 0 || j < endCol); j++ {
+		if s.values[row][j] != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *memorySheet) size() (int, int) {
+	rows, cols := 1000, 26
+	if len(s.values) > rows {
+		rows = len(s.values)
+	}
+	for _, row := range s.values {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	return rows, cols
+}
+
+// checkWrite reports values that do not fit into the range they are written to.
+func (gr gridRange) checkWrite(ref string, values [][]interface{}) error {
+	if gr.endRow >= 0 && gr.startRow+len(values) > gr.endRow {
+		return memoryError(http.StatusBadRequest, "Requested writing within range [%s], but tried writing to row [%d]", ref, gr.startRow+len(values))
+	}
+	for _, row := range values {
+		if gr.endCol >= 0 && gr.startCol+len(row) > gr.endCol {
+			return memoryError(http.StatusBadRequest, "Requested writing within range [%s], but tried writing to column [%s]", ref, a1.ColumnLetter(gr.startCol+len(row)-1))
+		}
+	}
+	return nil
+}
+
+func (gr gridRange) String() string {
+	name := quoteSheetName(gr.sheet)
+	if gr.startRow == 0 && gr.startCol == 0 && gr.endRow < 0 && gr.endCol < 0 {
+		return name
+	}
+
+	start, end := "", ""
+	if gr.endCol >= 0 || gr.startCol > 0 {
+		start = a1.ColumnLetter(gr.startCol)
+	}
+	if gr.endRow >= 0 || gr.startRow > 0 {
+		start += strconv.Itoa(gr.startRow + 1)
+	}
+	if gr.endCol >= 0 {
+		end = a1.ColumnLetter(gr.endCol - 1)
+	}
+	if gr.endRow >= 0 {
+		end += strconv.Itoa(gr.endRow)
+	}
+	if end == "" {
+		return name + "!" + start
+	}
+	return name + "!" + start + ":" + end
+}
+
+// parseA1 parses ranges of the form Sheet!A1:C4, Sheet!A:C, Sheet!2:5 and
+// Sheet!B3. The sheet name may be wrapped in single quotes.
+func parseA1(ref string) (gridRange, error) {
+	idx := strings.LastIndex(ref, "!")
+	if idx < 0 {
+		return gridRange{}, memoryError(http.StatusBadRequest, "Unable to parse range: %s", ref)
+	}
+
+	gr := gridRange{sheet: a1.UnquoteSheetName(ref[:idx]), endRow: -1, endCol: -1}
+	cells := strings.Split(ref[idx+1:], ":")
+	if len(cells) > 2 {
+		return gridRange{}, memoryError(http.StatusBadRequest, "Unable to parse range: %s", ref)
+	}
+
+	startCol, startRow, ok := a1.ParseCell(cells[0])
+	if !ok {
+		return gridRange{}, memoryError(http.StatusBadRequest, "Unable to parse range: %s", ref)
+	}
+	if len(cells) == 1 && (startCol < 0 || startRow < 0) {
+		return gridRange{}, memoryError(http.StatusBadRequest, "Unable to parse range: %s", ref)
+	}
+	endCol, endRow := startCol, startRow
+	if len(cells) == 2 {
+		endCol, endRow, ok = a1.ParseCell(cells[1])
+		if !ok {
+			return gridRange{}, memoryError(http.StatusBadRequest, "Unable to parse range: %s", ref)
+		}
+	}
+
+	if startCol >= 0 {
+		gr.startCol = startCol
+	}
+	if startRow >= 0 {
+		gr.startRow = startRow
+	}
+	if endCol >= 0 {
+		gr.endCol = endCol + 1
+	}
+	if endRow >= 0 {
+		gr.endRow = endRow + 1
+	}
+	if (gr.endCol >= 0 && gr.endCol <= gr.startCol) || (gr.endRow >= 0 && gr.endRow <= gr.startRow) {
+		return gridRange{}, memoryError(http.StatusBadRequest, "Unable to parse range: %s", ref)
+	}
+	return gr, nil
+}
+
+// quoteSheetName quotes a sheet name only when it needs it, as Google does in
+// the ranges it returns.
+func quoteSheetName(name string) string {
+	if plainSheetName.MatchString(name) {
+		return name
+	}
+	return a1.QuoteSheetName(name)
+}
+
+func toCell(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	case bool:
+		return strings.ToUpper(strconv.FormatBool(value))
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+func newMemoryID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func memoryError(code int, format string, args ...interface{}) error {
+	return &googleapi.Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
@@ -0,0 +1,151 @@
+package svc
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+func newTestExecutor(cfg ExecutorConfig) (*Executor, *[]time.Duration, *time.Time) {
+	e := NewExecutor(cfg)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var slept []time.Duration
+	e.now = func() time.Time { return now }
+	e.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		now = now.Add(d)
+	}
+	for _, b := range e.buckets {
+		b.last = now
+	}
+	return e, &slept, &now
+}
+
+func failing(errs ...error) (func() (string, error), *int) {
+	calls := 0
+	return func() (string, error) {
+		calls++
+		if calls <= len(errs) {
+			return "", errs[calls-1]
+		}
+		return "ok", nil
+	}, &calls
+}
+
+func TestExecuteRetries(t *testing.T) {
+	cfg := ExecutorConfig{MaxRetries: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+
+	unavailable := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	rateLimited := &googleapi.Error{Code: http.StatusForbidden, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}
+	retryAfter := &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"7"}}}
+
+	e, slept, _ := newTestExecutor(cfg)
+	call, calls := failing(unavailable, rateLimited, retryAfter)
+	result, err := execute(e, "values.get", quotaRead, call)
+	if err != nil || result != "ok" || *calls != 4 {
+		t.Fatalf("Expected success after 4 calls, got %q, %v after %d calls", result, err, *calls)
+	}
+	if len(*slept) != 3 || (*slept)[0] > time.Second || (*slept)[1] > 2*time.Second || (*slept)[2] != 7*time.Second {
+		t.Errorf("Unexpected delays %v", *slept)
+	}
+
+	stats := e.Stats()["values.get"]
+	if stats.Calls != 4 || stats.Retries != 3 || stats.Failures != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestExecuteGivesUp(t *testing.T) {
+	cfg := ExecutorConfig{MaxRetries: 2, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	unavailable := &googleapi.Error{Code: http.StatusInternalServerError}
+
+	cases := []struct {
+		name  string
+		op    string
+		quota int
+		err   error
+		calls int
+	}{
+		{"out of retries", "values.update", quotaWrite, unavailable, 3},
+		{"5xx on append", "values.append", quotaWrite, unavailable, 1},
+		{"5xx on batch update", "spreadsheets.batchUpdate", quotaWrite, unavailable, 1},
+		{"not found", "values.get", quotaRead, &googleapi.Error{Code: http.StatusNotFound}, 1},
+		{"permission", "values.get", quotaRead, &googleapi.Error{Code: http.StatusForbidden}, 1},
+		{"retry after too long", "values.get", quotaRead, &googleapi.Error{Code: http.StatusTooManyRequests, Header: http.Header{"Retry-After": {"60"}}}, 1},
+		{"network error on read", "values.get", quotaRead, &url.Error{Op: "Get", URL: "https://sheets.googleapis.com", Err: errors.New("reset")}, 3},
+		{"network error on idempotent write", "values.batchClear", quotaWrite, &url.Error{Op: "Post", URL: "https://sheets.googleapis.com", Err: errors.New("reset")}, 3},
+		{"network error on write", "values.append", quotaWrite, &url.Error{Op: "Post", URL: "https://sheets.googleapis.com", Err: errors.New("reset")}, 1},
+	}
+
+	for _, c := range cases {
+		e, _, _ := newTestExecutor(cfg)
+		call, calls := failing(c.err, c.err, c.err, c.err)
+		_, err := execute(e, c.op, c.quota, call)
+		if !errors.Is(err, c.err) || *calls != c.calls {
+			t.Errorf("%s: expected %v after %d calls, got %v after %d calls", c.name, c.err, c.calls, err, *calls)
+		}
+		if stats := e.Stats()[c.op]; stats.Failures != 1 {
+			t.Errorf("%s: expected one failure, got %+v", c.name, stats)
+		}
+	}
+}
+
+func TestExecuteRetriesRateLimitedWrites(t *testing.T) {
+	cfg := ExecutorConfig{MaxRetries: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	e, _, _ := newTestExecutor(cfg)
+
+	// a 429 is refused before the rows are added, so even an append is retried
+	call, calls := failing(&googleapi.Error{Code: http.StatusTooManyRequests})
+	if _, err := execute(e, "values.append", quotaWrite, call); err != nil || *calls != 2 {
+		t.Errorf("Expected success after 2 calls, got %v after %d calls", err, *calls)
+	}
+}
+
+func TestExecuteThrottles(t *testing.T) {
+	e, slept, _ := newTestExecutor(ExecutorConfig{ReadPerMinute: 60})
+
+	for i := 0; i < 62; i++ {
+		execute(e, "values.get", quotaRead, func() (string, error) { return "", nil })
+	}
+
+	// the first minute of quota is spent without waiting, then one call per second
+	if len(*slept) != 2 || (*slept)[0] != time.Second || (*slept)[1] != time.Second {
+		t.Errorf("Unexpected waits %v", *slept)
+	}
+	if stats := e.Stats()["values.get"]; stats.Throttled != 2 || stats.Waited != 2 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	// writes have their own bucket and Drive calls are not throttled
+	execute(e, "files.list", quotaDrive, func() (string, error) { return "", nil })
+	if len(*slept) != 2 {
+		t.Errorf("Expected Drive calls not to wait, got %v", *slept)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		value string
+		wait  time.Duration
+		ok    bool
+	}{
+		{"", 0, false},
+		{"3", 3 * time.Second, true},
+		{now.Add(5 * time.Second).Format(http.TimeFormat), 5 * time.Second, true},
+		{now.Add(-5 * time.Second).Format(http.TimeFormat), 0, true},
+		{"soon", 0, false},
+	}
+
+	for _, c := range cases {
+		wait, ok := retryAfter(http.Header{"Retry-After": {c.value}}, now)
+		if wait != c.wait || ok != c.ok {
+			t.Errorf("retryAfter(%q) = %v, %v, expected %v, %v", c.value, wait, ok, c.wait, c.ok)
+		}
+	}
+}
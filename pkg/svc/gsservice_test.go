@@ -0,0 +1,51 @@
+package svc
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+type sequenceTokenSource struct {
+	tokens []*oauth2.Token
+	calls  int
+}
+
+func (s *sequenceTokenSource) Token() (*oauth2.Token, error) {
+	tok := s.tokens[s.calls]
+	if s.calls < len(s.tokens)-1 {
+		s.calls++
+	}
+	return tok, nil
+}
+
+func TestPersistingTokenSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token.json")
+	initial := &oauth2.Token{AccessToken: "first", RefreshToken: "refresh"}
+	rotated := &oauth2.Token{AccessToken: "second", RefreshToken: "refresh-2"}
+
+	source := &persistingTokenSource{
+		source:  &sequenceTokenSource{tokens: []*oauth2.Token{initial, rotated}},
+		path:    path,
+		current: initial,
+	}
+
+	if _, err := source.Token(); err != nil {
+		t.Fatalf("Token returned error: %v", err)
+	}
+	if _, err := tokenFromFile(path); err == nil {
+		t.Error("Expected token file not to be written when the token is unchanged")
+	}
+
+	if _, err := source.Token(); err != nil {
+		t.Fatalf("Token returned error: %v", err)
+	}
+	saved, err := tokenFromFile(path)
+	if err != nil {
+		t.Fatalf("Expected rotated token to be saved: %v", err)
+	}
+	if saved.AccessToken != "second" || saved.RefreshToken != "refresh-2" {
+		t.Errorf("Saved token mismatch: %+v", saved)
+	}
+}
@@ -0,0 +1,218 @@
+package svc
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+func newTestMemoryBackend() *MemoryBackend {
+	backend := NewMemoryBackend()
+	backend.Seed("ss", "Test", MemorySheet{
+		SheetID: 0,
+		Title:   "Sheet1",
+		Values: [][]interface{}{
+			{"ID", "Name"},
+			{"1", "a"},
+			{"2", "b"},
+		},
+	})
+	return backend
+}
+
+func TestParseA1(t *testing.T) {
+	cases := []struct {
+		in       string
+		expected gridRange
+	}{
+		{"Sheet1!A1:C4", gridRange{sheet: "Sheet1", startRow: 0, endRow: 4, startCol: 0, endCol: 3}},
+		{"Sheet1!B:C", gridRange{sheet: "Sheet1", startRow: 0, endRow: -1, startCol: 1, endCol: 3}},
+		{"Sheet1!2:5", gridRange{sheet: "Sheet1", startRow: 1, endRow: 5, startCol: 0, endCol: -1}},
+		{"'My Sheet'!AA10", gridRange{sheet: "My Sheet", startRow: 9, endRow: 10, startCol: 26, endCol: 27}},
+	}
+
+	for _, c := range cases {
+		gr, err := parseA1(c.in)
+		if err != nil {
+			t.Errorf("parseA1(%q) returned error: %v", c.in, err)
+			continue
+		}
+		if gr != c.expected {
+			t.Errorf("parseA1(%q) = %+v, expected %+v", c.in, gr, c.expected)
+		}
+	}
+
+	for _, in := range []string{"Sheet1!", "Sheet1!A-4:C-4", "Sheet1!@4:@4", "Sheet1!C1:A1", "Sheet1!A0", "Sheet1!B", "Sheet1!3"} {
+		if _, err := parseA1(in); err == nil {
+			t.Errorf("parseA1(%q) expected an error", in)
+		}
+	}
+}
+
+func TestMemoryBackendValues(t *testing.T) {
+	backend := newTestMemoryBackend()
+
+	_, err := backend.AppendValues("ss", "Sheet1!A:B", &sheets.ValueRange{Values: [][]interface{}{{3, "c"}}})
+	if err != nil {
+		t.Fatalf("AppendValues returned error: %v", err)
+	}
+
+	_, err = backend.UpdateValues("ss", "Sheet1!B2:B2", &sheets.ValueRange{Values: [][]interface{}{{"z"}}})
+	if err != nil {
+		t.Fatalf("UpdateValues returned error: %v", err)
+	}
+
+	_, err = backend.UpdateValues("ss", "Sheet1!B2:B2", &sheets.ValueRange{Values: [][]interface{}{{"x", "y"}}})
+	if err == nil {
+		t.Error("UpdateValues expected an error when writing outside the range")
+	}
+
+	_, err = backend.ClearValues("ss", "Sheet1!A3:B3")
+	if err != nil {
+		t.Fatalf("ClearValues returned error: %v", err)
+	}
+
+	vr, err := backend.GetValues("ss", "Sheet1")
+	if err != nil {
+		t.Fatalf("GetValues returned error: %v", err)
+	}
+
+	expected := [][]interface{}{{"ID", "Name"}, {"1", "z"}, {}, {"3", "c"}}
+	if len(vr.Values) != len(expected) {
+		t.Fatalf("Expected %d rows but got %d: %v", len(expected), len(vr.Values), vr.Values)
+	}
+	for i := range expected {
+		if len(vr.Values[i]) != len(expected[i]) {
+			t.Fatalf("Row %d: expected %v but got %v", i, expected[i], vr.Values[i])
+		}
+		for j := range expected[i] {
+			if vr.Values[i][j] != expected[i][j] {
+				t.Errorf("Cell %d,%d: expected %v but got %v", i, j, expected[i][j], vr.Values[i][j])
+			}
+		}
+	}
+}
+
+func TestMemoryBackendBatchUpdate(t *testing.T) {
+	backend := newTestMemoryBackend()
+
+	res, err := backend.BatchUpdate("ss", &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
+			{AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: "Other"}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BatchUpdate returned error: %v", err)
+	}
+	sheetID := res.Replies[0].AddSheet.Properties.SheetId
+
+	// a failing request must leave the spreadsheet untouched
+	_, err = backend.BatchUpdate("ss", &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
+			{UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{
+				Properties: &sheets.SheetProperties{SheetId: sheetID, Title: "Renamed"},
+				Fields:     "title",
+			}},
+			{DeleteSheet: &sheets.DeleteSheetRequest{SheetId: 999}},
+		},
+	})
+	if err == nil {
+		t.Fatal("BatchUpdate expected an error for an unknown sheet")
+	}
+
+	spreadsheet, _ := backend.GetSpreadsheet("ss")
+	if title := spreadsheet.Sheets[1].Properties.Title; title != "Other" {
+		t.Errorf("Expected sheet title %q but got %q", "Other", title)
+	}
+
+	_, err = backend.GetSpreadsheet("missing")
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusNotFound {
+		t.Errorf("Expected a 404 googleapi.Error but got %v", err)
+	}
+}
+
+func TestMemoryBackendBatchValues(t *testing.T) {
+	backend := newTestMemoryBackend()
+
+	// a range that does not fit fails the whole request
+	_, err := backend.BatchUpdateValues("ss", &sheets.BatchUpdateValuesRequest{Data: []*sheets.ValueRange{
+		{Range: "Sheet1!A2:B2", Values: [][]interface{}{{"9", "z"}}},
+		{Range: "Sheet1!A3:A3", Values: [][]interface{}{{"8", "y"}}},
+	}})
+	if err == nil {
+		t.Fatalf("Expected an error for a value outside its range")
+	}
+	values, _ := backend.GetValues("ss", "Sheet1")
+	if values.Values[1][0] != "1" {
+		t.Errorf("Expected no row to change but got %v", values.Values)
+	}
+
+	updated, err := backend.BatchUpdateValues("ss", &sheets.BatchUpdateValuesRequest{Data: []*sheets.ValueRange{
+		{Range: "Sheet1!A2:B2", Values: [][]interface{}{{"9", "z"}}},
+		{Range: "Sheet1!B3", Values: [][]interface{}{{"y"}}},
+	}})
+	if err != nil {
+		t.Fatalf("BatchUpdateValues returned error: %v", err)
+	}
+	if updated.TotalUpdatedCells != 3 || updated.TotalUpdatedRows != 2 || updated.TotalUpdatedSheets != 1 || len(updated.Responses) != 2 {
+		t.Errorf("Unexpected response %+v", updated)
+	}
+
+	cleared, err := backend.BatchClearValues("ss", &sheets.BatchClearValuesRequest{Ranges: []string{"Sheet1!A2:B2", "Sheet1!B3"}})
+	if err != nil {
+		t.Fatalf("BatchClearValues returned error: %v", err)
+	}
+	if len(cleared.ClearedRanges) != 2 {
+		t.Errorf("Unexpected response %+v", cleared)
+	}
+	values, _ = backend.GetValues("ss", "Sheet1")
+	if len(values.Values[1]) != 0 || len(values.Values[2]) != 1 || values.Values[2][0] != "2" {
+		t.Errorf("Expected cleared cells but got %v", values.Values)
+	}
+
+	if _, err := backend.BatchClearValues("ss", &sheets.BatchClearValuesRequest{Ranges: []string{"Sheet1!A3", "Missing!A1"}}); err == nil {
+		t.Errorf("Expected an error for an unknown sheet")
+	}
+	values, _ = backend.GetValues("ss", "Sheet1")
+	if values.Values[2][0] != "2" {
+		t.Errorf("Expected no cell to be cleared but got %v", values.Values)
+	}
+}
+
+func TestMemoryBackendDeleteDimension(t *testing.T) {
+	backend := newTestMemoryBackend()
+
+	_, err := backend.BatchUpdate("ss", &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
+			{DeleteDimension: &sheets.DeleteDimensionRequest{Range: &sheets.DimensionRange{
+				SheetId: 0, Dimension: "ROWS", StartIndex: 1, EndIndex: 2,
+			}}},
+			{DeleteDimension: &sheets.DeleteDimensionRequest{Range: &sheets.DimensionRange{
+				SheetId: 0, Dimension: "COLUMNS", StartIndex: 0, EndIndex: 1,
+			}}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("BatchUpdate returned error: %v", err)
+	}
+
+	values, _ := backend.GetValues("ss", "Sheet1")
+	if len(values.Values) != 2 || len(values.Values[1]) != 1 || values.Values[1][0] != "b" {
+		t.Errorf("Expected the first row and column to be removed but got %v", values.Values)
+	}
+
+	_, err = backend.BatchUpdate("ss", &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{
+			{DeleteDimension: &sheets.DeleteDimensionRequest{Range: &sheets.DimensionRange{
+				SheetId: 0, Dimension: "ROWS", StartIndex: 2, EndIndex: 2,
+			}}},
+		},
+	})
+	if err == nil {
+		t.Errorf("Expected an error for an empty range")
+	}
+}
@@ -0,0 +1,55 @@
+// Package svctest provides an in-memory backend seeded with the spreadsheets
+// the handler tests expect, so they run without Google credentials.
+package svctest
+
+import "personnel-api/pkg/svc"
+
+const (
+	SpreadsheetID       = "13e2IAKNmZuj1asSc8qDKWhGC1OYRDxOEpUxEDPQpw8w"
+	SecondSpreadsheetID = "1Y6NRaduDsw_Wxu0yEhomYWXeCjBteFlnovj7TiPAyM8"
+	TitleSpreadsheetID  = "test-spreadsheet-id"
+	ExtraSheetID        = 123456
+)
+
+// NewBackend returns a MemoryBackend populated with the test fixtures.
+func NewBackend() *svc.MemoryBackend {
+	backend := svc.NewMemoryBackend()
+
+	backend.Seed(SpreadsheetID, "Personnel",
+		svc.MemorySheet{
+			SheetID: 0,
+			Title:   "Sheet1",
+			Values: [][]interface{}{
+				{"ID", "Name", "Email"},
+				{"1", "test1", "test1@gmail.com"},
+				{"2", "test2", "test2@gmail.com"},
+				{"3", "test3", "test3@gmail.com"},
+				{"4", "test4", "test4@gmail.com"},
+			},
+		},
+		svc.MemorySheet{
+			SheetID: 1,
+			Title:   "Sheet2",
+			Values: [][]interface{}{
+				{"ID", "Name", "Score"},
+				{"1", "test1", "9.8"},
+				{"2", "test2", "8.5"},
+				{"3", "test3", "9.9"},
+			},
+		},
+		svc.MemorySheet{
+			SheetID: ExtraSheetID,
+			Title:   "Extra",
+		},
+	)
+
+	backend.Seed(SecondSpreadsheetID, "Spreadsheet to delete",
+		svc.MemorySheet{SheetID: 0, Title: "Sheet1"},
+	)
+
+	backend.Seed(TitleSpreadsheetID, "Spreadsheet to rename",
+		svc.MemorySheet{SheetID: 0, Title: "Sheet1"},
+	)
+
+	return backend
+}
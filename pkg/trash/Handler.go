@@ -0,0 +1,161 @@
+package trash
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/authorization"
+)
+
+/*
+GET
+Query params: spreadsheetID=YOUR_SPREAD_SHEET_ID
+Without spreadsheetID the trashed items of every spreadsheet are listed. Only
+the items the caller may list, by the spreadsheet and sheet they came from,
+are returned.
+*/
+func ListTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	items := []*Item{}
+	for _, item := range Default().List(r.URL.Query().Get("spreadsheetID")) {
+		allowed, err := authorization.Allowed(r, item.SpreadsheetID, item.SheetName())
+		if err != nil {
+			apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, err.Error())
+			return
+		}
+		if allowed {
+			items = append(items, item)
+		}
+	}
+
+	response := struct {
+		Items []*Item `json:"items"`
+	}{Items: items}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+/*
+POST
+Body: {"id": "TRASH_ITEM_ID"}
+The caller must be allowed to call RestoreTrash on the spreadsheet and sheet
+the item came from.
+*/
+func RestoreTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id, ok := allowedItemID(w, r)
+	if !ok {
+		return
+	}
+
+	item, err := RestoreHelper(id)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Cannot restore item")
+		return
+	}
+
+	writeItemResponse(w, item, "Restore successfully!")
+}
+
+/*
+DELETE
+Body: {"id": "TRASH_ITEM_ID"}
+The caller must be allowed to call PurgeTrash on the spreadsheet and sheet the
+item came from.
+*/
+func PurgeTrash(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		apierror.Write(w, http.StatusMethodNotAllowed, apierror.CodeMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	id, ok := allowedItemID(w, r)
+	if !ok {
+		return
+	}
+
+	item, err := PurgeHelper(id)
+	if err != nil {
+		apierror.WriteFrom(w, err, http.StatusInternalServerError, apierror.CodeInternal, "Cannot purge item")
+		return
+	}
+
+	writeItemResponse(w, item, "Purge successfully!")
+}
+
+// allowedItemID reads the item ID of the request and checks the caller
+// against the spreadsheet and sheet of the item, as Authorize only sees the
+// target named in the request.
+func allowedItemID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	id, ok := itemID(w, r)
+	if !ok {
+		return "", false
+	}
+
+	item := Default().Get(id)
+	if item == nil {
+		apierror.Write(w, http.StatusNotFound, apierror.CodeNotFound, "Trash item not found")
+		return "", false
+	}
+	allowed, err := authorization.Allowed(r, item.SpreadsheetID, item.SheetName())
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, err.Error())
+		return "", false
+	}
+	if !allowed {
+		apierror.Write(w, http.StatusForbidden, apierror.CodePermissionDenied, "Forbidden")
+		return "", false
+	}
+	return id, true
+}
+
+func itemID(w http.ResponseWriter, r *http.Request) (string, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		apierror.Write(w, http.StatusInternalServerError, apierror.CodeInternal, "Failed to read request body")
+		return "", false
+	}
+
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "Failed to parse request body")
+		return "", false
+	}
+	if req.ID == "" {
+		apierror.Write(w, http.StatusBadRequest, apierror.CodeInvalidRequest, "id field is required")
+		return "", false
+	}
+	return req.ID, true
+}
+
+func writeItemResponse(w http.ResponseWriter, item *Item, message string) {
+	response := struct {
+		ID            string `json:"id"`
+		Kind          string `json:"kind"`
+		SpreadsheetID string `json:"spreadsheetID"`
+		Title         string `json:"title"`
+		Message       string `json:"message"`
+	}{
+		ID:            item.ID,
+		Kind:          item.Kind,
+		SpreadsheetID: item.SpreadsheetID,
+		Title:         item.Title,
+		Message:       message,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
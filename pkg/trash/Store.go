@@ -0,0 +1,253 @@
+// Package trash keeps the spreadsheets and sheets removed through the API so
+// they can be restored until they are purged, by hand or once their retention
+// period has passed. Spreadsheets stay in the Drive trash while listed here;
+// sheets are kept as a snapshot of their properties and values.
+package trash
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"personnel-api/pkg/keylock"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+const (
+	KindSpreadsheet = "spreadsheet"
+	KindSheet       = "sheet"
+
+	DefaultRetention = 30 * 24 * time.Hour
+)
+
+// Item is one trashed spreadsheet or sheet. Properties and Values are only
+// set for sheets.
+type Item struct {
+	ID            string                  `json:"id"`
+	Kind          string                  `json:"kind"`
+	SpreadsheetID string                  `json:"spreadsheetID"`
+	Title         string                  `json:"title"`
+	Properties    *sheets.SheetProperties `json:"properties,omitempty"`
+	Values        [][]interface{}         `json:"values,omitempty"`
+	DeletedAt     time.Time               `json:"deletedAt"`
+	ExpiresAt     *time.Time              `json:"expiresAt,omitempty"`
+}
+
+// SheetName returns the title of a trashed sheet, or "" for a spreadsheet,
+// the sheet its policies are checked against.
+func (item *Item) SheetName() string {
+	if item.Kind == KindSheet {
+		return item.Title
+	}
+	return ""
+}
+
+// Store holds the trashed items and saves them as a JSON list to its file, if
+// it has one, on every change.
+type Store struct {
+	path      string
+	retention time.Duration
+	mu        sync.RWMutex
+	items     map[string]*Item
+
+	// locks serialises restoring and purging each item
+	locks keylock.Locks
+}
+
+// NewStore loads the items saved at path. A missing file is an empty store
+// and an empty path keeps the items in memory only. Items added later expire
+// after retention, or never if it is 0.
+func NewStore(path string, retention time.Duration) (*Store, error) {
+	s := &Store{path: path, retention: retention, items: map[string]*Item{}}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read trash file: %v", err)
+	}
+
+	var list []*Item
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("unable to parse trash file: %v", err)
+	}
+	for _, item := range list {
+		s.items[item.ID] = item
+	}
+	return s, nil
+}
+
+// NewStoreFromEnv loads the file named by TRASH_FILE (default trash.json)
+// with the retention given by TRASH_RETENTION, a duration such as 720h where
+// 0 keeps items until they are purged by hand.
+func NewStoreFromEnv() (*Store, error) {
+	path := os.Getenv("TRASH_FILE")
+	if path == "" {
+		path = "trash.json"
+	}
+
+	retention := DefaultRetention
+	if s := os.Getenv("TRASH_RETENTION"); s != "" {
+		var err error
+		if retention, err = time.ParseDuration(s); err != nil || retention < 0 {
+			return nil, fmt.Errorf("invalid TRASH_RETENTION %q", s)
+		}
+	}
+	return NewStore(path, retention)
+}
+
+var (
+	defaultMu    sync.RWMutex
+	defaultStore = &Store{retention: DefaultRetention, items: map[string]*Item{}}
+)
+
+// SetDefault replaces the store returned by Default.
+func SetDefault(s *Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = s
+}
+
+// Default returns the store used by the delete helpers and trash handlers.
+// It keeps items in memory until SetDefault is called.
+func Default() *Store {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultStore
+}
+
+// Add assigns item an ID, deletion time and expiry and saves it.
+func (s *Store) Add(item *Item) error {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	item.ID = hex.EncodeToString(id)
+	item.DeletedAt = time.Now().UTC()
+	if s.retention > 0 {
+		expires := item.DeletedAt.Add(s.retention)
+		item.ExpiresAt = &expires
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items[item.ID] = item
+	if err := s.save(); err != nil {
+		delete(s.items, item.ID)
+		return err
+	}
+	return nil
+}
+
+// Get returns the item with the given ID or nil.
+func (s *Store) Get(id string) *Item {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.items[id]
+}
+
+// List returns the items of a spreadsheet, or of every spreadsheet when
+// spreadsheetID is empty, most recently deleted first.
+func (s *Store) List(spreadsheetID string) []*Item {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []*Item{}
+	for _, item := range s.items {
+		if spreadsheetID == "" || item.SpreadsheetID == spreadsheetID {
+			list = append(list, item)
+		}
+	}
+	sortItems(list)
+	return list
+}
+
+// Expired returns the items whose retention ended before now.
+func (s *Store) Expired(now time.Time) []*Item {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := []*Item{}
+	for _, item := range s.items {
+		if item.ExpiresAt != nil && item.ExpiresAt.Before(now) {
+			list = append(list, item)
+		}
+	}
+	sortItems(list)
+	return list
+}
+
+// Remove drops an item and reports whether the store had it.
+func (s *Store) Remove(id string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.items[id]
+	if !ok {
+		return false, nil
+	}
+	delete(s.items, id)
+	if err := s.save(); err != nil {
+		s.items[id] = item
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes the items to a temporary file and renames it over the store
+// file, so a failed write never leaves a truncated file behind.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	list := make([]*Item, 0, len(s.items))
+	for _, item := range s.items {
+		list = append(list, item)
+	}
+	sortItems(list)
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".trash-*")
+	if err != nil {
+		return fmt.Errorf("unable to save trash: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to save trash: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("unable to save trash: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("unable to save trash: %v", err)
+	}
+	return nil
+}
+
+func sortItems(list []*Item) {
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].DeletedAt.Equal(list[j].DeletedAt) {
+			return list[i].DeletedAt.After(list[j].DeletedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+}
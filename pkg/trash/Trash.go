@@ -0,0 +1,172 @@
+package trash
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/cache"
+	"personnel-api/pkg/svc"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// RestoreHelper puts a trashed item back and removes it from the trash. A
+// spreadsheet is taken out of the Drive trash. A sheet is added again with its
+// saved properties and values; it gets a new sheet ID if its old one has been
+// reused, and a sheet with the same title makes the restore fail with 409.
+// An item is restored or purged by one call at a time, so a restore and the
+// purger never act on the same item together.
+func RestoreHelper(id string) (*Item, error) {
+	store := Default()
+	defer store.locks.Lock(id)()
+
+	item := store.Get(id)
+	if item == nil {
+		return nil, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "trash item %s not found", id)
+	}
+
+	var err error
+	switch item.Kind {
+	case KindSpreadsheet:
+		err = svc.GetBackend().SetTrashed(item.SpreadsheetID, false)
+	case KindSheet:
+		err = restoreSheet(item)
+	default:
+		err = fmt.Errorf("unknown trash item kind %q", item.Kind)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := store.Remove(id); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func restoreSheet(item *Item) error {
+	defer cache.Default().InvalidateSpreadsheet(item.SpreadsheetID)
+
+	backend := svc.GetBackend()
+	spreadsheet, err := backend.GetSpreadsheet(item.SpreadsheetID)
+	if err != nil {
+		return err
+	}
+
+	props := *item.Properties
+	for _, sheet := range spreadsheet.Sheets {
+		if sheet.Properties == nil {
+			continue
+		}
+		if strings.EqualFold(sheet.Properties.Title, props.Title) {
+			return apierror.New(http.StatusConflict, apierror.CodeConflict, "a sheet named %q already exists", props.Title)
+		}
+		if sheet.Properties.SheetId == props.SheetId {
+			props.SheetId = 0
+		}
+	}
+	if props.Index > int64(len(spreadsheet.Sheets)) {
+		props.Index = int64(len(spreadsheet.Sheets))
+	}
+
+	resp, err := backend.BatchUpdate(item.SpreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{AddSheet: &sheets.AddSheetRequest{Properties: &props}}},
+	})
+	if err != nil {
+		return err
+	}
+	if len(item.Values) == 0 {
+		return nil
+	}
+
+	_, err = backend.UpdateValues(item.SpreadsheetID, props.Title, &sheets.ValueRange{Values: item.Values})
+	if err != nil {
+		// remove the empty sheet so the restore can be retried
+		if len(resp.Replies) > 0 && resp.Replies[0].AddSheet != nil {
+			backend.BatchUpdate(item.SpreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+				Requests: []*sheets.Request{{DeleteSheet: &sheets.DeleteSheetRequest{SheetId: resp.Replies[0].AddSheet.Properties.SheetId}}},
+			})
+		}
+		return err
+	}
+	return nil
+}
+
+// PurgeHelper removes a trashed item for good. A spreadsheet is deleted from
+// Drive, where a file already gone counts as purged.
+func PurgeHelper(id string) (*Item, error) {
+	store := Default()
+	defer store.locks.Lock(id)()
+
+	item := store.Get(id)
+	if item == nil {
+		return nil, apierror.New(http.StatusNotFound, apierror.CodeNotFound, "trash item %s not found", id)
+	}
+
+	if item.Kind == KindSpreadsheet {
+		err := svc.GetBackend().DeleteFile(item.SpreadsheetID)
+		if err != nil && apierror.From(err, http.StatusInternalServerError, apierror.CodeInternal, "").Status != http.StatusNotFound {
+			return nil, err
+		}
+		cache.Default().InvalidateSpreadsheet(item.SpreadsheetID)
+	}
+
+	if _, err := store.Remove(id); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// PurgeExpired purges every item whose retention ended before now and
+// returns how many were purged. It carries on past items that fail.
+func PurgeExpired(now time.Time) (int, error) {
+	purged := 0
+	var firstErr error
+	for _, item := range Default().Expired(now) {
+		if _, err := PurgeHelper(item.ID); err != nil {
+			// restored or purged by hand since it was listed
+			if apierror.From(err, http.StatusInternalServerError, apierror.CodeInternal, "").Status == http.StatusNotFound {
+				continue
+			}
+			if firstErr == nil {
+				firstErr = fmt.Errorf("unable to purge %s %s: %w", item.Kind, item.ID, err)
+			}
+			continue
+		}
+		purged++
+	}
+	return purged, firstErr
+}
+
+// StartPurger runs PurgeExpired every interval in the background until the
+// returned function is called.
+func StartPurger(interval time.Duration) func() {
+	ticker := time.NewTicker(interval)
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				purged, err := PurgeExpired(now)
+				if purged > 0 {
+					log.Printf("trash: purged %d expired items", purged)
+				}
+				if err != nil {
+					log.Printf("trash: %v", err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		ticker.Stop()
+		close(done)
+	}
+}
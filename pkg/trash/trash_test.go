@@ -0,0 +1,264 @@
+package trash
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"personnel-api/pkg/apierror"
+	"personnel-api/pkg/authorization"
+	"personnel-api/pkg/svc"
+	"personnel-api/pkg/svc/svctest"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"google.golang.org/api/sheets/v4"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "trash.json")
+	store, err := NewStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewStore returned error: %v", err)
+	}
+
+	item := &Item{Kind: KindSheet, SpreadsheetID: "ss", Title: "Sheet1", Values: [][]interface{}{{"ID"}}}
+	if err := store.Add(item); err != nil {
+		t.Fatalf("Add returned error: %v", err)
+	}
+	if item.ID == "" || item.ExpiresAt == nil || !item.ExpiresAt.Equal(item.DeletedAt.Add(time.Hour)) {
+		t.Errorf("Expected an ID and an expiry an hour after deletion but got %+v", item)
+	}
+
+	reloaded, err := NewStore(path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewStore returned error: %v", err)
+	}
+	if got := reloaded.Get(item.ID); got == nil || got.Title != "Sheet1" {
+		t.Fatalf("Expected the saved item to be loaded but got %+v", got)
+	}
+	if len(reloaded.List("ss")) != 1 || len(reloaded.List("other")) != 0 {
+		t.Errorf("Unexpected list %v", reloaded.List(""))
+	}
+	if len(reloaded.Expired(time.Now())) != 0 || len(reloaded.Expired(time.Now().Add(2*time.Hour))) != 1 {
+		t.Errorf("Expected the item to expire after an hour")
+	}
+
+	if removed, err := reloaded.Remove(item.ID); !removed || err != nil {
+		t.Errorf("Remove returned %v, %v", removed, err)
+	}
+	if again, _ := NewStore(path, time.Hour); again.Get(item.ID) != nil {
+		t.Errorf("Expected the removal to be saved")
+	}
+
+	forever, _ := NewStore("", 0)
+	forever.Add(&Item{Kind: KindSheet, SpreadsheetID: "ss"})
+	if len(forever.Expired(time.Now().Add(24*365*time.Hour))) != 0 {
+		t.Errorf("Expected items never to expire without a retention")
+	}
+}
+
+func TestRestoreAndPurge(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+	previous := Default()
+	store, _ := NewStore("", time.Hour)
+	SetDefault(store)
+	defer SetDefault(previous)
+	setEnforcer(t, []interface{}{"alice", "/*", "*", "*", "*"})
+
+	post := func(handler http.HandlerFunc, method string, id string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		handler(res, newRequest(method, "/", "alice", `{"id": "`+id+`"}`))
+		return res
+	}
+
+	// trash Sheet2 the way DeleteSheetHelper does
+	spreadsheet, _ := backend.GetSpreadsheet(svctest.SpreadsheetID)
+	props := spreadsheet.Sheets[1].Properties
+	values, _ := backend.GetValues(svctest.SpreadsheetID, "Sheet2")
+	sheetItem := &Item{Kind: KindSheet, SpreadsheetID: svctest.SpreadsheetID, Title: props.Title, Properties: props, Values: values.Values}
+	store.Add(sheetItem)
+	backend.BatchUpdate(svctest.SpreadsheetID, &sheets.BatchUpdateSpreadsheetRequest{
+		Requests: []*sheets.Request{{DeleteSheet: &sheets.DeleteSheetRequest{SheetId: props.SheetId}}},
+	})
+
+	res := post(RestoreTrash, http.MethodPost, sheetItem.ID)
+	if res.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+	restored, err := backend.GetValues(svctest.SpreadsheetID, "Sheet2")
+	if err != nil || !reflect.DeepEqual(restored.Values, values.Values) {
+		t.Errorf("Expected Sheet2 to be restored but got %v, %v", restored, err)
+	}
+	if store.Get(sheetItem.ID) != nil {
+		t.Errorf("Expected the restored item to leave the trash")
+	}
+
+	// restoring over a sheet with the same title is refused
+	store.Add(sheetItem)
+	if res := post(RestoreTrash, http.MethodPost, sheetItem.ID); res.Code != http.StatusConflict {
+		t.Errorf("Expected status code %d but got %d", http.StatusConflict, res.Code)
+	}
+	if res := post(PurgeTrash, http.MethodDelete, sheetItem.ID); res.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d", http.StatusOK, res.Code)
+	}
+
+	backend.SetTrashed(svctest.SecondSpreadsheetID, true)
+	spreadsheetItem := &Item{Kind: KindSpreadsheet, SpreadsheetID: svctest.SecondSpreadsheetID}
+	store.Add(spreadsheetItem)
+
+	if purged, err := PurgeExpired(time.Now()); purged != 0 || err != nil {
+		t.Errorf("Expected nothing to expire yet but got %d, %v", purged, err)
+	}
+	if purged, err := PurgeExpired(time.Now().Add(2 * time.Hour)); purged != 1 || err != nil {
+		t.Errorf("Expected the spreadsheet to be purged but got %d, %v", purged, err)
+	}
+	if _, err := backend.GetSpreadsheet(svctest.SecondSpreadsheetID); err == nil {
+		t.Errorf("Expected the purged spreadsheet to be deleted")
+	}
+
+	if res := post(RestoreTrash, http.MethodPost, "missing"); res.Code != http.StatusNotFound {
+		t.Errorf("Expected status code %d but got %d", http.StatusNotFound, res.Code)
+	}
+}
+
+func TestTrashAuthorization(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+	previous := Default()
+	store, _ := NewStore("", time.Hour)
+	SetDefault(store)
+	defer SetDefault(previous)
+	setEnforcer(t,
+		[]interface{}{"bob", "/ListTrash", "GET", svctest.SpreadsheetID, "Sheet2"},
+		[]interface{}{"bob", "/RestoreTrash", "POST", svctest.SpreadsheetID, "Sheet2"},
+		[]interface{}{"bob", "/PurgeTrash", "DELETE", svctest.SpreadsheetID, "Sheet2"},
+	)
+
+	allowed := &Item{Kind: KindSheet, SpreadsheetID: svctest.SpreadsheetID, Title: "Sheet2"}
+	other := &Item{Kind: KindSheet, SpreadsheetID: svctest.SpreadsheetID, Title: "Extra"}
+	spreadsheet := &Item{Kind: KindSpreadsheet, SpreadsheetID: svctest.SecondSpreadsheetID}
+	for _, item := range []*Item{allowed, other, spreadsheet} {
+		store.Add(item)
+	}
+
+	// bob only sees and acts on the items of Sheet2, whatever target he names
+	res := httptest.NewRecorder()
+	ListTrash(res, newRequest(http.MethodGet, "/ListTrash", "bob", ""))
+	var list struct {
+		Items []*Item `json:"items"`
+	}
+	json.Unmarshal(res.Body.Bytes(), &list)
+	if len(list.Items) != 1 || list.Items[0].ID != allowed.ID {
+		t.Errorf("Expected only the Sheet2 item to be listed but got %+v", list.Items)
+	}
+
+	for _, item := range []*Item{other, spreadsheet} {
+		body := `{"id": "` + item.ID + `", "spreadsheetID": "` + svctest.SpreadsheetID + `", "sheetName": "Sheet2"}`
+		res = httptest.NewRecorder()
+		RestoreTrash(res, newRequest(http.MethodPost, "/RestoreTrash", "bob", body))
+		if res.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d restoring %s but got %d", http.StatusForbidden, item.Title, res.Code)
+		}
+		res = httptest.NewRecorder()
+		PurgeTrash(res, newRequest(http.MethodDelete, "/PurgeTrash", "bob", body))
+		if res.Code != http.StatusForbidden {
+			t.Errorf("Expected status code %d purging %s but got %d", http.StatusForbidden, item.Title, res.Code)
+		}
+		if store.Get(item.ID) == nil {
+			t.Errorf("Expected %s to stay in the trash", item.Title)
+		}
+	}
+
+	res = httptest.NewRecorder()
+	PurgeTrash(res, newRequest(http.MethodDelete, "/PurgeTrash", "bob", `{"id": "`+allowed.ID+`"}`))
+	if res.Code != http.StatusOK {
+		t.Errorf("Expected status code %d but got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+}
+
+func TestRestoreDuringPurge(t *testing.T) {
+	backend := svctest.NewBackend()
+	svc.SetBackend(backend)
+	previous := Default()
+	store, _ := NewStore("", time.Hour)
+	SetDefault(store)
+	defer SetDefault(previous)
+
+	backend.SetTrashed(svctest.SecondSpreadsheetID, true)
+	item := &Item{Kind: KindSpreadsheet, SpreadsheetID: svctest.SecondSpreadsheetID}
+	store.Add(item)
+
+	// only one of a restore and a purge of the same item acts on it
+	var wg sync.WaitGroup
+	var restoreErr, purgeErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, restoreErr = RestoreHelper(item.ID)
+	}()
+	go func() {
+		defer wg.Done()
+		_, purgeErr = PurgeExpired(time.Now().Add(2 * time.Hour))
+	}()
+	wg.Wait()
+
+	if restoreErr == nil {
+		if _, err := backend.GetSpreadsheet(svctest.SecondSpreadsheetID); err != nil {
+			t.Errorf("Expected the restored spreadsheet to be kept but got %v", err)
+		}
+	} else if apierror.From(restoreErr, 0, "", "").Status != http.StatusNotFound {
+		t.Errorf("Expected the restore to find the item purged but got %v", restoreErr)
+	}
+	if purgeErr != nil {
+		t.Errorf("Expected the purger to skip a restored item but got %v", purgeErr)
+	}
+	if store.Get(item.ID) != nil {
+		t.Errorf("Expected the item to leave the trash")
+	}
+}
+
+// setEnforcer installs an enforcer holding the given policies for the test.
+func setEnforcer(t *testing.T, policies ...[]interface{}) {
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act, spreadsheet, sheet
+
+[policy_definition]
+p = sub, obj, act, spreadsheet, sheet
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && keyMatch(r.obj, p.obj) && (p.act == "*" || r.act == p.act) && keyMatch(r.spreadsheet, p.spreadsheet) && keyMatch(r.sheet, p.sheet)
+`)
+	if err != nil {
+		t.Fatalf("Failed to load model: %v", err)
+	}
+	enforcer, err := casbin.NewSyncedEnforcer(m)
+	if err != nil {
+		t.Fatalf("Failed to create enforcer: %v", err)
+	}
+	for _, policy := range policies {
+		enforcer.AddPolicy(policy...)
+	}
+	authorization.SetEnforcer(enforcer)
+	t.Cleanup(func() { authorization.SetEnforcer(nil) })
+}
+
+// newRequest returns a request made by principal, as Authorize passes it on.
+func newRequest(method string, target string, principal string, body string) *http.Request {
+	req := httptest.NewRequest(method, target, bytes.NewReader([]byte(body)))
+	return req.WithContext(authorization.WithPrincipal(req.Context(), principal))
+}
@@ -0,0 +1,185 @@
+// Package xlsx writes workbooks with a single worksheet in the Office Open
+// XML format read by Excel, LibreOffice and Google Sheets. Rows are written to
+// the underlying writer as they come, so a large sheet is never held twice in
+// memory. Only values are written; there are no styles or formulas.
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"personnel-api/pkg/a1"
+)
+
+const ContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// maxSheetName is the longest sheet name Excel accepts.
+const maxSheetName = 31
+
+const contentTypesXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`
+
+const rootRelsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`
+
+const workbookXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="%s" sheetId="1" r:id="rId1"/></sheets></workbook>`
+
+const workbookRelsXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`
+
+const sheetHeaderXML = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`
+
+const sheetFooterXML = `</sheetData></worksheet>`
+
+// Writer writes the rows of one worksheet. Close must be called to finish the
+// workbook.
+type Writer struct {
+	zw    *zip.Writer
+	sheet io.Writer
+	rows  int
+	err   error
+}
+
+// NewWriter starts a workbook on w whose only worksheet is called sheetName.
+func NewWriter(w io.Writer, sheetName string) (*Writer, error) {
+	zw := zip.NewWriter(w)
+
+	var name strings.Builder
+	xml.EscapeText(&name, []byte(SheetName(sheetName)))
+
+	parts := []struct {
+		name    string
+		content string
+	}{
+		{"[Content_Types].xml", contentTypesXML},
+		{"_rels/.rels", rootRelsXML},
+		{"xl/workbook.xml", fmt.Sprintf(workbookXML, name.String())},
+		{"xl/_rels/workbook.xml.rels", workbookRelsXML},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := zw.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheet, sheetHeaderXML); err != nil {
+		return nil, err
+	}
+
+	return &Writer{zw: zw, sheet: sheet}, nil
+}
+
+// WriteRow appends a row. Numbers and booleans keep their type, and strings
+// holding a plain decimal number are written as numbers too; everything else
+// is written as text. Empty cells are skipped.
+func (w *Writer) WriteRow(cells []interface{}) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	w.rows++
+	var row strings.Builder
+	fmt.Fprintf(&row, `<row r="%d">`, w.rows)
+	for i, cell := range cells {
+		writeCell(&row, a1.ColumnLetter(i)+strconv.Itoa(w.rows), cell)
+	}
+	row.WriteString("</row>")
+
+	_, w.err = io.WriteString(w.sheet, row.String())
+	return w.err
+}
+
+// Close ends the worksheet and writes the zip directory. It does not close
+// the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	if _, err := io.WriteString(w.sheet, sheetFooterXML); err != nil {
+		return err
+	}
+	return w.zw.Close()
+}
+
+// SheetName makes name acceptable as an Excel sheet name: the characters
+// : \ / ? * [ ] are replaced and it is cut to 31 characters.
+func SheetName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`:\/?*[]`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > maxSheetName {
+		name = string(runes[:maxSheetName])
+	}
+	if strings.TrimSpace(name) == "" {
+		return "Sheet1"
+	}
+	return name
+}
+
+func writeCell(b *strings.Builder, ref string, value interface{}) {
+	switch v := value.(type) {
+	case nil:
+		return
+	case bool:
+		fmt.Fprintf(b, `<c r="%s" t="b"><v>%d</v></c>`, ref, boolToInt(v))
+		return
+	case float64:
+		if !math.IsNaN(v) && !math.IsInf(v, 0) {
+			fmt.Fprintf(b, `<c r="%s"><v>%s</v></c>`, ref, strconv.FormatFloat(v, 'f', -1, 64))
+			return
+		}
+	case int, int32, int64:
+		fmt.Fprintf(b, `<c r="%s"><v>%d</v></c>`, ref, v)
+		return
+	case json.Number:
+		if _, err := v.Float64(); err == nil {
+			fmt.Fprintf(b, `<c r="%s"><v>%s</v></c>`, ref, v)
+			return
+		}
+	case string:
+		if v == "" {
+			return
+		}
+		if isNumber(v) {
+			fmt.Fprintf(b, `<c r="%s"><v>%s</v></c>`, ref, v)
+			return
+		}
+	}
+
+	fmt.Fprintf(b, `<c r="%s" t="inlineStr"><is><t xml:space="preserve">`, ref)
+	xml.EscapeText(b, []byte(fmt.Sprint(value)))
+	b.WriteString(`</t></is></c>`)
+}
+
+// isNumber reports whether s is a number written the way it would be read
+// back, so values such as "007" or "1e3" stay text.
+func isNumber(s string) bool {
+	f, err := strconv.ParseFloat(s, 64)
+	return err == nil && !math.IsInf(f, 0) && strconv.FormatFloat(f, 'f', -1, 64) == s
+}
+
+func boolToInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
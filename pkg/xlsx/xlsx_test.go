@@ -0,0 +1,70 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, "Q1/Q2")
+	if err != nil {
+		t.Fatalf("NewWriter returned error: %v", err)
+	}
+	w.WriteRow([]interface{}{"Name", "Score", "Code"})
+	w.WriteRow([]interface{}{"<b>&", "9.5", "007", nil, true, 3.0})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close returned error: %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("Expected a zip archive: %v", err)
+	}
+	parts := map[string]string{}
+	for _, f := range archive.File {
+		rc, _ := f.Open()
+		data, _ := io.ReadAll(rc)
+		rc.Close()
+		parts[f.Name] = string(data)
+	}
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/_rels/workbook.xml.rels"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("Expected part %s", name)
+		}
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `<sheet name="Q1_Q2"`) {
+		t.Errorf("Expected the sheet name to be cleaned but got %s", parts["xl/workbook.xml"])
+	}
+
+	sheet := parts["xl/worksheets/sheet1.xml"]
+	for _, want := range []string{
+		`<row r="2"><c r="A2" t="inlineStr"><is><t xml:space="preserve">&lt;b&gt;&amp;</t></is></c>`,
+		`<c r="B2"><v>9.5</v></c>`,
+		`<c r="C2" t="inlineStr"><is><t xml:space="preserve">007</t></is></c>`,
+		`<c r="E2" t="b"><v>1</v></c><c r="F2"><v>3</v></c></row>`,
+		`</sheetData></worksheet>`,
+	} {
+		if !strings.Contains(sheet, want) {
+			t.Errorf("Expected the worksheet to contain %s but got %s", want, sheet)
+		}
+	}
+}
+
+func TestSheetName(t *testing.T) {
+	cases := map[string]string{
+		"Sheet1":                                "Sheet1",
+		"a:b*c?[d]":                             "a_b_c__d_",
+		"":                                      "Sheet1",
+		"A very long sheet name over the limit": "A very long sheet name over the",
+	}
+	for name, want := range cases {
+		if got := SheetName(name); got != want {
+			t.Errorf("SheetName(%q): expected %q but got %q", name, want, got)
+		}
+	}
+}